@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -14,6 +16,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"chatcode/internal/config"
 	"chatcode/internal/domain"
@@ -23,7 +26,11 @@ import (
 	"chatcode/internal/service"
 	"chatcode/internal/session"
 	"chatcode/internal/store"
+	"chatcode/internal/transport/discord"
+	"chatcode/internal/transport/matrix"
+	"chatcode/internal/transport/slack"
 	"chatcode/internal/transport/telegram"
+	"chatcode/internal/transport/terminal"
 	"chatcode/internal/transport/whatsapp"
 
 	cli "github.com/urfave/cli/v2"
@@ -51,6 +58,16 @@ func main() {
 				Usage:  "Run daemon in foreground",
 				Action: runDaemonCommand,
 			},
+			{
+				Name:  "chat",
+				Usage: "Chat with the executors in this terminal, without a bot",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "db", Usage: "SQLite database of the chat (default: chatcode-chat.db beside storage.sqlite_path)"},
+					&cli.StringFlag{Name: "log-file", Usage: "Append logs to this file instead of discarding them"},
+					&cli.BoolFlag{Name: "no-color", Usage: "Print output without ANSI styles"},
+				},
+				Action: runChatCommand,
+			},
 			{
 				Name:   "status",
 				Usage:  "Show runtime and service status",
@@ -102,7 +119,7 @@ func runSetupCommand(c *cli.Context) error {
 	codexBinary := promptString(reader, "executor.codex_binary", firstNonEmpty(lookPathOrEmpty("codex"), "codex"))
 	claudeBinary := promptString(reader, "executor.claude_binary", firstNonEmpty(lookPathOrEmpty("claude"), "claude"))
 	whatsappEnabled := promptString(reader, "whatsapp.enabled", "false")
-	whatsappListen := promptString(reader, "whatsapp.bridge_listen_addr", ":8090")
+	whatsappListen := promptString(reader, "whatsapp.bridge_listen_addr", "127.0.0.1:8090")
 	whatsappSender := promptString(reader, "whatsapp.allowed_sender_id", "your-whatsapp-id")
 
 	allowlist := "codex,claude"
@@ -118,11 +135,38 @@ telegram:
   enabled: %s
   bot_token: "%s"
   allowed_user_id: "%s"
+  allowed_user_ids: ""
+  allowed_chat_ids: ""
+  mode: "polling"
 
 whatsapp:
   enabled: %s
   bridge_listen_addr: "%s"
   allowed_sender_id: "%s"
+  outbound_url: ""
+  shared_secret: ""
+  replay_window: "5m"
+
+slack:
+  enabled: false
+  bot_token: ""
+  signing_secret: ""
+  listen_addr: "127.0.0.1:8091"
+  allowed_user_ids: ""
+  allowed_channel_ids: ""
+
+discord:
+  enabled: false
+  bot_token: ""
+  allowed_user_ids: ""
+  allowed_role_ids: ""
+
+matrix:
+  enabled: false
+  homeserver_url: ""
+  access_token: ""
+  allowed_user_ids: ""
+  allowed_room_ids: ""
 
 executor:
   codex_binary: "%s"
@@ -136,6 +180,12 @@ queue:
 stream:
   batch_interval: "400ms"
   max_chunk_bytes: 3500
+  mode: "batch"
+  reactions: "off"
+
+attachments:
+  dir: ".chatcode/attachments"
+  max_bytes: 20971520
 
 security:
   allowlist_commands: "%s"
@@ -388,6 +438,9 @@ func runDaemon(cfgPath string) error {
 	logger := logging.New()
 	slog.SetDefault(logger)
 	logger.Info("chatcode starting", "config", cfgPath)
+	for _, w := range cfg.Warnings() {
+		logger.Warn("unsafe configuration", "detail", w)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
@@ -398,24 +451,92 @@ func runDaemon(cfgPath string) error {
 	}
 	defer st.Close()
 
-	sm := session.NewManager(st, cfg.Storage.SessionRetention)
-	policy := security.New(cfg.Security.AllowlistCommands, []string{cfg.Security.ProjectRoot})
-
-	execs := map[string]executor.Executor{
-		"codex":  executor.CodexExecutor{Binary: cfg.Executor.CodexBinary, SessionStore: st},
-		"claude": executor.ClaudeExecutor{Binary: cfg.Executor.ClaudeBinary, SessionStore: st},
-	}
 	transports := make(map[domain.Platform]domain.Transport)
 	if cfg.Telegram.Enabled {
-		transports[domain.PlatformTelegram] = telegram.New(cfg.Telegram.BotToken, cfg.Telegram.AllowedUserID)
-		logger.Info("transport registered", "transport", "telegram")
+		opts := []telegram.Option{
+			telegram.WithStateStore(st),
+			telegram.WithAllowedUsers(cfg.Telegram.AllowedUserIDs...),
+			telegram.WithAllowedChats(cfg.Telegram.AllowedChatIDs...),
+			telegram.WithAPIBase(cfg.Telegram.APIBaseURL),
+			telegram.WithTimeouts(cfg.Telegram.RequestTimeout, cfg.Telegram.PollTimeout),
+		}
+		if cfg.Telegram.ProxyURL != "" {
+			// Already validated by config.Load.
+			proxy, _ := url.Parse(cfg.Telegram.ProxyURL)
+			opts = append(opts, telegram.WithProxy(proxy))
+		}
+		if cfg.Telegram.Mode == "webhook" {
+			opts = append(opts, telegram.WithWebhook(cfg.Server.ListenAddr, cfg.Telegram.WebhookURL, cfg.Telegram.WebhookSecret))
+		}
+		transports[domain.PlatformTelegram] = telegram.New(cfg.Telegram.BotToken, cfg.Telegram.AllowedUserID, opts...)
+		logger.Info("transport registered", "transport", "telegram", "mode", cfg.Telegram.Mode)
 	}
 	if cfg.WhatsApp.Enabled {
-		transports[domain.PlatformWhatsApp] = whatsapp.NewWebBridge(cfg.WhatsApp.BridgeListenAddr, cfg.WhatsApp.AllowedSenderID)
+		opts := []whatsapp.Option{
+			whatsapp.WithStateStore(st),
+			whatsapp.WithOutboundURL(cfg.WhatsApp.OutboundURL),
+		}
+		if cfg.WhatsApp.SharedSecret != "" {
+			opts = append(opts, whatsapp.WithSharedSecret(cfg.WhatsApp.SharedSecret, cfg.WhatsApp.ReplayWindow))
+		}
+		transports[domain.PlatformWhatsApp] = whatsapp.NewWebBridge(cfg.WhatsApp.BridgeListenAddr, cfg.WhatsApp.AllowedSenderID, opts...)
 		logger.Info("transport registered", "transport", "whatsapp", "listen_addr", cfg.WhatsApp.BridgeListenAddr)
 	}
+	if cfg.Slack.Enabled {
+		transports[domain.PlatformSlack] = slack.New(cfg.Slack.BotToken, cfg.Slack.SigningSecret, cfg.Slack.ListenAddr,
+			slack.WithAllowedUsers(cfg.Slack.AllowedUserIDs...),
+			slack.WithAllowedChannels(cfg.Slack.AllowedChannelIDs...),
+			slack.WithAPIBase(cfg.Slack.APIBaseURL),
+		)
+		logger.Info("transport registered", "transport", "slack", "listen_addr", cfg.Slack.ListenAddr)
+	}
+	if cfg.Discord.Enabled {
+		transports[domain.PlatformDiscord] = discord.New(cfg.Discord.BotToken,
+			discord.WithAllowedUsers(cfg.Discord.AllowedUserIDs...),
+			discord.WithAllowedRoles(cfg.Discord.AllowedRoleIDs...),
+			discord.WithAPIBase(cfg.Discord.APIBaseURL),
+			discord.WithGatewayURL(cfg.Discord.GatewayURL),
+		)
+		logger.Info("transport registered", "transport", "discord")
+	}
+	if cfg.Matrix.Enabled {
+		transports[domain.PlatformMatrix] = matrix.New(cfg.Matrix.HomeserverURL, cfg.Matrix.AccessToken,
+			matrix.WithStateStore(st),
+			matrix.WithAllowedUsers(cfg.Matrix.AllowedUserIDs...),
+			matrix.WithAllowedRooms(cfg.Matrix.AllowedRoomIDs...),
+			matrix.WithSyncTimeout(cfg.Matrix.SyncTimeout),
+		)
+		logger.Info("transport registered", "transport", "matrix", "homeserver", cfg.Matrix.HomeserverURL)
+	}
+
+	orch := newOrchestrator(ctx, cfg, st, transports)
+	if err := orch.Recover(ctx); err != nil {
+		logger.Error("job queue recovery failed", "error", err)
+	}
 
-	orch := service.NewOrchestrator(
+	for _, t := range transports {
+		go func(tp domain.Transport) {
+			logger.Info("transport starting", "transport", tp.Name())
+			if err := tp.Start(ctx, orch.HandleIncomingMessage); err != nil && ctx.Err() == nil {
+				logger.Error("transport stopped with error", "transport", tp.Name(), "error", err)
+			}
+		}(t)
+	}
+
+	<-ctx.Done()
+	logger.Info("chatcode stopped")
+	return nil
+}
+
+func newOrchestrator(ctx context.Context, cfg config.Config, st *store.SQLiteStore, transports map[domain.Platform]domain.Transport) *service.Orchestrator {
+	sm := session.NewManager(st, cfg.Storage.SessionRetention)
+	go sm.RunSweeper(ctx, time.Hour)
+	policy := security.New(cfg.Security.AllowlistCommands, []string{cfg.Security.ProjectRoot})
+	execs := map[string]executor.Executor{
+		"codex":  executor.CodexExecutor{Binary: cfg.Executor.CodexBinary, SessionStore: st},
+		"claude": executor.ClaudeExecutor{Binary: cfg.Executor.ClaudeBinary, SessionStore: st},
+	}
+	return service.NewOrchestrator(
 		ctx,
 		st,
 		sm,
@@ -427,22 +548,74 @@ func runDaemon(cfgPath string) error {
 		cfg.Queue.PerSessionBuffer,
 		cfg.Stream.BatchInterval,
 		cfg.Stream.MaxChunkBytes,
+		service.WithStreamMode(cfg.Stream.Mode),
+		service.WithStatusReactions(cfg.Stream.Reactions),
+		service.WithAttachments(cfg.Attachments.Dir, cfg.Attachments.MaxBytes),
 	)
+}
 
-	for _, t := range transports {
-		go func(tp domain.Transport) {
-			logger.Info("transport starting", "transport", tp.Name())
-			if err := tp.Start(ctx, orch.HandleIncomingMessage); err != nil && ctx.Err() == nil {
-				logger.Error("transport stopped with error", "transport", tp.Name(), "error", err)
-			}
-		}(t)
+// runChatCommand runs the orchestrator with the terminal as its only
+// transport. The chat keeps its own database: recovering the job queue
+// would otherwise pick up jobs queued by the daemon for its chats.
+func runChatCommand(c *cli.Context) error {
+	cfgPath, err := resolveConfigPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+	var logOut io.Writer = io.Discard
+	if path := c.String("log-file"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		defer f.Close()
+		logOut = f
 	}
+	slog.SetDefault(logging.NewTo(logOut))
 
-	<-ctx.Done()
-	logger.Info("chatcode stopped")
+	dbPath := c.String("db")
+	if dbPath == "" {
+		dbPath = filepath.Join(filepath.Dir(cfg.Storage.SQLitePath), "chatcode-chat.db")
+	}
+	st, err := store.NewSQLiteStore(dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	console := terminal.New(os.Stdin, os.Stdout,
+		terminal.WithColor(!c.Bool("no-color") && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)),
+		terminal.WithSender(os.Getenv("USER")),
+	)
+	orch := newOrchestrator(ctx, cfg, st, map[domain.Platform]domain.Transport{domain.PlatformTerminal: console})
+	if err := orch.Recover(ctx); err != nil {
+		slog.Error("job queue recovery failed", "error", err)
+	}
+	fmt.Printf("chatcode %s: send prompts and /commands, one per line; Ctrl-D exits\n", version)
+	if err := console.Start(ctx, orch.HandleIncomingMessage); err != nil {
+		return err
+	}
+	// Input ended, e.g. a script piped into the chat: let its jobs finish
+	// before closing the store. Ctrl-C stops them instead.
+	if ctx.Err() == nil && !orch.Idle() {
+		fmt.Println("waiting for jobs to finish; Ctrl-C stops them")
+		_ = orch.Wait(ctx)
+	}
 	return nil
 }
 
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func resolveConfigPath() (string, error) {
 	return expandHome("~/.chatcode/config.yaml")
 }
@@ -4,21 +4,28 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Telegram TelegramConfig
-	WhatsApp WhatsAppConfig
-	Executor ExecutorConfig
-	Queue    QueueConfig
-	Stream   StreamConfig
-	Security SecurityConfig
-	Storage  StorageConfig
+	Server      ServerConfig
+	Telegram    TelegramConfig
+	WhatsApp    WhatsAppConfig
+	Slack       SlackConfig
+	Discord     DiscordConfig
+	Matrix      MatrixConfig
+	Executor    ExecutorConfig
+	Queue       QueueConfig
+	Stream      StreamConfig
+	Attachments AttachmentsConfig
+	Security    SecurityConfig
+	Storage     StorageConfig
 }
 
 type ServerConfig struct {
@@ -29,13 +36,76 @@ type ServerConfig struct {
 type TelegramConfig struct {
 	BotToken      string
 	AllowedUserID string
-	Enabled       bool
+	// AllowedUserIDs may use the bot in any chat; every member of a chat in
+	// AllowedChatIDs may use it there. In groups the bot only answers
+	// commands, mentions and replies to its own messages.
+	AllowedUserIDs []string
+	AllowedChatIDs []string
+	Enabled        bool
+	// Mode is "polling" (getUpdates) or "webhook". Webhooks are served on
+	// server.listen_addr at the path of WebhookURL.
+	Mode          string
+	WebhookURL    string
+	WebhookSecret string
+	// APIBaseURL, ProxyURL and the timeouts tune the Bot API client, e.g.
+	// for a self-hosted Bot API server.
+	APIBaseURL     string
+	ProxyURL       string
+	RequestTimeout time.Duration
+	PollTimeout    time.Duration
 }
 
 type WhatsAppConfig struct {
 	BridgeListenAddr string
 	AllowedSenderID  string
 	Enabled          bool
+	// SharedSecret signs requests between the daemon and the bridge; leaving
+	// it empty is only safe when the bridge endpoints listen on a loopback
+	// address. Signed requests older than ReplayWindow are rejected.
+	SharedSecret string
+	ReplayWindow time.Duration
+	// OutboundURL is where outbound messages are posted until the bridge
+	// registers a URL itself; registered URLs are persisted.
+	OutboundURL string
+}
+
+// SlackConfig configures the Slack app. ListenAddr serves the Events API,
+// slash command and interactivity request URLs, which Slack must be able to
+// reach, e.g. through a reverse proxy; requests are verified with
+// SigningSecret.
+type SlackConfig struct {
+	Enabled           bool
+	BotToken          string
+	SigningSecret     string
+	ListenAddr        string
+	AllowedUserIDs    []string
+	AllowedChannelIDs []string
+	APIBaseURL        string
+}
+
+// DiscordConfig configures the Discord bot. Users in AllowedUserIDs and
+// guild members holding a role in AllowedRoleIDs may use it. The API and
+// gateway URLs can point at a local stand-in.
+type DiscordConfig struct {
+	Enabled        bool
+	BotToken       string
+	AllowedUserIDs []string
+	AllowedRoleIDs []string
+	APIBaseURL     string
+	GatewayURL     string
+}
+
+// MatrixConfig configures the Matrix client. It logs in with the access
+// token of an existing account on HomeserverURL and joins rooms it is
+// invited to by users in AllowedUserIDs. Users in AllowedUserIDs may use it
+// in any room, every member may use it in rooms in AllowedRoomIDs.
+type MatrixConfig struct {
+	Enabled        bool
+	HomeserverURL  string
+	AccessToken    string
+	AllowedUserIDs []string
+	AllowedRoomIDs []string
+	SyncTimeout    time.Duration
 }
 
 type ExecutorConfig struct {
@@ -52,6 +122,16 @@ type QueueConfig struct {
 type StreamConfig struct {
 	BatchInterval time.Duration
 	MaxChunkBytes int
+	Mode          string
+	// Reactions is off, on or only; see service.WithStatusReactions.
+	Reactions string
+}
+
+// AttachmentsConfig controls where inbound files are saved. Dir is relative
+// to the session workdir.
+type AttachmentsConfig struct {
+	Dir      string
+	MaxBytes int64
 }
 
 type SecurityConfig struct {
@@ -67,18 +147,31 @@ type StorageConfig struct {
 
 func Default() Config {
 	return Config{
-		Server:   ServerConfig{ListenAddr: ":8080", Timezone: "UTC"},
-		Telegram: TelegramConfig{Enabled: false},
-		WhatsApp: WhatsAppConfig{BridgeListenAddr: ":8090", Enabled: false},
+		Server: ServerConfig{ListenAddr: ":8080", Timezone: "UTC"},
+		Telegram: TelegramConfig{
+			Enabled:        false,
+			Mode:           "polling",
+			APIBaseURL:     "https://api.telegram.org",
+			RequestTimeout: 30 * time.Second,
+			PollTimeout:    20 * time.Second,
+		},
+		WhatsApp: WhatsAppConfig{BridgeListenAddr: "127.0.0.1:8090", Enabled: false, ReplayWindow: 5 * time.Minute},
+		Slack:    SlackConfig{ListenAddr: "127.0.0.1:8091", APIBaseURL: "https://slack.com/api"},
+		Discord: DiscordConfig{
+			APIBaseURL: "https://discord.com/api/v10",
+			GatewayURL: "wss://gateway.discord.gg/?v=10&encoding=json",
+		},
+		Matrix: MatrixConfig{SyncTimeout: 30 * time.Second},
 		Executor: ExecutorConfig{
 			CodexBinary:  "codex",
 			ClaudeBinary: "claude",
 			Timeout:      30 * time.Minute,
 		},
-		Queue:    QueueConfig{MaxConcurrentSessions: 8, PerSessionBuffer: 64},
-		Stream:   StreamConfig{BatchInterval: 400 * time.Millisecond, MaxChunkBytes: 3500},
-		Security: SecurityConfig{},
-		Storage:  StorageConfig{SQLitePath: "chatcode.db", SessionRetention: 7 * 24 * time.Hour},
+		Queue:       QueueConfig{MaxConcurrentSessions: 8, PerSessionBuffer: 64},
+		Stream:      StreamConfig{BatchInterval: 400 * time.Millisecond, MaxChunkBytes: 3500, Mode: "batch", Reactions: "off"},
+		Attachments: AttachmentsConfig{Dir: ".chatcode/attachments", MaxBytes: 20 << 20},
+		Security:    SecurityConfig{},
+		Storage:     StorageConfig{SQLitePath: "chatcode.db", SessionRetention: 7 * 24 * time.Hour},
 	}
 }
 
@@ -100,12 +193,104 @@ func (c Config) Validate() error {
 	if c.Telegram.Enabled && c.Telegram.BotToken == "" {
 		return errors.New("telegram.bot_token is required when telegram.enabled=true")
 	}
+	switch c.Telegram.Mode {
+	case "polling":
+	case "webhook":
+		if c.Telegram.Enabled && (c.Telegram.WebhookURL == "" || c.Telegram.WebhookSecret == "") {
+			return errors.New("telegram.webhook_url and telegram.webhook_secret are required when telegram.mode=webhook")
+		}
+		if c.Telegram.Enabled && c.Server.ListenAddr == "" {
+			return errors.New("server.listen_addr is required when telegram.mode=webhook")
+		}
+	default:
+		return fmt.Errorf("telegram.mode must be polling or webhook: got %q", c.Telegram.Mode)
+	}
+	if u, err := url.Parse(c.Telegram.APIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("telegram.api_base_url must be an http(s) URL: got %q", c.Telegram.APIBaseURL)
+	}
+	if c.Telegram.ProxyURL != "" {
+		u, err := url.Parse(c.Telegram.ProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("telegram.proxy_url is invalid: got %q", c.Telegram.ProxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("telegram.proxy_url scheme must be http, https or socks5: got %q", u.Scheme)
+		}
+	}
+	if c.Telegram.RequestTimeout <= 0 || c.Telegram.PollTimeout <= 0 {
+		return errors.New("telegram.request_timeout and telegram.poll_timeout must be > 0")
+	}
+	if c.WhatsApp.Enabled {
+		if c.WhatsApp.ReplayWindow <= 0 {
+			return errors.New("whatsapp.replay_window must be > 0")
+		}
+		if c.WhatsApp.OutboundURL != "" {
+			if u, err := url.Parse(c.WhatsApp.OutboundURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("whatsapp.outbound_url must be an http(s) URL: got %q", c.WhatsApp.OutboundURL)
+			}
+		}
+	}
+	if c.Slack.Enabled {
+		if c.Slack.BotToken == "" || c.Slack.SigningSecret == "" {
+			return errors.New("slack.bot_token and slack.signing_secret are required when slack.enabled=true")
+		}
+		if len(c.Slack.AllowedUserIDs) == 0 && len(c.Slack.AllowedChannelIDs) == 0 {
+			return errors.New("slack.allowed_user_ids or slack.allowed_channel_ids is required when slack.enabled=true")
+		}
+		if u, err := url.Parse(c.Slack.APIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("slack.api_base_url must be an http(s) URL: got %q", c.Slack.APIBaseURL)
+		}
+	}
+	if c.Discord.Enabled {
+		if c.Discord.BotToken == "" {
+			return errors.New("discord.bot_token is required when discord.enabled=true")
+		}
+		if len(c.Discord.AllowedUserIDs) == 0 && len(c.Discord.AllowedRoleIDs) == 0 {
+			return errors.New("discord.allowed_user_ids or discord.allowed_role_ids is required when discord.enabled=true")
+		}
+		if u, err := url.Parse(c.Discord.APIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("discord.api_base_url must be an http(s) URL: got %q", c.Discord.APIBaseURL)
+		}
+		if u, err := url.Parse(c.Discord.GatewayURL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("discord.gateway_url must be a ws(s) URL: got %q", c.Discord.GatewayURL)
+		}
+	}
+	if c.Matrix.Enabled {
+		if c.Matrix.AccessToken == "" {
+			return errors.New("matrix.access_token is required when matrix.enabled=true")
+		}
+		if len(c.Matrix.AllowedUserIDs) == 0 && len(c.Matrix.AllowedRoomIDs) == 0 {
+			return errors.New("matrix.allowed_user_ids or matrix.allowed_room_ids is required when matrix.enabled=true")
+		}
+		if u, err := url.Parse(c.Matrix.HomeserverURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("matrix.homeserver_url must be an http(s) URL: got %q", c.Matrix.HomeserverURL)
+		}
+		if c.Matrix.SyncTimeout <= 0 {
+			return fmt.Errorf("matrix.sync_timeout must be positive: got %s", c.Matrix.SyncTimeout)
+		}
+	}
 	if c.Storage.SQLitePath == "" {
 		return errors.New("storage.sqlite_path is required")
 	}
 	if c.Stream.BatchInterval < 300*time.Millisecond || c.Stream.BatchInterval > 500*time.Millisecond {
 		return fmt.Errorf("stream.batch_interval must be between 300ms and 500ms: got %s", c.Stream.BatchInterval)
 	}
+	if c.Stream.Mode != "batch" && c.Stream.Mode != "edit" {
+		return fmt.Errorf("stream.mode must be batch or edit: got %q", c.Stream.Mode)
+	}
+	switch c.Stream.Reactions {
+	case "off", "on", "only":
+	default:
+		return fmt.Errorf("stream.reactions must be off, on or only: got %q", c.Stream.Reactions)
+	}
+	if !filepath.IsLocal(c.Attachments.Dir) {
+		return fmt.Errorf("attachments.dir must be a relative path inside the workdir: got %q", c.Attachments.Dir)
+	}
+	if c.Attachments.MaxBytes <= 0 {
+		return errors.New("attachments.max_bytes must be > 0")
+	}
 	if c.Queue.MaxConcurrentSessions <= 0 {
 		return errors.New("queue.max_concurrent_sessions must be > 0")
 	}
@@ -167,12 +352,90 @@ func applyKV(cfg *Config, section, key, val string) error {
 		cfg.Telegram.BotToken = val
 	case "telegram.allowed_user_id":
 		cfg.Telegram.AllowedUserID = val
+	case "telegram.allowed_user_ids":
+		cfg.Telegram.AllowedUserIDs = splitCSV(val)
+	case "telegram.allowed_chat_ids":
+		cfg.Telegram.AllowedChatIDs = splitCSV(val)
+	case "telegram.mode":
+		cfg.Telegram.Mode = val
+	case "telegram.webhook_url":
+		cfg.Telegram.WebhookURL = val
+	case "telegram.webhook_secret":
+		cfg.Telegram.WebhookSecret = val
+	case "telegram.api_base_url":
+		cfg.Telegram.APIBaseURL = val
+	case "telegram.proxy_url":
+		cfg.Telegram.ProxyURL = val
+	case "telegram.request_timeout":
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("telegram.request_timeout: %w", err)
+		}
+		cfg.Telegram.RequestTimeout = d
+	case "telegram.poll_timeout":
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("telegram.poll_timeout: %w", err)
+		}
+		cfg.Telegram.PollTimeout = d
 	case "whatsapp.enabled":
 		cfg.WhatsApp.Enabled = val == "true"
 	case "whatsapp.bridge_listen_addr":
 		cfg.WhatsApp.BridgeListenAddr = val
 	case "whatsapp.allowed_sender_id":
 		cfg.WhatsApp.AllowedSenderID = val
+	case "whatsapp.outbound_url":
+		cfg.WhatsApp.OutboundURL = val
+	case "whatsapp.shared_secret":
+		cfg.WhatsApp.SharedSecret = val
+	case "whatsapp.replay_window":
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("whatsapp.replay_window: %w", err)
+		}
+		cfg.WhatsApp.ReplayWindow = d
+	case "slack.enabled":
+		cfg.Slack.Enabled = val == "true"
+	case "slack.bot_token":
+		cfg.Slack.BotToken = val
+	case "slack.signing_secret":
+		cfg.Slack.SigningSecret = val
+	case "slack.listen_addr":
+		cfg.Slack.ListenAddr = val
+	case "slack.allowed_user_ids":
+		cfg.Slack.AllowedUserIDs = splitCSV(val)
+	case "slack.allowed_channel_ids":
+		cfg.Slack.AllowedChannelIDs = splitCSV(val)
+	case "slack.api_base_url":
+		cfg.Slack.APIBaseURL = val
+	case "discord.enabled":
+		cfg.Discord.Enabled = val == "true"
+	case "discord.bot_token":
+		cfg.Discord.BotToken = val
+	case "discord.allowed_user_ids":
+		cfg.Discord.AllowedUserIDs = splitCSV(val)
+	case "discord.allowed_role_ids":
+		cfg.Discord.AllowedRoleIDs = splitCSV(val)
+	case "discord.api_base_url":
+		cfg.Discord.APIBaseURL = val
+	case "discord.gateway_url":
+		cfg.Discord.GatewayURL = val
+	case "matrix.enabled":
+		cfg.Matrix.Enabled = val == "true"
+	case "matrix.homeserver_url":
+		cfg.Matrix.HomeserverURL = val
+	case "matrix.access_token":
+		cfg.Matrix.AccessToken = val
+	case "matrix.allowed_user_ids":
+		cfg.Matrix.AllowedUserIDs = splitCSV(val)
+	case "matrix.allowed_room_ids":
+		cfg.Matrix.AllowedRoomIDs = splitCSV(val)
+	case "matrix.sync_timeout":
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("matrix.sync_timeout: %w", err)
+		}
+		cfg.Matrix.SyncTimeout = d
 	case "executor.codex_binary":
 		cfg.Executor.CodexBinary = val
 	case "executor.claude_binary":
@@ -207,6 +470,18 @@ func applyKV(cfg *Config, section, key, val string) error {
 			return fmt.Errorf("stream.max_chunk_bytes: %w", err)
 		}
 		cfg.Stream.MaxChunkBytes = n
+	case "stream.mode":
+		cfg.Stream.Mode = val
+	case "stream.reactions":
+		cfg.Stream.Reactions = val
+	case "attachments.dir":
+		cfg.Attachments.Dir = val
+	case "attachments.max_bytes":
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("attachments.max_bytes: %w", err)
+		}
+		cfg.Attachments.MaxBytes = n
 	case "security.allowlist_commands":
 		cfg.Security.AllowlistCommands = splitCSV(val)
 	case "security.project_root":
@@ -225,6 +500,30 @@ func applyKV(cfg *Config, section, key, val string) error {
 	return nil
 }
 
+// Warnings returns settings that are valid but unsafe, to be logged at
+// startup.
+func (c Config) Warnings() []string {
+	var warnings []string
+	if c.WhatsApp.Enabled && c.WhatsApp.SharedSecret == "" && !isLoopbackAddr(c.WhatsApp.BridgeListenAddr) {
+		warnings = append(warnings, fmt.Sprintf("whatsapp.bridge_listen_addr %q is not a loopback address and whatsapp.shared_secret is empty: anyone who can reach it can send prompts", c.WhatsApp.BridgeListenAddr))
+	}
+	return warnings
+}
+
+// isLoopbackAddr reports whether a listen address only accepts local
+// connections. An empty host listens on every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func splitCSV(v string) []string {
 	items := strings.Split(v, ",")
 	out := make([]string, 0, len(items))
@@ -241,7 +540,25 @@ func overrideEnv(cfg *Config) {
 	if v := os.Getenv("CHATBRIDGE_TELEGRAM_TOKEN"); v != "" {
 		cfg.Telegram.BotToken = v
 	}
+	if v := os.Getenv("CHATBRIDGE_TELEGRAM_WEBHOOK_SECRET"); v != "" {
+		cfg.Telegram.WebhookSecret = v
+	}
 	if v := os.Getenv("CHATBRIDGE_WHATSAPP_ALLOWED_SENDER"); v != "" {
 		cfg.WhatsApp.AllowedSenderID = v
 	}
+	if v := os.Getenv("CHATBRIDGE_WHATSAPP_SHARED_SECRET"); v != "" {
+		cfg.WhatsApp.SharedSecret = v
+	}
+	if v := os.Getenv("CHATBRIDGE_SLACK_BOT_TOKEN"); v != "" {
+		cfg.Slack.BotToken = v
+	}
+	if v := os.Getenv("CHATBRIDGE_SLACK_SIGNING_SECRET"); v != "" {
+		cfg.Slack.SigningSecret = v
+	}
+	if v := os.Getenv("CHATBRIDGE_DISCORD_TOKEN"); v != "" {
+		cfg.Discord.BotToken = v
+	}
+	if v := os.Getenv("CHATBRIDGE_MATRIX_ACCESS_TOKEN"); v != "" {
+		cfg.Matrix.AccessToken = v
+	}
 }
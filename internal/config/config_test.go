@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+const baseConfig = `
+security:
+  allowlist_commands: codex,claude
+  project_root: /tmp
+whatsapp:
+  enabled: true
+  allowed_sender_id: me
+`
+
+func TestLoadWarnsAboutUnsignedWhatsAppBridgeOffLoopback(t *testing.T) {
+	t.Setenv("CHATBRIDGE_WHATSAPP_SHARED_SECRET", "")
+	cases := []struct {
+		name  string
+		extra string
+		warn  bool
+	}{
+		{"default loopback", "", false},
+		{"localhost", "  bridge_listen_addr: localhost:8090\n", false},
+		{"all interfaces", "  bridge_listen_addr: 0.0.0.0:8090\n", true},
+		{"empty host", "  bridge_listen_addr: :8090\n", true},
+		{"signed", "  bridge_listen_addr: 0.0.0.0:8090\n  shared_secret: s3cret\n", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, baseConfig+tc.extra))
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			warnings := cfg.Warnings()
+			if got := len(warnings) > 0; got != tc.warn {
+				t.Fatalf("expected warning %v, got %q", tc.warn, warnings)
+			}
+			if tc.warn && !strings.Contains(warnings[0], "whatsapp.shared_secret") {
+				t.Fatalf("unexpected warning %q", warnings[0])
+			}
+		})
+	}
+}
+
+func TestLoadReadsWhatsAppSettings(t *testing.T) {
+	t.Setenv("CHATBRIDGE_WHATSAPP_SHARED_SECRET", "from-env")
+	cfg, err := Load(writeConfig(t, baseConfig+"  replay_window: 30s\n  outbound_url: http://127.0.0.1:9000/send\n"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	w := cfg.WhatsApp
+	if w.ReplayWindow != 30*time.Second || w.OutboundURL != "http://127.0.0.1:9000/send" || w.SharedSecret != "from-env" {
+		t.Fatalf("unexpected whatsapp config %+v", w)
+	}
+}
+
+func TestValidateRejectsInvalidWhatsAppSettings(t *testing.T) {
+	for name, mutate := range map[string]func(*Config){
+		"replay window": func(c *Config) { c.WhatsApp.ReplayWindow = 0 },
+		"outbound url":  func(c *Config) { c.WhatsApp.OutboundURL = "127.0.0.1:9000" },
+	} {
+		cfg := Default()
+		cfg.Security.AllowlistCommands = []string{"codex"}
+		cfg.Security.ProjectRoot = "/tmp"
+		cfg.WhatsApp.Enabled = true
+		mutate(&cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "whatsapp.") {
+			t.Fatalf("%s: expected whatsapp validation error, got %v", name, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"io"
 	"strings"
 	"time"
 )
@@ -11,6 +12,10 @@ type Platform string
 const (
 	PlatformTelegram Platform = "telegram"
 	PlatformWhatsApp Platform = "whatsapp"
+	PlatformSlack    Platform = "slack"
+	PlatformDiscord  Platform = "discord"
+	PlatformMatrix   Platform = "matrix"
+	PlatformTerminal Platform = "terminal"
 )
 
 type SessionKey struct {
@@ -30,20 +35,92 @@ type Message struct {
 	SessionKey SessionKey
 	SenderID   string
 	Text       string
-	Meta       InboundMessageMeta
-	At         time.Time
+	// Action is set instead of Text when the user pressed a button attached
+	// to an earlier outbound message.
+	Action *Action
+	// Attachments are files sent with the message; Text then holds the
+	// caption, if any.
+	Attachments []Attachment
+	Meta        InboundMessageMeta
+	At          time.Time
 }
 
+const (
+	AttachmentDocument = "document"
+	AttachmentPhoto    = "photo"
+)
+
+// Attachment describes an inbound file. Its content is downloaded through
+// the transport's AttachmentFetcher.
+type Attachment struct {
+	Kind     string
+	FileID   string
+	FileName string
+	MIMEType string
+	Size     int64
+}
+
+// Action is a control attached to an outbound message, such as an inline
+// button. Transports deliver a pressed action back as Message.Action with the
+// same Name and Data; Label is only used for display.
+type Action struct {
+	Name  string
+	Label string
+	Data  string
+}
+
+const (
+	ActionStopJob    = "stop"
+	ActionRetryJob   = "retry"
+	ActionShowLog    = "log"
+	ActionTranscript = "transcript"
+	// ActionRunEdited stops the job if it is still active and runs it
+	// again with the prompt from an edited message.
+	ActionRunEdited = "rerun"
+)
+
 type InboundMessageMeta struct {
+	// MessageID is the platform's ID for this message, unique within the
+	// chat. It is used to drop redelivered messages.
+	MessageID string
+	// ReplyToMessageID is the message that replies to this one should be
+	// threaded under, usually MessageID itself.
 	ReplyToMessageID string
-	Raw              map[string]string
+	// QuotedMessageID is the earlier message this one is a reply to, if
+	// any.
+	QuotedMessageID string
+	// EditID is set when the message is an edit of the message MessageID
+	// and tells successive edits apart.
+	EditID string
+	// Forum is set when the chat organizes threads as named topics that
+	// can be created through a TopicCreator.
+	Forum bool
+	// TopicName is the name of the topic the message was posted in, when
+	// the transport knows it.
+	TopicName string
+	Raw       map[string]string
 }
 
 type OutboundMessage struct {
 	SessionKey SessionKey
 	Text       string
 	Format     string
-	Meta       map[string]string
+	Actions    []Action
+	// ReplyToMessageID threads the message under an earlier message of the
+	// same chat. Transports without replies ignore it.
+	ReplyToMessageID string
+	Meta             map[string]string
+}
+
+// OutboundFile is a document uploaded to a chat, used for output too long
+// to read comfortably as messages.
+type OutboundFile struct {
+	SessionKey SessionKey
+	Name       string
+	Content    []byte
+	// Caption is plain text shown with the file.
+	Caption string
+	Actions []Action
 }
 
 type JobStatus string
@@ -54,38 +131,94 @@ const (
 	JobDone    JobStatus = "done"
 	JobFailed  JobStatus = "failed"
 	JobStopped JobStatus = "stopped"
+	// JobInterrupted marks a job that was running when the daemon exited.
+	JobInterrupted JobStatus = "interrupted"
 )
 
 type Job struct {
-	ID             string
-	SessionKey     SessionKey
+	ID         string
+	SessionKey SessionKey
+	// SenderID is the user who started the job.
+	SenderID       string
 	Executor       string
 	PermissionMode string
 	Session        string
 	Prompt         string
-	Workdir        string
-	Status         JobStatus
-	CreatedAt      time.Time
-	StartedAt      *time.Time
-	FinishedAt     *time.Time
-	ErrorMessage   string
+	// Images are absolute paths of inbound photos, passed to executors that
+	// accept image input.
+	Images []string
+	// ReplyToMessageID is the inbound message that started the job; the
+	// job's messages are threaded under it.
+	ReplyToMessageID string
+	Workdir          string
+	Status           JobStatus
+	CreatedAt        time.Time
+	StartedAt        *time.Time
+	FinishedAt       *time.Time
+	ErrorMessage     string
 }
 
+// EventKindCommand marks an event holding a command the executor ran and
+// its output.
+const EventKindCommand = "command"
+
 type StreamEvent struct {
-	JobID    string
-	Seq      int64
-	Chunk    string
-	Format   string
-	IsFinal  bool
-	TS       time.Time
-	Stream   string
+	JobID   string
+	Seq     int64
+	Chunk   string
+	Format  string
+	IsFinal bool
+	TS      time.Time
+	Stream  string
+	// Kind is set by executors for events that are not plain output, e.g.
+	// EventKindCommand.
+	Kind     string
 	ExitCode *int
 }
 
+// MessageRef identifies a message delivered by a transport. ID is empty when
+// the platform does not report one.
+type MessageRef struct {
+	SessionKey SessionKey
+	ID         string
+}
+
 type Transport interface {
 	Name() string
 	Start(context.Context, MessageHandler) error
-	Send(context.Context, OutboundMessage) error
+	Send(context.Context, OutboundMessage) (MessageRef, error)
+}
+
+// MessageEditor is optional. Transports that can replace the content of a
+// message they already sent implement it to support live progress messages.
+type MessageEditor interface {
+	Edit(context.Context, MessageRef, OutboundMessage) error
+}
+
+// FileSender is optional. Transports that can upload documents implement it
+// so long output is sent as a file instead of many messages.
+type FileSender interface {
+	SendFile(context.Context, OutboundFile) (MessageRef, error)
+}
+
+// MessageReactor is optional. Transports that can put an emoji reaction on
+// a message implement it so job status can be shown on the prompt itself.
+// An empty emoji removes the reaction.
+type MessageReactor interface {
+	React(ctx context.Context, ref MessageRef, emoji string) error
+}
+
+// TopicCreator is optional. Transports whose chats can hold named topics
+// implement it so a project can get a thread of its own. The returned key
+// addresses the new topic.
+type TopicCreator interface {
+	CreateTopic(ctx context.Context, key SessionKey, name string) (SessionKey, error)
+}
+
+// AttachmentFetcher is optional. Transports that receive files implement it
+// so attachments can be saved into the session workdir.
+type AttachmentFetcher interface {
+	FetchAttachment(context.Context, Attachment) (io.ReadCloser, error)
 }
 
 type MessageHandler func(context.Context, Message) error
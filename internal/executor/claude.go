@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"chatcode/internal/domain"
+	"chatcode/internal/render"
 )
 
 type ClaudeExecutor struct {
@@ -102,24 +103,15 @@ func parseClaudeJSONEvent(chunk string) (sessionID, text, format string, ok bool
 	return "", "", "", true
 }
 
+// extractClaudeMessageText renders an assistant message as HTML: text blocks
+// are Markdown and are converted, tool calls are summarized.
 func extractClaudeMessageText(msg *claudeJSONMessage) (text, format string) {
-	hasHTML := false
-	for _, block := range msg.Content {
-		if block.Type == "tool_use" {
-			hasHTML = true
-			break
-		}
-	}
 	var parts []string
 	for _, block := range msg.Content {
 		switch block.Type {
 		case "text":
 			if t := strings.TrimSpace(block.Text); t != "" {
-				if hasHTML {
-					parts = append(parts, html.EscapeString(t))
-				} else {
-					parts = append(parts, t)
-				}
+				parts = append(parts, render.MarkdownToHTML(t))
 			}
 		case "tool_use":
 			if s := formatClaudeToolUse(block.Name, block.Input); s != "" {
@@ -128,13 +120,13 @@ func extractClaudeMessageText(msg *claudeJSONMessage) (text, format string) {
 		}
 	}
 	result := strings.Join(parts, "\n")
-	if result != "" && !strings.HasSuffix(result, "\n") {
-		result += "\n"
+	if result == "" {
+		return "", ""
 	}
-	if hasHTML {
-		return result, "html"
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
 	}
-	return result, ""
+	return result, "html"
 }
 
 func formatClaudeToolUse(name string, input json.RawMessage) string {
@@ -106,13 +106,16 @@ func TestClaudeHandleEventToolUse(t *testing.T) {
 	}
 }
 
-func TestClaudeHandleEventTextNoFormat(t *testing.T) {
+func TestClaudeHandleEventTextRendersMarkdown(t *testing.T) {
 	ex := ClaudeExecutor{}
-	chunk := `{"type":"assistant","message":{"content":[{"type":"text","text":"hello"}]}}`
+	chunk := `{"type":"assistant","message":{"content":[{"type":"text","text":"**done**: see ` + "`a<b`" + `"}]}}`
 	ev := &domain.StreamEvent{Chunk: chunk, Stream: "stdout"}
 	ex.HandleEvent(ev)
-	if ev.Format != "" {
-		t.Fatalf("expected empty format for plain text, got %q", ev.Format)
+	if ev.Format != "html" {
+		t.Fatalf("expected format=html, got %q", ev.Format)
+	}
+	if ev.Chunk != "<b>done</b>: see <code>a&lt;b</code>\n" {
+		t.Fatalf("unexpected chunk: %q", ev.Chunk)
 	}
 }
 
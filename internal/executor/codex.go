@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"chatcode/internal/domain"
+	"chatcode/internal/render"
 )
 
 type CodexExecutor struct {
@@ -32,21 +33,7 @@ func (e CodexExecutor) BuildCommand(_ context.Context, job domain.Job) ([]string
 	if domain.NormalizePermissionMode(job.PermissionMode) == domain.PermissionModeFullAccess {
 		sandboxMode = "danger-full-access"
 	}
-	if job.Session != "" {
-		return []string{
-			e.Binary,
-			"--full-auto",
-			"--sandbox",
-			sandboxMode,
-			"exec",
-			"--json",
-			"--skip-git-repo-check",
-			"resume",
-			job.Session,
-			job.Prompt,
-		}, nil
-	}
-	return []string{
+	args := []string{
 		e.Binary,
 		"--full-auto",
 		"--sandbox",
@@ -54,8 +41,16 @@ func (e CodexExecutor) BuildCommand(_ context.Context, job domain.Job) ([]string
 		"exec",
 		"--json",
 		"--skip-git-repo-check",
-		job.Prompt,
-	}, nil
+	}
+	// --image takes several values; the "=" form keeps it from consuming
+	// the arguments that follow.
+	for _, img := range job.Images {
+		args = append(args, "--image="+img)
+	}
+	if job.Session != "" {
+		args = append(args, "resume", job.Session)
+	}
+	return append(args, job.Prompt), nil
 }
 
 func (e CodexExecutor) LoadSession(ctx context.Context, job domain.Job) (string, error) {
@@ -80,10 +75,11 @@ func (e CodexExecutor) SaveSession(ctx context.Context, job domain.Job, sessionI
 }
 
 func (e CodexExecutor) HandleEvent(ev *domain.StreamEvent) string {
-	sessionID, text, format, ok := parseCodexJSONEvent(ev.Chunk)
+	sessionID, text, format, kind, ok := parseCodexJSONEvent(ev.Chunk)
 	if ok {
 		ev.Chunk = text
 		ev.Format = format
+		ev.Kind = kind
 	} else {
 		ev.Chunk = ""
 		return ""
@@ -94,21 +90,24 @@ func (e CodexExecutor) HandleEvent(ev *domain.StreamEvent) string {
 	return extractSessionIDByRegex(ev.Chunk, codexThreadIDRegex, 1)
 }
 
-func parseCodexJSONEvent(chunk string) (sessionID, text, format string, ok bool) {
+func parseCodexJSONEvent(chunk string) (sessionID, text, format, kind string, ok bool) {
 	line := strings.TrimSpace(chunk)
 	if !strings.HasPrefix(line, "{") {
-		return "", "", "", false
+		return "", "", "", "", false
 	}
 	var ev codexJSONEvent
 	if err := json.Unmarshal([]byte(line), &ev); err != nil {
-		return "", "", "", false
+		return "", "", "", "", false
 	}
 	sessionID = extractCodexSessionID(ev)
 	text, format = extractCodexEventText(ev)
 	if text != "" && !strings.HasSuffix(text, "\n") {
 		text += "\n"
 	}
-	return sessionID, text, format, true
+	if ev.Type == "item.completed" && ev.Item != nil && ev.Item.Type == "command_execution" {
+		kind = domain.EventKindCommand
+	}
+	return sessionID, text, format, kind, true
 }
 
 func extractCodexSessionID(ev codexJSONEvent) string {
@@ -138,7 +137,8 @@ func extractCodexEventText(ev codexJSONEvent) (string, string) {
 		}
 		switch ev.Item.Type {
 		case "agent_message", "reasoning":
-			return ev.Item.Text, ""
+			// Both are Markdown written by the model.
+			return render.MarkdownToHTML(strings.TrimSpace(ev.Item.Text)), "html"
 		case "command_execution":
 			return formatCommandExecutionHTML(ev.Item.Command, ev.Item.AggregatedOutput), "html"
 		}
@@ -53,6 +53,23 @@ func TestCodexBuildCommandFullAccessMode(t *testing.T) {
 	}
 }
 
+func TestCodexBuildCommandPassesImages(t *testing.T) {
+	ex := CodexExecutor{Binary: "codex"}
+	args, err := ex.BuildCommand(context.Background(), domain.Job{
+		Prompt:  "what is wrong here?",
+		Session: "sid",
+		Images:  []string{"/w/a.png", "/w/b.jpg"},
+	})
+	if err != nil {
+		t.Fatalf("BuildCommand error: %v", err)
+	}
+	want := []string{"codex", "--full-auto", "--sandbox", "workspace-write", "exec", "--json", "--skip-git-repo-check",
+		"--image=/w/a.png", "--image=/w/b.jpg", "resume", "sid", "what is wrong here?"}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+}
+
 func TestCodexHandleEventJSON(t *testing.T) {
 	ex := CodexExecutor{}
 	ev := &domain.StreamEvent{Chunk: `{"type":"thread.started","thread_id":"019c5a9e-f025-7330-8911-56a4519ce9fa"}` + "\n"}
@@ -83,6 +100,16 @@ func TestCodexHandleEventItemCompletedAgentMessage(t *testing.T) {
 	}
 }
 
+func TestCodexHandleEventConvertsAgentMessageMarkdown(t *testing.T) {
+	ex := CodexExecutor{}
+	ev := &domain.StreamEvent{Chunk: `{"type":"item.completed","item":{"id":"item_2","type":"agent_message","text":"**Fixed** the glob in [match.go](https://github.com/o/r/search?q=*match*)\n- run ` + "`go test ./...`" + `"}}`}
+	_ = ex.HandleEvent(ev)
+	want := `<b>Fixed</b> the glob in <a href="https://github.com/o/r/search?q=*match*">match.go</a>` + "\n• run <code>go test ./...</code>\n"
+	if ev.Chunk != want || ev.Format != "html" {
+		t.Fatalf("unexpected %s chunk: %q", ev.Format, ev.Chunk)
+	}
+}
+
 func TestCodexHandleEventItemCompletedCommandExecution(t *testing.T) {
 	ex := CodexExecutor{}
 	ev := &domain.StreamEvent{Chunk: `{"type":"item.completed","item":{"id":"item_1","type":"command_execution","aggregated_output":"line1\nline2\n"}}`}
@@ -94,6 +121,9 @@ func TestCodexHandleEventItemCompletedCommandExecution(t *testing.T) {
 	if ev.Format != "html" {
 		t.Fatalf("expected format=html, got %q", ev.Format)
 	}
+	if ev.Kind != domain.EventKindCommand {
+		t.Fatalf("expected command kind, got %q", ev.Kind)
+	}
 }
 
 func TestCodexSessionIsolatedBySessionKey(t *testing.T) {
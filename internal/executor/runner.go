@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"chatcode/internal/domain"
@@ -28,6 +29,10 @@ func isNonFatalWaitErr(ex Executor, waitErr error) bool {
 	return checker.IsSuccessExitCode(exitErr.ExitCode())
 }
 
+// pipeCloseDelay is how long output of a stopped job is still read before
+// its pipes are closed, in case a process outside its group holds them.
+const pipeCloseDelay = 5 * time.Second
+
 type Runner struct {
 	Timeout time.Duration
 }
@@ -50,6 +55,12 @@ func (r Runner) RunJob(ctx context.Context, ex Executor, job domain.Job, sink Si
 
 	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Dir = job.Workdir
+	// The command gets its own process group, so stopping the job also
+	// kills the processes it started, which share its output pipes.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("stdout pipe: %w", err)
@@ -83,8 +94,23 @@ func (r Runner) RunJob(ctx context.Context, ex Executor, job domain.Job, sink Si
 	go emit("stdout", stdout)
 	go emit("stderr", stderr)
 
-	waitErr := cmd.Wait()
+	drained := make(chan struct{})
+	go func() {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			select {
+			case <-drained:
+			case <-time.After(pipeCloseDelay):
+				_ = stdout.Close()
+				_ = stderr.Close()
+			}
+		}
+	}()
+	// Drain both pipes before Wait, which closes them once the process exits.
 	wg.Wait()
+	close(drained)
+	waitErr := cmd.Wait()
 	if isNonFatalWaitErr(ex, waitErr) {
 		waitErr = nil
 	}
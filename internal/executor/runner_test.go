@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+type shellExec struct{ script string }
+
+func (e shellExec) Name() string { return "shell" }
+func (e shellExec) BuildCommand(context.Context, domain.Job) ([]string, error) {
+	return []string{"/bin/sh", "-c", e.script}, nil
+}
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []domain.StreamEvent
+}
+
+func (s *recordingSink) OnEvent(_ context.Context, ev domain.StreamEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func TestRunJobDeliversAllOutputOfShortCommand(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		sink := &recordingSink{}
+		err := Runner{Timeout: 10 * time.Second}.RunJob(context.Background(), shellExec{script: "seq 1 2000; echo warn >&2"}, domain.Job{ID: "j", Workdir: t.TempDir()}, sink)
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		stdout, stderr := 0, 0
+		for _, ev := range sink.events[:len(sink.events)-1] {
+			switch ev.Stream {
+			case "stdout":
+				stdout++
+			case "stderr":
+				stderr++
+			}
+		}
+		if stdout != 2000 || stderr != 1 {
+			t.Fatalf("expected all output lines, got %d on stdout and %d on stderr", stdout, stderr)
+		}
+		if last := sink.events[len(sink.events)-1]; !last.IsFinal || last.Stream != "meta" {
+			t.Fatalf("expected final meta event last, got %+v", last)
+		}
+	}
+}
+
+func TestRunJobStopsCommandWithChildrenHoldingOutput(t *testing.T) {
+	// The background sleep inherits stdout; killing only the shell would
+	// leave the pipe open until it exits.
+	ex := shellExec{script: "sleep 30 & echo started; sleep 30"}
+	for name, run := range map[string]func(sink Sink) error{
+		"stop": func(sink Sink) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(200*time.Millisecond, cancel)
+			return Runner{Timeout: time.Minute}.RunJob(ctx, ex, domain.Job{ID: "j", Workdir: t.TempDir()}, sink)
+		},
+		"timeout": func(sink Sink) error {
+			return Runner{Timeout: 200 * time.Millisecond}.RunJob(context.Background(), ex, domain.Job{ID: "j", Workdir: t.TempDir()}, sink)
+		},
+	} {
+		start := time.Now()
+		if err := run(&recordingSink{}); err == nil {
+			t.Fatalf("%s: expected an error for a killed command", name)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("%s: job took %s to end", name, elapsed)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package logging
 
 import (
+	"io"
 	"log/slog"
 	"os"
 )
 
 func New() *slog.Logger {
-	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	return NewTo(os.Stdout)
+}
+
+// NewTo returns a logger like New that writes to w.
+func NewTo(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo}))
 }
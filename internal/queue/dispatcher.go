@@ -2,19 +2,39 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"chatcode/internal/domain"
 )
 
+var ErrSessionQueueFull = errors.New("session queue is full")
+
 type Worker func(context.Context, domain.Job)
 
+// JobStore is the persistence the dispatcher restores its queues from. Jobs
+// are written by the caller before Enqueue, so after a restart the store is
+// the source of truth for what was still waiting to run.
+type JobStore interface {
+	ListJobsByStatus(ctx context.Context, status domain.JobStatus) ([]domain.Job, error)
+	UpdateJobStatus(ctx context.Context, jobID string, status domain.JobStatus, startedAt, finishedAt *time.Time, errMsg string) error
+}
+
 type Dispatcher struct {
-	mu           sync.Mutex
-	sessionQueue map[string]chan domain.Job
-	worker       Worker
-	sem          chan struct{}
-	buffer       int
+	mu       sync.Mutex
+	sessions map[string]*sessionQueue
+	worker   Worker
+	sem      chan struct{}
+	buffer   int
+	// idle are closed once no session has a queued or running job.
+	idle []chan struct{}
+}
+
+type sessionQueue struct {
+	jobs    []domain.Job
+	running bool
 }
 
 func NewDispatcher(maxConcurrent, perSessionBuffer int, worker Worker) *Dispatcher {
@@ -25,35 +45,179 @@ func NewDispatcher(maxConcurrent, perSessionBuffer int, worker Worker) *Dispatch
 		perSessionBuffer = 64
 	}
 	return &Dispatcher{
-		sessionQueue: make(map[string]chan domain.Job),
-		worker:       worker,
-		sem:          make(chan struct{}, maxConcurrent),
-		buffer:       perSessionBuffer,
+		sessions: make(map[string]*sessionQueue),
+		worker:   worker,
+		sem:      make(chan struct{}, maxConcurrent),
+		buffer:   perSessionBuffer,
 	}
 }
 
-func (d *Dispatcher) Enqueue(ctx context.Context, job domain.Job) {
-	key := job.SessionKey.String()
+// Enqueue appends job to its session queue. It returns ErrSessionQueueFull
+// when perSessionBuffer jobs are already waiting for that session.
+func (d *Dispatcher) Enqueue(ctx context.Context, job domain.Job) error {
 	d.mu.Lock()
-	q, ok := d.sessionQueue[key]
-	if !ok {
-		q = make(chan domain.Job, d.buffer)
-		d.sessionQueue[key] = q
-		go d.consume(ctx, q)
+	defer d.mu.Unlock()
+	q := d.sessionLocked(job.SessionKey)
+	if len(q.jobs) >= d.buffer {
+		return ErrSessionQueueFull
+	}
+	d.pushLocked(ctx, job.SessionKey, q, job)
+	return nil
+}
+
+// Restore rebuilds the in-memory queues from st after a restart. Jobs left
+// running by the previous process are marked interrupted and returned so the
+// caller can notify their chats; pending jobs are re-enqueued in creation
+// order, bypassing the per-session buffer limit.
+func (d *Dispatcher) Restore(ctx context.Context, st JobStore) ([]domain.Job, error) {
+	running, err := st.ListJobsByStatus(ctx, domain.JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	interrupted := make([]domain.Job, 0, len(running))
+	for _, job := range running {
+		finished := time.Now().UTC()
+		if err := st.UpdateJobStatus(ctx, job.ID, domain.JobInterrupted, job.StartedAt, &finished, "interrupted by daemon restart"); err != nil {
+			return interrupted, fmt.Errorf("mark job %s interrupted: %w", job.ID, err)
+		}
+		job.Status = domain.JobInterrupted
+		job.FinishedAt = &finished
+		interrupted = append(interrupted, job)
+	}
+
+	pending, err := st.ListJobsByStatus(ctx, domain.JobPending)
+	if err != nil {
+		return interrupted, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, job := range pending {
+		d.pushLocked(ctx, job.SessionKey, d.sessionLocked(job.SessionKey), job)
+	}
+	return interrupted, nil
+}
+
+// Remove drops a job that is still waiting in its session queue. It reports
+// false when the job is unknown or has already been handed to the worker.
+func (d *Dispatcher) Remove(jobID string) (domain.Job, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, q := range d.sessions {
+		for i, job := range q.jobs {
+			if job.ID == jobID {
+				q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+				return job, true
+			}
+		}
+	}
+	return domain.Job{}, false
+}
+
+// Update applies fn to a job that is still waiting in its session queue and
+// returns the updated job. It reports false when the job is unknown or has
+// already been handed to the worker, in which case fn is not called.
+func (d *Dispatcher) Update(jobID string, fn func(*domain.Job)) (domain.Job, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, q := range d.sessions {
+		for i := range q.jobs {
+			if q.jobs[i].ID == jobID {
+				fn(&q.jobs[i])
+				return q.jobs[i], true
+			}
+		}
+	}
+	return domain.Job{}, false
+}
+
+// Wait blocks until no session has a job queued or running, or ctx is done.
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	if len(d.sessions) == 0 {
+		d.mu.Unlock()
+		return nil
 	}
+	idle := make(chan struct{})
+	d.idle = append(d.idle, idle)
 	d.mu.Unlock()
-	q <- job
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Idle reports whether no session has a job queued or running.
+func (d *Dispatcher) Idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sessions) == 0
+}
+
+func (d *Dispatcher) sessionLocked(key domain.SessionKey) *sessionQueue {
+	q, ok := d.sessions[key.String()]
+	if !ok {
+		q = &sessionQueue{}
+		d.sessions[key.String()] = q
+	}
+	return q
+}
+
+func (d *Dispatcher) pushLocked(ctx context.Context, key domain.SessionKey, q *sessionQueue, job domain.Job) {
+	q.jobs = append(q.jobs, job)
+	if !q.running {
+		q.running = true
+		go d.consume(ctx, key.String(), q)
+	}
 }
 
-func (d *Dispatcher) consume(ctx context.Context, q <-chan domain.Job) {
+func (d *Dispatcher) consume(ctx context.Context, key string, q *sessionQueue) {
 	for {
+		if d.stopIdle(ctx, key, q) {
+			return
+		}
+		// The job stays in its queue while waiting for a slot, so it can
+		// still be removed or updated until the worker gets it.
 		select {
 		case <-ctx.Done():
-			return
-		case job := <-q:
-			d.sem <- struct{}{}
-			d.worker(ctx, job)
+			continue
+		case d.sem <- struct{}{}:
+		}
+		d.mu.Lock()
+		if len(q.jobs) == 0 {
+			d.mu.Unlock()
 			<-d.sem
+			continue
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		d.mu.Unlock()
+
+		d.worker(ctx, job)
+		<-d.sem
+	}
+}
+
+// stopIdle ends the consumer of q when it has nothing left to run or ctx
+// is done, and reports whether it did.
+func (d *Dispatcher) stopIdle(ctx context.Context, key string, q *sessionQueue) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(q.jobs) > 0 && ctx.Err() == nil {
+		return false
+	}
+	// Jobs still queued on shutdown stay pending in the store and are
+	// picked up again by Restore.
+	q.running = false
+	if len(q.jobs) == 0 {
+		delete(d.sessions, key)
+	}
+	if len(d.sessions) == 0 {
+		for _, idle := range d.idle {
+			close(idle)
 		}
+		d.idle = nil
 	}
+	return true
 }
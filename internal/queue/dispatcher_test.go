@@ -33,3 +33,187 @@ func TestDispatcherPerSessionSerial(t *testing.T) {
 		t.Fatalf("unexpected order: %#v", order)
 	}
 }
+
+type fakeJobStore struct {
+	mu      sync.Mutex
+	jobs    map[domain.JobStatus][]domain.Job
+	updated map[string]domain.JobStatus
+}
+
+func (f *fakeJobStore) ListJobsByStatus(_ context.Context, status domain.JobStatus) ([]domain.Job, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]domain.Job(nil), f.jobs[status]...), nil
+}
+
+func (f *fakeJobStore) UpdateJobStatus(_ context.Context, jobID string, status domain.JobStatus, _, _ *time.Time, _ string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updated[jobID] = status
+	return nil
+}
+
+func TestDispatcherRestore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	st := &fakeJobStore{
+		jobs: map[domain.JobStatus][]domain.Job{
+			domain.JobRunning: {{ID: "r", SessionKey: key, Status: domain.JobRunning}},
+			domain.JobPending: {{ID: "p1", SessionKey: key}, {ID: "p2", SessionKey: key}, {ID: "p3", SessionKey: key}},
+		},
+		updated: map[string]domain.JobStatus{},
+	}
+
+	var mu sync.Mutex
+	order := make([]string, 0, 3)
+	// A buffer of 1 must not drop restored jobs.
+	d := NewDispatcher(4, 1, func(_ context.Context, job domain.Job) {
+		mu.Lock()
+		order = append(order, job.ID)
+		mu.Unlock()
+	})
+	interrupted, err := d.Restore(ctx, st)
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if len(interrupted) != 1 || interrupted[0].ID != "r" || interrupted[0].Status != domain.JobInterrupted {
+		t.Fatalf("unexpected interrupted jobs: %#v", interrupted)
+	}
+	if st.updated["r"] != domain.JobInterrupted {
+		t.Fatalf("expected running job marked interrupted, got %q", st.updated["r"])
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 3 || order[0] != "p1" || order[1] != "p2" || order[2] != "p3" {
+		t.Fatalf("unexpected order: %#v", order)
+	}
+}
+
+func TestDispatcherEnqueueRejectsWhenSessionQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	d := NewDispatcher(1, 1, func(context.Context, domain.Job) { <-release })
+	defer close(release)
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := d.Enqueue(ctx, domain.Job{ID: "a", SessionKey: key}); err != nil {
+		t.Fatalf("enqueue a: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := d.Enqueue(ctx, domain.Job{ID: "b", SessionKey: key}); err != nil {
+		t.Fatalf("enqueue b: %v", err)
+	}
+	if err := d.Enqueue(ctx, domain.Job{ID: "c", SessionKey: key}); err != ErrSessionQueueFull {
+		t.Fatalf("expected ErrSessionQueueFull, got %v", err)
+	}
+}
+
+func TestDispatcherUpdateQueuedJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	prompts := map[string]string{}
+	d := NewDispatcher(4, 16, func(_ context.Context, job domain.Job) {
+		if job.ID == "a" {
+			<-release
+		}
+		mu.Lock()
+		prompts[job.ID] = job.Prompt
+		mu.Unlock()
+	})
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	d.Enqueue(ctx, domain.Job{ID: "a", SessionKey: key, Prompt: "first"})
+	d.Enqueue(ctx, domain.Job{ID: "b", SessionKey: key, Prompt: "typo"})
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := d.Update("a", func(j *domain.Job) { j.Prompt = "changed" }); ok {
+		t.Fatalf("expected running job not to be updated")
+	}
+	job, ok := d.Update("b", func(j *domain.Job) { j.Prompt = "fixed" })
+	if !ok || job.Prompt != "fixed" {
+		t.Fatalf("expected queued job to be updated, got %#v %v", job, ok)
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if prompts["a"] != "first" || prompts["b"] != "fixed" {
+		t.Fatalf("unexpected prompts: %#v", prompts)
+	}
+}
+
+func TestDispatcherRemoveJobWaitingForSlot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	ran := map[string]bool{}
+	d := NewDispatcher(1, 16, func(_ context.Context, job domain.Job) {
+		if job.ID == "a" {
+			<-release
+		}
+		mu.Lock()
+		ran[job.ID] = true
+		mu.Unlock()
+	})
+	d.Enqueue(ctx, domain.Job{ID: "a", SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}})
+	time.Sleep(20 * time.Millisecond)
+	// The only slot is taken, so b waits for it in another session.
+	d.Enqueue(ctx, domain.Job{ID: "b", SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "2"}})
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := d.Remove("b"); !ok {
+		t.Fatal("expected job waiting for a slot to be removable")
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !ran["a"] || ran["b"] {
+		t.Fatalf("unexpected jobs run: %#v", ran)
+	}
+}
+
+func TestDispatcherWaitReturnsOnceIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	ran := 0
+	d := NewDispatcher(1, 16, func(_ context.Context, job domain.Job) {
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		ran++
+		mu.Unlock()
+	})
+	if !d.Idle() {
+		t.Fatal("expected new dispatcher to be idle")
+	}
+	d.Enqueue(ctx, domain.Job{ID: "a", SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}})
+	d.Enqueue(ctx, domain.Job{ID: "b", SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "2"}})
+	if d.Idle() {
+		t.Fatal("expected dispatcher with queued jobs to be busy")
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer waitCancel()
+	if err := d.Wait(waitCtx); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if ran != 2 || !d.Idle() {
+		t.Fatalf("expected both jobs to have run, ran %d", ran)
+	}
+}
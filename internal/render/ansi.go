@@ -0,0 +1,40 @@
+package render
+
+import "strings"
+
+// ansiEscaper strips escape characters from text, so output cannot move
+// the cursor or change the terminal's state between the styles set here.
+var ansiEscaper = strings.NewReplacer("\x1b", "")
+
+var ansi = markup{
+	inline: map[string][2]string{
+		"b":      {"\x1b[1m", "\x1b[22m"},
+		"strong": {"\x1b[1m", "\x1b[22m"},
+		"i":      {"\x1b[3m", "\x1b[23m"},
+		"em":     {"\x1b[3m", "\x1b[23m"},
+		"u":      {"\x1b[4m", "\x1b[24m"},
+		"ins":    {"\x1b[4m", "\x1b[24m"},
+		"s":      {"\x1b[9m", "\x1b[29m"},
+		"del":    {"\x1b[9m", "\x1b[29m"},
+		"strike": {"\x1b[9m", "\x1b[29m"},
+	},
+	code:  [2]string{"\x1b[36m", "\x1b[39m"},
+	pre:   [2]string{"\x1b[36m", "\x1b[39m"},
+	quote: "\x1b[2m│\x1b[22m ",
+	link: func(label, href string) string {
+		if href == "" || label == href {
+			return "\x1b[4m" + label + "\x1b[24m"
+		}
+		return "\x1b[4m" + label + "\x1b[24m \x1b[2m(" + href + ")\x1b[22m"
+	},
+	escape: ansiEscaper.Replace,
+}
+
+// HTMLToANSI converts Telegram-style HTML into text styled with ANSI escape
+// sequences for a terminal: bold, italic, underline and strikethrough keep
+// their style, code and pre blocks are cyan, links are underlined and
+// followed by their URL, and blockquote lines get a bar. Escape characters
+// in the text itself are removed.
+func HTMLToANSI(text string) string {
+	return convertHTML(text, ansi)
+}
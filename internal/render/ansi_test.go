@@ -0,0 +1,19 @@
+package render
+
+import "testing"
+
+func TestHTMLToANSI(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"<b>done</b> in 2s", "\x1b[1mdone\x1b[22m in 2s"},
+		{"log:\n<pre>a &lt; b\n</pre>", "log:\n\x1b[36ma < b\x1b[39m"},
+		{"<i>x</i> <code><b>y</b></code>", "\x1b[3mx\x1b[23m \x1b[36my\x1b[39m"},
+		{`<a href="https://example.com">docs</a>`, "\x1b[4mdocs\x1b[24m \x1b[2m(https://example.com)\x1b[22m"},
+		{"<blockquote>a\nb</blockquote>", "\x1b[2m│\x1b[22m a\n\x1b[2m│\x1b[22m b"},
+		{"evil \x1b[2J output", "evil [2J output"},
+	}
+	for _, c := range cases {
+		if got := HTMLToANSI(c.in); got != c.want {
+			t.Errorf("HTMLToANSI(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
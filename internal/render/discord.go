@@ -0,0 +1,40 @@
+package render
+
+import "strings"
+
+var discordEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`)
+
+var discord = markup{
+	inline: map[string][2]string{
+		"b":      {"**", "**"},
+		"strong": {"**", "**"},
+		"i":      {"*", "*"},
+		"em":     {"*", "*"},
+		"u":      {"__", "__"},
+		"ins":    {"__", "__"},
+		"s":      {"~~", "~~"},
+		"del":    {"~~", "~~"},
+		"strike": {"~~", "~~"},
+	},
+	code:  [2]string{"`", "`"},
+	pre:   [2]string{"```\n", "\n```"},
+	quote: "> ",
+	link: func(label, href string) string {
+		if label == "" {
+			return href
+		}
+		if href == "" || label == href {
+			return label
+		}
+		return "[" + label + "](" + href + ")"
+	},
+	escapeMarkup: discordEscaper.Replace,
+}
+
+// HTMLToDiscord converts Telegram-style HTML into Discord markdown: bold,
+// italic, underline and strikethrough become **x**, *x*, __x__ and ~~x~~,
+// inline code `x`, pre blocks fenced code blocks and links [text](url).
+// Markdown characters in plain text are escaped.
+func HTMLToDiscord(text string) string {
+	return convertHTML(text, discord)
+}
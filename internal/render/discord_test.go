@@ -0,0 +1,17 @@
+package render
+
+import "testing"
+
+func TestHTMLToDiscord(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"<b>command_execution</b> done", "**command\\_execution** done"},
+		{"output:\n<pre><code class=\"language-go\">a := b * c\n</code></pre>", "output:\n```\na := b * c\n```"},
+		{"<i>x</i> <u>y</u> <s>z</s> <code>*p</code>", "*x* __y__ ~~z~~ `*p`"},
+		{`<a href="https://example.com/a_b">docs</a>`, "[docs](https://example.com/a_b)"},
+	}
+	for _, c := range cases {
+		if got := HTMLToDiscord(c.in); got != c.want {
+			t.Errorf("HTMLToDiscord(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
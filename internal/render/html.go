@@ -0,0 +1,218 @@
+package render
+
+import (
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// Split breaks text into parts of at most max bytes. HTML-formatted text is
+// split with SplitHTML, anything else with SplitText.
+func Split(text, format string, max int) []string {
+	if format == "html" {
+		return SplitHTML(text, max)
+	}
+	return SplitText(text, max)
+}
+
+// SplitText breaks plain text into parts of at most max bytes without
+// cutting a UTF-8 sequence, preferring to break after a newline in the
+// second half of a part.
+func SplitText(text string, max int) []string {
+	if max <= 0 || len(text) <= max {
+		return []string{text}
+	}
+	var parts []string
+	for len(text) > max {
+		cut := max
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			// max is smaller than a single rune; emit the rune whole.
+			_, size := utf8.DecodeRuneInString(text)
+			cut = size
+		}
+		if nl := strings.LastIndexByte(text[:cut], '\n'); nl >= cut/2 {
+			cut = nl + 1
+		}
+		parts = append(parts, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// SplitHTML breaks Telegram-style HTML into parts of at most max bytes that
+// are each well formed on their own: tags open at a split are closed at the
+// end of one part and reopened at the start of the next, and tags, entities
+// and UTF-8 sequences are never cut. Like SplitText it prefers to break
+// after a newline in the second half of a part.
+func SplitHTML(text string, max int) []string {
+	if max <= 0 || len(text) <= max {
+		return []string{text}
+	}
+	var (
+		parts []string
+		cur   strings.Builder
+		open  []openTag
+		// State at the last newline in cur, used to break there instead of
+		// mid-line.
+		nlPos  = -1
+		nlOpen []openTag
+	)
+	emit := func(body string, stack []openTag) {
+		if strings.TrimSpace(PlainText(body)) == "" {
+			return
+		}
+		parts = append(parts, body+closers(stack))
+	}
+	for _, tok := range tokenize(text) {
+		next := open
+		if tok.kind == tokenTag {
+			next = applyTag(open, tok)
+		}
+		if cur.Len() > 0 && cur.Len()+len(tok.text)+len(closers(next)) > max {
+			body := cur.String()
+			if nlPos > len(body)/2 {
+				emit(body[:nlPos], nlOpen)
+				rest := body[nlPos:]
+				cur.Reset()
+				cur.WriteString(openers(nlOpen))
+				cur.WriteString(rest)
+			} else {
+				emit(body, open)
+				cur.Reset()
+				cur.WriteString(openers(open))
+			}
+			nlPos = -1
+		}
+		cur.WriteString(tok.text)
+		open = next
+		if tok.kind == tokenText && tok.text == "\n" {
+			nlPos = cur.Len()
+			nlOpen = append(nlOpen[:0:0], open...)
+		}
+	}
+	if cur.Len() > 0 {
+		emit(cur.String(), open)
+	}
+	return parts
+}
+
+// PlainText strips tags from HTML and unescapes entities.
+func PlainText(text string) string {
+	var b strings.Builder
+	for _, tok := range tokenize(text) {
+		switch tok.kind {
+		case tokenTag:
+			if tok.name == "br" {
+				b.WriteString("\n")
+			}
+		case tokenEntity:
+			b.WriteString(html.UnescapeString(tok.text))
+		default:
+			b.WriteString(tok.text)
+		}
+	}
+	return b.String()
+}
+
+type tokenKind int
+
+const (
+	tokenText tokenKind = iota
+	tokenEntity
+	tokenTag
+)
+
+type token struct {
+	kind    tokenKind
+	text    string
+	name    string
+	closing bool
+}
+
+type openTag struct {
+	name string
+	raw  string
+}
+
+// tokenize splits HTML into tags, entities and single runes of text. A "<"
+// or "&" that does not start a well-formed tag or entity is treated as text.
+func tokenize(text string) []token {
+	var toks []token
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				raw := text[i : i+end+1]
+				name, closing := tagName(raw)
+				if name != "" {
+					toks = append(toks, token{kind: tokenTag, text: raw, name: name, closing: closing})
+					i += end + 1
+					continue
+				}
+			}
+		case '&':
+			if end := strings.IndexByte(text[i:], ';'); end > 1 && end <= 10 && !strings.ContainsAny(text[i+1:i+end], " \n<&") {
+				toks = append(toks, token{kind: tokenEntity, text: text[i : i+end+1]})
+				i += end + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		toks = append(toks, token{kind: tokenText, text: text[i : i+size]})
+		i += size
+	}
+	return toks
+}
+
+func tagName(raw string) (string, bool) {
+	inner := strings.TrimSpace(raw[1 : len(raw)-1])
+	closing := strings.HasPrefix(inner, "/")
+	inner = strings.TrimPrefix(inner, "/")
+	end := strings.IndexAny(inner, " \t\n/")
+	if end >= 0 {
+		inner = inner[:end]
+	}
+	for _, r := range inner {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return "", false
+		}
+	}
+	return strings.ToLower(inner), closing
+}
+
+func applyTag(stack []openTag, tok token) []openTag {
+	if tok.name == "br" {
+		return stack
+	}
+	if !tok.closing {
+		return append(stack[:len(stack):len(stack)], openTag{name: tok.name, raw: tok.text})
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].name == tok.name {
+			return stack[:i:i]
+		}
+	}
+	return stack
+}
+
+func openers(stack []openTag) string {
+	var b strings.Builder
+	for _, t := range stack {
+		b.WriteString(t.raw)
+	}
+	return b.String()
+}
+
+func closers(stack []openTag) string {
+	var b strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		b.WriteString("</" + stack[i].name + ">")
+	}
+	return b.String()
+}
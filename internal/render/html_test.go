@@ -0,0 +1,65 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitTextKeepsRunesAndPrefersNewlines(t *testing.T) {
+	parts := SplitText("héllo\nwörld and more", 8)
+	for _, p := range parts {
+		if len(p) > 8 {
+			t.Fatalf("part %q exceeds limit", p)
+		}
+		if !utf8.ValidString(p) {
+			t.Fatalf("part %q cuts a rune", p)
+		}
+	}
+	if parts[0] != "héllo\n" {
+		t.Fatalf("expected break after newline, got %#v", parts)
+	}
+	if strings.Join(parts, "") != "héllo\nwörld and more" {
+		t.Fatalf("parts do not reassemble: %#v", parts)
+	}
+}
+
+func TestSplitHTMLReopensTagsAcrossParts(t *testing.T) {
+	text := `<pre><code class="language-go">` + strings.Repeat("fmt.Println(1)\n", 6) + `</code></pre>`
+	parts := SplitHTML(text, 80)
+	if len(parts) < 2 {
+		t.Fatalf("expected several parts, got %#v", parts)
+	}
+	var plain strings.Builder
+	for _, p := range parts {
+		if len(p) > 80 {
+			t.Fatalf("part exceeds limit: %q", p)
+		}
+		if !strings.HasPrefix(p, `<pre><code class="language-go">`) || !strings.HasSuffix(p, "</code></pre>") {
+			t.Fatalf("part is not balanced: %q", p)
+		}
+		plain.WriteString(PlainText(p))
+	}
+	if plain.String() != strings.Repeat("fmt.Println(1)\n", 6) {
+		t.Fatalf("content changed across parts: %q", plain.String())
+	}
+}
+
+func TestSplitHTMLDoesNotCutEntities(t *testing.T) {
+	parts := SplitHTML(strings.Repeat("a&amp;", 10), 7)
+	for _, p := range parts {
+		if strings.Count(p, "&") != strings.Count(p, "&amp;") {
+			t.Fatalf("entity cut in part %q", p)
+		}
+	}
+	if strings.Join(parts, "") != strings.Repeat("a&amp;", 10) {
+		t.Fatalf("parts do not reassemble: %#v", parts)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	got := PlainText(`<b>a &lt; b</b> &amp; <a href="x">link</a> 1<2`)
+	if got != "a < b & link 1<2" {
+		t.Fatalf("unexpected plain text: %q", got)
+	}
+}
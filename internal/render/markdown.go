@@ -0,0 +1,138 @@
+package render
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	mdFenceRegex   = regexp.MustCompile("^\\s*(```|~~~)\\s*([\\w+#.-]*)")
+	mdHeadingRegex = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	mdBulletRegex  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdOrderedRegex = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	mdQuoteRegex   = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	mdRuleRegex    = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdLinkRegex    = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+	mdBoldRegex    = regexp.MustCompile(`\*\*([^*\n]+?)\*\*`)
+	mdStrikeRegex  = regexp.MustCompile(`~~([^~\n]+?)~~`)
+	mdItalicRegex  = regexp.MustCompile(`(^|[^\w*])\*([^*\s](?:[^*\n]*[^*\s])?)\*`)
+	mdURLRegex     = regexp.MustCompile(`(?i)\b(?:https?|mailto|tg):[^\s<>"]*[^\s<>"*~.,;:!?)]`)
+	mdKeptRegex    = regexp.MustCompile("\x00(\\d+)\x00")
+	mdLinkSchemes  = []string{"http://", "https://", "mailto:", "tg://"}
+)
+
+// MarkdownToHTML converts the Markdown agents write into the HTML subset
+// Telegram accepts: headings become bold lines, list markers become bullets,
+// fenced blocks become <pre><code> with their language, and bold, italic,
+// strikethrough, inline code and links are mapped to their tags. Underscore
+// emphasis is left alone because it collides with identifiers. Everything
+// else is escaped, so the result is always safe to send with parse_mode HTML.
+func MarkdownToHTML(md string) string {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var (
+		out   []string
+		quote []string
+	)
+	flushQuote := func() {
+		if len(quote) > 0 {
+			out = append(out, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+			quote = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if m := mdFenceRegex.FindStringSubmatch(line); m != nil {
+			flushQuote()
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			out = append(out, codeBlock(strings.Join(code, "\n"), m[2]))
+			continue
+		}
+		if m := mdQuoteRegex.FindStringSubmatch(line); m != nil {
+			quote = append(quote, inlineMarkdown(m[1]))
+			continue
+		}
+		flushQuote()
+		switch {
+		case mdHeadingRegex.MatchString(line):
+			m := mdHeadingRegex.FindStringSubmatch(line)
+			out = append(out, "<b>"+inlineMarkdown(m[1])+"</b>")
+		case mdRuleRegex.MatchString(line):
+			out = append(out, "──────────")
+		case mdBulletRegex.MatchString(line):
+			m := mdBulletRegex.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+inlineMarkdown(m[2]))
+		case mdOrderedRegex.MatchString(line):
+			m := mdOrderedRegex.FindStringSubmatch(line)
+			out = append(out, m[1]+m[2]+". "+inlineMarkdown(m[3]))
+		default:
+			out = append(out, inlineMarkdown(line))
+		}
+	}
+	flushQuote()
+	return strings.Join(out, "\n")
+}
+
+func codeBlock(code, lang string) string {
+	if lang == "" {
+		return "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	return `<pre><code class="language-` + html.EscapeString(lang) + `">` + html.EscapeString(code) + "</code></pre>"
+}
+
+// inlineMarkdown converts the inline markup of a single line. Code spans are
+// cut out first so nothing inside them is interpreted.
+func inlineMarkdown(line string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(line, '`')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(line[start+1:], '`')
+		if end < 0 {
+			break
+		}
+		b.WriteString(inlineSpans(line[:start]))
+		b.WriteString("<code>" + html.EscapeString(line[start+1:start+1+end]) + "</code>")
+		line = line[start+end+2:]
+	}
+	b.WriteString(inlineSpans(line))
+	return b.String()
+}
+
+// inlineSpans converts links and emphasis. Link tags and bare URLs are set
+// aside while emphasis is applied, so a "*" or "~" in a URL is kept as is.
+func inlineSpans(text string) string {
+	text = html.EscapeString(strings.ReplaceAll(text, "\x00", ""))
+	var kept []string
+	keep := func(s string) string {
+		kept = append(kept, s)
+		return "\x00" + strconv.Itoa(len(kept)-1) + "\x00"
+	}
+	text = mdLinkRegex.ReplaceAllStringFunc(text, func(s string) string {
+		m := mdLinkRegex.FindStringSubmatch(s)
+		href := html.UnescapeString(m[2])
+		for _, scheme := range mdLinkSchemes {
+			if strings.HasPrefix(strings.ToLower(href), scheme) {
+				return keep(`<a href="`+html.EscapeString(href)+`">`) + mdURLRegex.ReplaceAllStringFunc(m[1], keep) + keep("</a>")
+			}
+		}
+		return s
+	})
+	text = mdURLRegex.ReplaceAllStringFunc(text, keep)
+	text = mdBoldRegex.ReplaceAllString(text, "<b>$1</b>")
+	text = mdStrikeRegex.ReplaceAllString(text, "<s>$1</s>")
+	text = mdItalicRegex.ReplaceAllString(text, "$1<i>$2</i>")
+	return mdKeptRegex.ReplaceAllStringFunc(text, func(s string) string {
+		i, _ := strconv.Atoi(s[1 : len(s)-1])
+		return kept[i]
+	})
+}
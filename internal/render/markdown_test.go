@@ -0,0 +1,34 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToHTML(t *testing.T) {
+	md := "## Plan\n- read **main.go**\n1. run `go test`\nsee [docs](https://go.dev/?a=1&b=2) and *this*\n```go\nif a < b {}\n```\nsnake_case __init__ 2*3*4"
+	want := "<b>Plan</b>\n" +
+		"• read <b>main.go</b>\n" +
+		"1. run <code>go test</code>\n" +
+		`see <a href="https://go.dev/?a=1&amp;b=2">docs</a> and <i>this</i>` + "\n" +
+		`<pre><code class="language-go">if a &lt; b {}</code></pre>` + "\n" +
+		"snake_case __init__ 2*3*4"
+	if got := MarkdownToHTML(md); got != want {
+		t.Fatalf("unexpected html:\n%s", got)
+	}
+}
+
+func TestMarkdownToHTMLEscapesUnsafeLinks(t *testing.T) {
+	got := MarkdownToHTML(`[x](javascript:alert(1)) <script>`)
+	if strings.Contains(got, "<a") || strings.Contains(got, "<script>") {
+		t.Fatalf("unsafe markup kept: %q", got)
+	}
+}
+
+func TestMarkdownToHTMLKeepsEmphasisOutOfURLs(t *testing.T) {
+	md := "see [the *glob* docs](https://example.com/a*b*c?q=~~x~~) and https://example.com/x*y*z, then *done*"
+	want := `see <a href="https://example.com/a*b*c?q=~~x~~">the <i>glob</i> docs</a> and https://example.com/x*y*z, then <i>done</i>`
+	if got := MarkdownToHTML(md); got != want {
+		t.Fatalf("unexpected html:\n%s", got)
+	}
+}
@@ -0,0 +1,165 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var hrefRegex = regexp.MustCompile(`(?i)\bhref\s*=\s*"([^"]*)"`)
+
+// markup describes a chat platform's lightweight text formatting, which
+// convertHTML produces from Telegram-style HTML.
+type markup struct {
+	// inline maps tags such as b and i to their opening and closing
+	// markers. Unlisted tags are dropped.
+	inline map[string][2]string
+	code   [2]string
+	pre    [2]string
+	// quote prefixes every line inside a blockquote.
+	quote string
+	// link renders an anchor from its already converted label and its
+	// unescaped href.
+	link func(label, href string) string
+	// escape, when set, is applied to text.
+	escape func(string) string
+	// escapeMarkup, when set, is applied to text outside code, where
+	// markers would otherwise take effect.
+	escapeMarkup func(string) string
+}
+
+// convertHTML rewrites Telegram-style HTML in the markup m. Entities are
+// unescaped, pre blocks start on a line of their own and nothing is marked
+// up inside code, where markers would show literally. Empty pairs such as
+// "<b></b>" are dropped for the same reason.
+func convertHTML(text string, m markup) string {
+	type opened struct {
+		end, size int // buffer length after the marker and its length
+	}
+	var (
+		b      bytes.Buffer
+		stack  []opened
+		hrefs  []string
+		linkAt []int
+		pre    int
+		code   int
+		quote  int
+	)
+	write := func(s string) {
+		if m.escape != nil {
+			s = m.escape(s)
+		}
+		if m.escapeMarkup != nil && pre == 0 && code == 0 {
+			s = m.escapeMarkup(s)
+		}
+		b.WriteString(s)
+	}
+	open := func(marker string) {
+		b.WriteString(marker)
+		stack = append(stack, opened{end: b.Len(), size: len(marker)})
+	}
+	closeMarker := func(marker string) {
+		if len(stack) == 0 {
+			return
+		}
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if o.end == b.Len() {
+			b.Truncate(o.end - o.size)
+			return
+		}
+		b.WriteString(marker)
+	}
+	newline := func() {
+		b.WriteByte('\n')
+		if quote > 0 && pre == 0 {
+			b.WriteString(m.quote)
+		}
+	}
+	startLine := func() {
+		if n := b.Len(); n > 0 && b.Bytes()[n-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	for _, tok := range tokenize(text) {
+		switch tok.kind {
+		case tokenText:
+			if tok.text == "\n" {
+				newline()
+			} else {
+				write(tok.text)
+			}
+			continue
+		case tokenEntity:
+			write(html.UnescapeString(tok.text))
+			continue
+		}
+		switch tok.name {
+		case "br":
+			newline()
+		case "pre":
+			if tok.closing {
+				pre--
+				// A trailing newline would end up inside the block.
+				if n := b.Len(); n > 0 && b.Bytes()[n-1] == '\n' {
+					b.Truncate(n - 1)
+				}
+				closeMarker(m.pre[1])
+			} else {
+				startLine()
+				pre++
+				open(m.pre[0])
+			}
+		case "code":
+			// Code inside pre is already monospaced.
+			if pre > 0 {
+				continue
+			}
+			if tok.closing {
+				code--
+				closeMarker(m.code[1])
+			} else {
+				code++
+				open(m.code[0])
+			}
+		case "blockquote":
+			if tok.closing {
+				quote--
+				continue
+			}
+			startLine()
+			quote++
+			b.WriteString(m.quote)
+		case "a":
+			if !tok.closing {
+				href := ""
+				if match := hrefRegex.FindStringSubmatch(tok.text); match != nil {
+					href = html.UnescapeString(match[1])
+				}
+				hrefs = append(hrefs, href)
+				linkAt = append(linkAt, b.Len())
+				continue
+			}
+			if len(hrefs) == 0 {
+				continue
+			}
+			href, at := hrefs[len(hrefs)-1], linkAt[len(linkAt)-1]
+			hrefs, linkAt = hrefs[:len(hrefs)-1], linkAt[:len(linkAt)-1]
+			label := string(b.Bytes()[at:])
+			b.Truncate(at)
+			b.WriteString(m.link(label, href))
+		default:
+			markers, ok := m.inline[tok.name]
+			if !ok || pre > 0 || code > 0 {
+				continue
+			}
+			if tok.closing {
+				closeMarker(markers[1])
+			} else {
+				open(markers[0])
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
@@ -0,0 +1,34 @@
+package render
+
+import "strings"
+
+// HTMLToMatrix adapts Telegram-style HTML to the org.matrix.custom.html
+// format. Both share the common tags; Matrix clients collapse newlines like
+// browsers do, so newlines outside pre become <br>, and spoilers become
+// spans with data-mx-spoiler.
+func HTMLToMatrix(text string) string {
+	var b strings.Builder
+	pre := 0
+	for _, tok := range tokenize(text) {
+		switch {
+		case tok.kind == tokenTag && tok.name == "pre":
+			if tok.closing {
+				pre--
+			} else {
+				pre++
+			}
+			b.WriteString(tok.text)
+		case tok.kind == tokenTag && tok.name == "tg-spoiler":
+			if tok.closing {
+				b.WriteString("</span>")
+			} else {
+				b.WriteString("<span data-mx-spoiler>")
+			}
+		case tok.kind == tokenText && tok.text == "\n" && pre == 0:
+			b.WriteString("<br>")
+		default:
+			b.WriteString(tok.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,11 @@
+package render
+
+import "testing"
+
+func TestHTMLToMatrix(t *testing.T) {
+	in := "<b>done</b>\nsee <tg-spoiler>x</tg-spoiler>\n<pre><code class=\"language-go\">a\nb</code></pre>"
+	want := "<b>done</b><br>see <span data-mx-spoiler>x</span><br><pre><code class=\"language-go\">a\nb</code></pre>"
+	if got := HTMLToMatrix(in); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,30 @@
+package render
+
+import "strings"
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var slack = markup{
+	inline: whatsApp.inline,
+	code:   [2]string{"`", "`"},
+	pre:    [2]string{"```", "```"},
+	quote:  "> ",
+	link: func(label, href string) string {
+		if href == "" {
+			return label
+		}
+		href = slackEscaper.Replace(href)
+		if label == "" || label == href {
+			return "<" + href + ">"
+		}
+		return "<" + href + "|" + label + ">"
+	},
+	escape: slackEscaper.Replace,
+}
+
+// HTMLToSlack converts Telegram-style HTML into Slack mrkdwn. It uses the
+// same markers as WhatsApp, renders links as <url|text> and escapes &, <
+// and >, which Slack reserves for links and mentions.
+func HTMLToSlack(text string) string {
+	return convertHTML(text, slack)
+}
@@ -0,0 +1,17 @@
+package render
+
+import "testing"
+
+func TestHTMLToSlack(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"<b>command_execution</b>\n<pre>a &lt; b &amp;&amp; c</pre>", "*command_execution*\n```a &lt; b &amp;&amp; c```"},
+		{"x <i>y</i> <code>&lt;T&gt;</code>", "x _y_ `&lt;T&gt;`"},
+		{`<a href="https://example.com/?a=1&amp;b=2">docs</a>`, "<https://example.com/?a=1&amp;b=2|docs>"},
+		{`<a href="https://example.com">https://example.com</a>`, "<https://example.com>"},
+	}
+	for _, c := range cases {
+		if got := HTMLToSlack(c.in); got != c.want {
+			t.Errorf("HTMLToSlack(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package render
+
+var whatsApp = markup{
+	inline: map[string][2]string{
+		"b":      {"*", "*"},
+		"strong": {"*", "*"},
+		"i":      {"_", "_"},
+		"em":     {"_", "_"},
+		"s":      {"~", "~"},
+		"del":    {"~", "~"},
+		"strike": {"~", "~"},
+	},
+	code:  [2]string{"`", "`"},
+	pre:   [2]string{"```", "```"},
+	quote: "> ",
+	link: func(label, href string) string {
+		if href == "" || label == href {
+			return label
+		}
+		return label + " (" + href + ")"
+	},
+}
+
+// HTMLToWhatsApp converts Telegram-style HTML into WhatsApp markup: bold,
+// italic and strikethrough become *x*, _x_ and ~x~, inline code `x`, pre
+// blocks ```x```, links "text (url)" and blockquotes "> " lines. Other tags
+// are dropped and entities unescaped.
+func HTMLToWhatsApp(text string) string {
+	return convertHTML(text, whatsApp)
+}
@@ -0,0 +1,31 @@
+package render
+
+import "testing"
+
+func TestHTMLToWhatsApp(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"<b>done</b> in 3s", "*done* in 3s"},
+		{"<i>note</i> and <s>old</s>", "_note_ and ~old~"},
+		{"run <code>go test</code> &amp; check", "run `go test` & check"},
+		{"<b>x</b><b></b>", "*x*"},
+		{"<code>a *b* &lt;c&gt;</code>", "`a *b* <c>`"},
+		{"output:\n<pre><code class=\"language-go\">x := 1 &lt; 2\n<b>y</b>\n</code></pre>", "output:\n```x := 1 < 2\ny```"},
+		{`see <a href="https://example.com/?a=1&amp;b=2">docs</a>`, "see docs (https://example.com/?a=1&b=2)"},
+		{`<a href="https://example.com">https://example.com</a>`, "https://example.com"},
+		{"said:<blockquote>one\ntwo</blockquote>", "said:\n> one\n> two"},
+		{"a<br>b <tg-spoiler>c</tg-spoiler>", "a\nb c"},
+	}
+	for _, c := range cases {
+		if got := HTMLToWhatsApp(c.in); got != c.want {
+			t.Errorf("HTMLToWhatsApp(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestHTMLToWhatsAppRendersMarkdownOutput(t *testing.T) {
+	got := HTMLToWhatsApp(MarkdownToHTML("**Summary**: use `make`\n\n```sh\nmake test\n```"))
+	want := "*Summary*: use `make`\n\n```make test```"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// handleAttachments saves the files of msg into the session workdir and
+// queues a job whose prompt is the caption followed by the saved paths.
+// A caption may start with /codex or /claude to pick the executor.
+func (o *Orchestrator) handleAttachments(ctx context.Context, msg domain.Message, caption string) error {
+	key := msg.SessionKey
+	exName := o.defaultExecutor(key)
+	for _, name := range []string{"codex", "claude"} {
+		if caption == "/"+name || strings.HasPrefix(caption, "/"+name+" ") {
+			o.sessions.SetDefaultExecutor(key, name)
+			exName = name
+			caption = strings.TrimSpace(strings.TrimPrefix(caption, "/"+name))
+		}
+	}
+	if strings.HasPrefix(caption, "/") {
+		return o.reply(ctx, key, "attachments can only be sent with a prompt, /codex or /claude")
+	}
+	fetcher, ok := o.transport[key.Platform].(domain.AttachmentFetcher)
+	if !ok {
+		return o.reply(ctx, key, "attachments are not supported on this platform")
+	}
+	wd, err := o.sessions.Workdir(ctx, key)
+	if err != nil {
+		return err
+	}
+	if wd == "" {
+		return o.reply(ctx, key, "workdir is not set, use /cd <project_dir> first")
+	}
+
+	var saved, images []string
+	for _, att := range msg.Attachments {
+		rel, err := o.saveAttachment(ctx, fetcher, wd, att)
+		if err != nil {
+			return o.reply(ctx, key, "save attachment failed: "+err.Error())
+		}
+		saved = append(saved, rel)
+		if att.Kind == domain.AttachmentPhoto {
+			images = append(images, filepath.Join(wd, rel))
+		}
+	}
+	prompt := caption
+	if prompt == "" {
+		prompt = "See the attached files."
+	}
+	prompt += "\n\nAttached files (relative to the workdir):\n- " + strings.Join(saved, "\n- ")
+	prompt, err = o.withReplyContext(ctx, msg, prompt)
+	if err != nil {
+		return err
+	}
+	return o.enqueueJob(ctx, domain.Job{SessionKey: key, SenderID: msg.SenderID, Executor: exName, Prompt: prompt, Images: images})
+}
+
+// saveAttachment downloads att into the attachments directory of wd and
+// returns its path relative to wd.
+func (o *Orchestrator) saveAttachment(ctx context.Context, fetcher domain.AttachmentFetcher, wd string, att domain.Attachment) (string, error) {
+	if att.Size > o.attachmentMaxBytes {
+		return "", fmt.Errorf("%s is larger than %d bytes", attachmentName(att), o.attachmentMaxBytes)
+	}
+	dir := filepath.Join(wd, o.attachmentsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create attachments dir: %w", err)
+	}
+	body, err := fetcher.FetchAttachment(ctx, att)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", attachmentName(att), err)
+	}
+	defer body.Close()
+
+	// A timestamp prefix keeps repeated uploads of the same name apart.
+	name := time.Now().UTC().Format("20060102-150405") + "-" + attachmentName(att)
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", name, err)
+	}
+	n, err := io.Copy(f, io.LimitReader(body, o.attachmentMaxBytes+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > o.attachmentMaxBytes {
+		err = fmt.Errorf("%s is larger than %d bytes", attachmentName(att), o.attachmentMaxBytes)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return filepath.Join(o.attachmentsDir, name), nil
+}
+
+// attachmentName returns a file name for att that is safe to join to a
+// directory.
+func attachmentName(att domain.Attachment) string {
+	name := filepath.Base(strings.ReplaceAll(att.FileName, "\\", "/"))
+	if name == "." || name == "/" || name == ".." || strings.TrimSpace(name) == "" {
+		name = att.Kind + "-" + att.FileID
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, name)
+}
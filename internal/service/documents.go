@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+// documentOutput uploads events larger than maxChunk as files instead of
+// splitting them over many messages, and counts the job's total output so
+// runJob can send the full transcript when it did not fit in one message.
+// With batched output, once the streamed output exceeds maxChunk further
+// events are left to the transcript instead of being sent twice; a live
+// progress message only keeps the tail of the output and gets every event.
+type documentOutput struct {
+	jobOutput
+	files    domain.FileSender
+	key      domain.SessionKey
+	maxChunk int
+	// flushBefore is set when the wrapped output batches messages, which
+	// must be sent before the file to keep the chat in order.
+	flushBefore bool
+
+	mu       sync.Mutex
+	total    int
+	streamed int
+	cut      bool
+}
+
+func (d *documentOutput) OnEvent(ctx context.Context, ev domain.StreamEvent) error {
+	d.mu.Lock()
+	d.total += len(ev.Chunk)
+	if d.cut {
+		d.mu.Unlock()
+		return nil
+	}
+	if len(ev.Chunk) <= d.maxChunk {
+		d.streamed += len(ev.Chunk)
+		d.cut = d.flushBefore && d.streamed > d.maxChunk
+		cut := d.cut
+		d.mu.Unlock()
+		if !cut {
+			return d.jobOutput.OnEvent(ctx, ev)
+		}
+		return d.jobOutput.OnEvent(ctx, domain.StreamEvent{
+			JobID:  ev.JobID,
+			Seq:    ev.Seq,
+			Chunk:  "<i>📎 the rest of the output follows in the transcript</i>\n",
+			Format: "html",
+			TS:     ev.TS,
+			Stream: ev.Stream,
+		})
+	}
+	d.mu.Unlock()
+	if d.flushBefore {
+		if err := d.jobOutput.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	text := ev.Chunk
+	if ev.Format == "html" {
+		text = render.PlainText(text)
+	}
+	name := fmt.Sprintf("output-%s-%d.txt", ev.JobID, ev.Seq)
+	if ev.Kind == domain.EventKindCommand {
+		name = fmt.Sprintf("command-%s-%d.log", ev.JobID, ev.Seq)
+	}
+	_, err := d.files.SendFile(ctx, domain.OutboundFile{
+		SessionKey: d.key,
+		Name:       name,
+		Content:    []byte(text),
+		Caption:    fileCaption(text),
+	})
+	if err != nil {
+		slog.Error("send output file failed, sending as messages", "job_id", ev.JobID, "seq", ev.Seq, "error", err)
+		return d.jobOutput.OnEvent(ctx, ev)
+	}
+	return d.jobOutput.OnEvent(ctx, domain.StreamEvent{
+		JobID:  ev.JobID,
+		Seq:    ev.Seq,
+		Chunk:  fmt.Sprintf("<i>📎 %s (%d bytes) sent as a file</i>\n", html.EscapeString(name), len(text)),
+		Format: "html",
+		TS:     ev.TS,
+		Stream: ev.Stream,
+	})
+}
+
+func (d *documentOutput) totalBytes() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.total
+}
+
+// fileCaption returns the first few lines of text, which for command output
+// holds the command itself.
+func fileCaption(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == 2 {
+			break
+		}
+	}
+	caption := strings.Join(lines, "\n")
+	if r := []rune(caption); len(r) > 200 {
+		caption = string(r[:200]) + "…"
+	}
+	return caption
+}
+
+// sendTranscript uploads every event of a job as one text file.
+func (o *Orchestrator) sendTranscript(ctx context.Context, key domain.SessionKey, jobID string) error {
+	files, ok := o.transport[key.Platform].(domain.FileSender)
+	if !ok {
+		return o.reply(ctx, key, "files are not supported on this platform")
+	}
+	job, ok, err := o.sessionJob(ctx, key, jobID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return o.reply(ctx, key, "job not found: "+jobID)
+	}
+	events, err := o.store.ListJobEvents(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, ev := range events {
+		if ev.Format == "html" {
+			b.WriteString(render.PlainText(ev.Chunk))
+		} else {
+			b.WriteString(ev.Chunk)
+		}
+	}
+	if b.Len() == 0 {
+		return o.reply(ctx, key, "job "+jobID+" has no output")
+	}
+	_, err = files.SendFile(ctx, domain.OutboundFile{
+		SessionKey: key,
+		Name:       "job-" + job.ID + ".log",
+		Content:    []byte(b.String()),
+		Caption:    fmt.Sprintf("job %s · %s · full transcript", job.ID, job.Status),
+	})
+	return err
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// editOfferTTL is how long an offer to run an edited prompt can be
+// accepted.
+const editOfferTTL = time.Hour
+
+// editOffer is the job to run when the user accepts an edited prompt.
+type editOffer struct {
+	job domain.Job
+	at  time.Time
+}
+
+// handleEdit applies an edited prompt to the job the original message
+// started. A job still waiting in the queue gets the new prompt; for a job
+// that is running or finished the user is offered to run the edited prompt
+// instead.
+func (o *Orchestrator) handleEdit(ctx context.Context, msg domain.Message) error {
+	key := msg.SessionKey
+	first, err := o.store.MarkInboundMessage(ctx, key.Platform, key.ChatID, msg.Meta.MessageID+"#"+msg.Meta.EditID)
+	if err != nil || !first {
+		return err
+	}
+	job, ok, err := o.store.LatestJobForMessage(ctx, key, msg.Meta.MessageID)
+	if err != nil {
+		return err
+	}
+	if !ok || job.SessionKey != key {
+		// Only edits of prompts matter; edited chatter and commands that
+		// started no job are ignored.
+		return nil
+	}
+	if len(msg.Attachments) > 0 {
+		return o.reply(ctx, key, "edited captions are not applied, send the files again")
+	}
+	text := strings.TrimSpace(msg.Text)
+	exName := job.Executor
+	for _, name := range []string{"codex", "claude"} {
+		if strings.HasPrefix(text, "/"+name+" ") {
+			exName = name
+			text = strings.TrimSpace(strings.TrimPrefix(text, "/"+name+" "))
+		}
+	}
+	if text == "" || strings.HasPrefix(text, "/") {
+		return nil
+	}
+	prompt, err := o.withReplyContext(ctx, msg, text)
+	if err != nil {
+		return err
+	}
+	slog.Info("prompt edited", "job_id", job.ID, "status", job.Status, "session_key", key.String())
+
+	if job.Status == domain.JobPending && exName == job.Executor {
+		if _, ok := o.dispatcher.Update(job.ID, func(j *domain.Job) { j.Prompt = prompt }); ok {
+			if err := o.store.UpdateJobPrompt(ctx, job.ID, prompt); err != nil {
+				return err
+			}
+			return o.reply(ctx, key, "queued job "+job.ID+" updated with the edited prompt")
+		}
+		// The job started in the meantime.
+		job.Status = domain.JobRunning
+	}
+
+	o.pruneEdits()
+	o.edits.Store(job.ID, editOffer{at: time.Now(), job: domain.Job{
+		SessionKey:       key,
+		SenderID:         msg.SenderID,
+		Executor:         exName,
+		Prompt:           prompt,
+		Images:           job.Images,
+		ReplyToMessageID: job.ReplyToMessageID,
+	}})
+	text = "job " + job.ID + " already finished, run the edited prompt?"
+	label := "Run edited"
+	if job.Status == domain.JobPending || job.Status == domain.JobRunning {
+		text = "job " + job.ID + " is still running with the original prompt"
+		label = "Stop and re-run"
+	}
+	_, err = o.send(ctx, domain.OutboundMessage{
+		SessionKey: key,
+		Text:       text,
+		Actions:    []domain.Action{{Name: domain.ActionRunEdited, Label: label, Data: job.ID}},
+	})
+	return err
+}
+
+// runEdited stops jobID if it is still active and queues the edited prompt
+// saved for it by handleEdit. The edited prompt is not run when the job
+// could not be stopped, so both prompts never run.
+func (o *Orchestrator) runEdited(ctx context.Context, key domain.SessionKey, jobID string) error {
+	v, ok := o.edits.Load(jobID)
+	if !ok || time.Since(v.(editOffer).at) > editOfferTTL {
+		return o.reply(ctx, key, "edited prompt for job "+jobID+" is no longer available, send it again")
+	}
+	edited := v.(editOffer).job
+	if edited.SessionKey != key {
+		return o.reply(ctx, key, "job not found: "+jobID)
+	}
+	if _, ok := o.edits.LoadAndDelete(jobID); !ok {
+		// Accepted twice; the first one runs it.
+		return nil
+	}
+	job, ok, err := o.sessionJob(ctx, key, jobID)
+	if err != nil {
+		return err
+	}
+	if ok && (job.Status == domain.JobPending || job.Status == domain.JobRunning) {
+		text, stopped, err := o.stop(ctx, key, jobID)
+		if err != nil {
+			return err
+		}
+		if !stopped {
+			return o.reply(ctx, key, "job "+jobID+" could not be stopped, the edited prompt was not run")
+		}
+		if err := o.reply(ctx, key, text); err != nil {
+			return err
+		}
+	}
+	return o.enqueueJob(ctx, edited)
+}
+
+// renewEditOffer offers the edited prompt of a job that ended while the
+// user was offered to stop it and run the edited prompt, as there is
+// nothing left to stop. The earlier button keeps working; accepting either
+// runs the edited prompt once.
+func (o *Orchestrator) renewEditOffer(ctx context.Context, job domain.Job, status domain.JobStatus) {
+	v, ok := o.edits.Load(job.ID)
+	if !ok {
+		return
+	}
+	if time.Since(v.(editOffer).at) > editOfferTTL {
+		o.edits.Delete(job.ID)
+		return
+	}
+	_, err := o.send(ctx, domain.OutboundMessage{
+		SessionKey:       job.SessionKey,
+		Text:             "job " + job.ID + " finished with the original prompt (" + string(status) + "), run the edited prompt?",
+		ReplyToMessageID: job.ReplyToMessageID,
+		Actions:          []domain.Action{{Name: domain.ActionRunEdited, Label: "Run edited", Data: job.ID}},
+	})
+	if err != nil {
+		slog.Error("renew edit offer failed", "job_id", job.ID, "error", err)
+	}
+}
+
+// pruneEdits drops offers that can no longer be accepted.
+func (o *Orchestrator) pruneEdits() {
+	o.edits.Range(func(id, v any) bool {
+		if time.Since(v.(editOffer).at) > editOfferTTL {
+			o.edits.Delete(id)
+		}
+		return true
+	})
+}
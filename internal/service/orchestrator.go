@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"html"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -31,9 +32,49 @@ type Orchestrator struct {
 	transport  map[domain.Platform]domain.Transport
 	dispatcher *queue.Dispatcher
 	jobs       sync.Map
+	// stops holds IDs of jobs asked to stop after they left the queue but
+	// before runJob registered them in jobs; runJob stops them on start.
+	stops sync.Map
+	// edits holds, by job ID, the editOffer to run when the user accepts
+	// an edited prompt.
+	edits sync.Map
 
 	batchInterval time.Duration
 	maxChunkBytes int
+	streamMode    string
+	reactions     string
+
+	attachmentsDir     string
+	attachmentMaxBytes int64
+}
+
+const (
+	// StreamModeBatch sends job output as a series of batched messages.
+	StreamModeBatch = "batch"
+	// StreamModeEdit keeps job output in one message that is edited in
+	// place, on transports that implement domain.MessageEditor.
+	StreamModeEdit = "edit"
+
+	defaultAttachmentsDir     = ".chatcode/attachments"
+	defaultAttachmentMaxBytes = 20 << 20
+)
+
+// Option configures optional Orchestrator behavior.
+type Option func(*Orchestrator)
+
+func WithStreamMode(mode string) Option {
+	return func(o *Orchestrator) {
+		o.streamMode = mode
+	}
+}
+
+// WithAttachments sets the directory, relative to the session workdir,
+// that inbound files are saved to and the largest file accepted.
+func WithAttachments(dir string, maxBytes int64) Option {
+	return func(o *Orchestrator) {
+		o.attachmentsDir = dir
+		o.attachmentMaxBytes = maxBytes
+	}
 }
 
 func NewOrchestrator(
@@ -48,6 +89,7 @@ func NewOrchestrator(
 	perSessionBuffer int,
 	batchInterval time.Duration,
 	maxChunkBytes int,
+	opts ...Option,
 ) *Orchestrator {
 	o := &Orchestrator{
 		store:         st,
@@ -58,6 +100,14 @@ func NewOrchestrator(
 		transport:     transports,
 		batchInterval: batchInterval,
 		maxChunkBytes: maxChunkBytes,
+		streamMode:    StreamModeBatch,
+		reactions:     ReactionsOff,
+
+		attachmentsDir:     defaultAttachmentsDir,
+		attachmentMaxBytes: defaultAttachmentMaxBytes,
+	}
+	for _, opt := range opts {
+		opt(o)
 	}
 	o.dispatcher = queue.NewDispatcher(maxConcurrent, perSessionBuffer, o.runJob)
 	_ = ctx
@@ -65,8 +115,34 @@ func NewOrchestrator(
 }
 
 func (o *Orchestrator) HandleIncomingMessage(ctx context.Context, msg domain.Message) error {
+	ctx = withReplyTo(ctx, msg)
+	if msg.Action != nil {
+		return o.handleAction(ctx, msg)
+	}
+	if msg.Meta.EditID != "" {
+		return o.handleEdit(ctx, msg)
+	}
+	if id := msg.Meta.MessageID; id != "" {
+		first, err := o.store.MarkInboundMessage(ctx, msg.SessionKey.Platform, msg.SessionKey.ChatID, id)
+		if err != nil {
+			return err
+		}
+		if !first {
+			slog.Info("duplicate message ignored",
+				"platform", msg.SessionKey.Platform,
+				"chat_id", msg.SessionKey.ChatID,
+				"message_id", id,
+			)
+			return nil
+		}
+	}
+	if msg.Meta.TopicName != "" {
+		if err := o.bindTopic(ctx, msg.SessionKey, msg.Meta.TopicName); err != nil {
+			return err
+		}
+	}
 	text := strings.TrimSpace(msg.Text)
-	if text == "" {
+	if text == "" && len(msg.Attachments) == 0 {
 		return nil
 	}
 	slog.Info("message received",
@@ -75,20 +151,28 @@ func (o *Orchestrator) HandleIncomingMessage(ctx context.Context, msg domain.Mes
 		"thread_id", msg.SessionKey.ThreadID,
 		"sender_id", msg.SenderID,
 		"text", shorten(text, 200),
+		"attachments", len(msg.Attachments),
 	)
+	if len(msg.Attachments) > 0 {
+		return o.handleAttachments(ctx, msg, text)
+	}
 	if action := o.sessions.TakePendingInput(msg.SessionKey); action != "" {
 		switch action {
 		case "cd":
 			return o.setWorkdir(ctx, msg.SessionKey, text)
 		case "new":
-			return o.createAndSetWorkdir(ctx, msg.SessionKey, text)
+			return o.createAndSetWorkdir(ctx, msg, text)
 		}
 	}
 	if strings.HasPrefix(text, "/") {
 		return o.handleCommand(ctx, msg, text)
 	}
 	exName := o.defaultExecutor(msg.SessionKey)
-	return o.enqueueJob(ctx, msg.SessionKey, exName, text)
+	prompt, err := o.withReplyContext(ctx, msg, text)
+	if err != nil {
+		return err
+	}
+	return o.enqueueJob(ctx, domain.Job{SessionKey: msg.SessionKey, SenderID: msg.SenderID, Executor: exName, Prompt: prompt})
 }
 
 func (o *Orchestrator) handleCommand(ctx context.Context, msg domain.Message, text string) error {
@@ -97,7 +181,7 @@ func (o *Orchestrator) handleCommand(ctx context.Context, msg domain.Message, te
 		return o.reply(ctx, msg.SessionKey, "send project directory path for /new")
 	}
 	if strings.HasPrefix(text, "/new ") {
-		return o.createAndSetWorkdir(ctx, msg.SessionKey, strings.TrimSpace(strings.TrimPrefix(text, "/new ")))
+		return o.createAndSetWorkdir(ctx, msg, strings.TrimSpace(strings.TrimPrefix(text, "/new ")))
 	}
 	if text == "/cd" {
 		root := o.policy.PrimaryRoot()
@@ -117,14 +201,22 @@ func (o *Orchestrator) handleCommand(ctx context.Context, msg domain.Message, te
 		if text == "/codex" {
 			return o.reply(ctx, msg.SessionKey, "default executor set to: codex")
 		}
-		return o.enqueueJob(ctx, msg.SessionKey, "codex", strings.TrimSpace(strings.TrimPrefix(text, "/codex ")))
+		prompt, err := o.withReplyContext(ctx, msg, strings.TrimSpace(strings.TrimPrefix(text, "/codex ")))
+		if err != nil {
+			return err
+		}
+		return o.enqueueJob(ctx, domain.Job{SessionKey: msg.SessionKey, SenderID: msg.SenderID, Executor: "codex", Prompt: prompt})
 	}
 	if text == "/claude" || strings.HasPrefix(text, "/claude ") {
 		o.sessions.SetDefaultExecutor(msg.SessionKey, "claude")
 		if text == "/claude" {
 			return o.reply(ctx, msg.SessionKey, "default executor set to: claude")
 		}
-		return o.enqueueJob(ctx, msg.SessionKey, "claude", strings.TrimSpace(strings.TrimPrefix(text, "/claude ")))
+		prompt, err := o.withReplyContext(ctx, msg, strings.TrimSpace(strings.TrimPrefix(text, "/claude ")))
+		if err != nil {
+			return err
+		}
+		return o.enqueueJob(ctx, domain.Job{SessionKey: msg.SessionKey, SenderID: msg.SenderID, Executor: "claude", Prompt: prompt})
 	}
 	if text == "/reset" {
 		o.sessions.Reset(msg.SessionKey)
@@ -183,16 +275,165 @@ func (o *Orchestrator) handleCommand(ctx context.Context, msg domain.Message, te
 		return o.reply(ctx, msg.SessionKey, "mode set to: "+mode)
 	}
 	if strings.HasPrefix(text, "/stop ") {
-		jobID := strings.TrimSpace(strings.TrimPrefix(text, "/stop "))
-		if cancel, ok := o.jobs.Load(jobID); ok {
-			cancel.(context.CancelFunc)()
-			return o.reply(ctx, msg.SessionKey, "stop signal sent for job "+jobID)
-		}
-		return o.reply(ctx, msg.SessionKey, "job not found: "+jobID)
+		return o.stopJob(ctx, msg.SessionKey, strings.TrimSpace(strings.TrimPrefix(text, "/stop ")))
 	}
 	return o.reply(ctx, msg.SessionKey, "unsupported command")
 }
 
+func (o *Orchestrator) handleAction(ctx context.Context, msg domain.Message) error {
+	action := msg.Action
+	slog.Info("action received",
+		"platform", msg.SessionKey.Platform,
+		"chat_id", msg.SessionKey.ChatID,
+		"sender_id", msg.SenderID,
+		"action", action.Name,
+		"data", action.Data,
+	)
+	switch action.Name {
+	case domain.ActionStopJob:
+		return o.stopJob(ctx, msg.SessionKey, action.Data)
+	case domain.ActionRetryJob:
+		job, ok, err := o.sessionJob(ctx, msg.SessionKey, action.Data)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return o.reply(ctx, msg.SessionKey, "job not found: "+action.Data)
+		}
+		return o.enqueueJob(ctx, domain.Job{
+			SessionKey:       msg.SessionKey,
+			SenderID:         msg.SenderID,
+			Executor:         job.Executor,
+			Prompt:           job.Prompt,
+			Images:           job.Images,
+			ReplyToMessageID: job.ReplyToMessageID,
+		})
+	case domain.ActionShowLog:
+		return o.showJobLog(ctx, msg.SessionKey, action.Data)
+	case domain.ActionTranscript:
+		return o.sendTranscript(ctx, msg.SessionKey, action.Data)
+	case domain.ActionRunEdited:
+		return o.runEdited(ctx, msg.SessionKey, action.Data)
+	}
+	return o.reply(ctx, msg.SessionKey, "unsupported action: "+action.Name)
+}
+
+func (o *Orchestrator) stopJob(ctx context.Context, key domain.SessionKey, jobID string) error {
+	text, _, err := o.stop(ctx, key, jobID)
+	if err != nil {
+		return err
+	}
+	return o.reply(ctx, key, text)
+}
+
+// stop stops jobID if it is running or removes it from the queue, and
+// returns the reply for the user. stopped reports whether the job is one
+// of the session's that will not run on with its prompt.
+func (o *Orchestrator) stop(ctx context.Context, key domain.SessionKey, jobID string) (text string, stopped bool, err error) {
+	job, ok, err := o.sessionJob(ctx, key, jobID)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return "job not found: " + jobID, false, nil
+	}
+	if job.Status != domain.JobPending && job.Status != domain.JobRunning {
+		return alreadyFinished(job), true, nil
+	}
+	// The request is recorded before looking for the job and runJob checks
+	// it after registering, so a job between the queue and jobs is not
+	// missed.
+	o.stops.Store(jobID, struct{}{})
+	if cancel, ok := o.jobs.Load(jobID); ok {
+		o.stops.Delete(jobID)
+		cancel.(context.CancelFunc)()
+		return "stop signal sent for job " + jobID, true, nil
+	}
+	if job, ok := o.dispatcher.Remove(jobID); ok {
+		o.stops.Delete(jobID)
+		finished := time.Now().UTC()
+		if err := o.store.UpdateJobStatus(ctx, job.ID, domain.JobStopped, nil, &finished, "stopped before start"); err != nil {
+			return "", false, err
+		}
+		o.react(ctx, job, domain.JobStopped)
+		return "job removed from queue: " + jobID, true, nil
+	}
+	if job, ok, err := o.store.GetJob(ctx, jobID); err == nil && ok && job.Status != domain.JobPending && job.Status != domain.JobRunning {
+		// It finished in the meantime.
+		o.stops.Delete(jobID)
+		return alreadyFinished(job), true, nil
+	}
+	return "stop signal sent for job " + jobID, true, nil
+}
+
+func alreadyFinished(job domain.Job) string {
+	return "job " + job.ID + " has already finished (" + string(job.Status) + ")"
+}
+
+// sessionJob loads a job and only returns it when it belongs to key, so a
+// chat cannot act on jobs started elsewhere.
+func (o *Orchestrator) sessionJob(ctx context.Context, key domain.SessionKey, jobID string) (domain.Job, bool, error) {
+	job, ok, err := o.store.GetJob(ctx, jobID)
+	if err != nil || !ok {
+		return domain.Job{}, false, err
+	}
+	if job.SessionKey != key {
+		return domain.Job{}, false, nil
+	}
+	return job, true, nil
+}
+
+func (o *Orchestrator) showJobLog(ctx context.Context, key domain.SessionKey, jobID string) error {
+	job, ok, err := o.sessionJob(ctx, key, jobID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return o.reply(ctx, key, "job not found: "+jobID)
+	}
+	events, err := o.store.ListJobEvents(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("<b>job %s</b> · %s", html.EscapeString(job.ID), html.EscapeString(string(job.Status)))
+	if job.SenderID != "" {
+		header += " · by " + html.EscapeString(job.SenderID)
+	}
+	header += "\n\n"
+	body := tailEventsHTML(events, o.maxChunkBytes-len(header))
+	if body == "" {
+		body = "<i>(no output)</i>"
+	}
+	_, err = o.send(ctx, domain.OutboundMessage{SessionKey: key, Text: header + body, Format: "html"})
+	return err
+}
+
+// tailEventsHTML renders the most recent events that fit in limit bytes.
+// Whole events are kept so HTML chunks are never cut inside a tag.
+func tailEventsHTML(events []domain.StreamEvent, limit int) string {
+	var parts []string
+	size := 0
+	for i := len(events) - 1; i >= 0; i-- {
+		chunk := events[i].Chunk
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+		if events[i].Format != "html" {
+			chunk = html.EscapeString(chunk)
+		}
+		if size+len(chunk) > limit {
+			break
+		}
+		size += len(chunk)
+		parts = append(parts, chunk)
+	}
+	var b strings.Builder
+	for i := len(parts) - 1; i >= 0; i-- {
+		b.WriteString(parts[i])
+	}
+	return b.String()
+}
+
 func (o *Orchestrator) setWorkdir(ctx context.Context, key domain.SessionKey, wd string) error {
 	target := wd
 	if !filepath.IsAbs(target) {
@@ -212,7 +453,11 @@ func (o *Orchestrator) setWorkdir(ctx context.Context, key domain.SessionKey, wd
 	return o.reply(ctx, key, "workdir set to: "+target)
 }
 
-func (o *Orchestrator) createAndSetWorkdir(ctx context.Context, key domain.SessionKey, wd string) error {
+// createAndSetWorkdir creates wd and sets it as the session workdir. In
+// forum chats the project gets a topic of its own, whose session is bound
+// instead.
+func (o *Orchestrator) createAndSetWorkdir(ctx context.Context, msg domain.Message, wd string) error {
+	key := msg.SessionKey
 	if wd == "" {
 		return o.reply(ctx, key, "workdir cannot be empty")
 	}
@@ -231,6 +476,9 @@ func (o *Orchestrator) createAndSetWorkdir(ctx context.Context, key domain.Sessi
 	if err := os.MkdirAll(target, 0o755); err != nil {
 		return o.reply(ctx, key, "create workdir failed: "+err.Error())
 	}
+	if topics, ok := o.transport[key.Platform].(domain.TopicCreator); ok && msg.Meta.Forum {
+		return o.createTopic(ctx, topics, key, target)
+	}
 	if err := o.sessions.SetWorkdir(ctx, key, target); err != nil {
 		return err
 	}
@@ -259,7 +507,12 @@ func (o *Orchestrator) listProjects(ctx context.Context, key domain.SessionKey)
 	return o.reply(ctx, key, "projects:\n- "+strings.Join(projects, "\n- "))
 }
 
-func (o *Orchestrator) enqueueJob(ctx context.Context, key domain.SessionKey, exName, prompt string) error {
+// enqueueJob creates and queues a job from req, which carries the session
+// key, sender, executor, prompt and images; everything else is filled in
+// here. The job is threaded under req.ReplyToMessageID, or else under the
+// message being handled.
+func (o *Orchestrator) enqueueJob(ctx context.Context, req domain.Job) error {
+	key, exName, prompt := req.SessionKey, req.Executor, req.Prompt
 	if prompt == "" {
 		return o.reply(ctx, key, "prompt cannot be empty")
 	}
@@ -273,11 +526,18 @@ func (o *Orchestrator) enqueueJob(ctx context.Context, key domain.SessionKey, ex
 	job := domain.Job{
 		ID:         newJobID(),
 		SessionKey: key,
+		SenderID:   req.SenderID,
 		Executor:   exName,
 		Prompt:     prompt,
+		Images:     req.Images,
 		Workdir:    wd,
 		Status:     domain.JobPending,
 		CreatedAt:  time.Now().UTC(),
+
+		ReplyToMessageID: req.ReplyToMessageID,
+	}
+	if job.ReplyToMessageID == "" {
+		job.ReplyToMessageID = replyToFrom(ctx, key)
 	}
 	mode, err := o.sessions.PermissionMode(ctx, key)
 	if err != nil {
@@ -302,36 +562,95 @@ func (o *Orchestrator) enqueueJob(ctx context.Context, key domain.SessionKey, ex
 	if err := o.store.CreateJob(ctx, job); err != nil {
 		return err
 	}
-	o.dispatcher.Enqueue(ctx, job)
-	return o.reply(ctx, key, "job queued: "+job.ID)
+	slog.Info("job created", "job_id", job.ID, "session_key", key.String(), "sender_id", job.SenderID, "executor", exName)
+	if err := o.dispatcher.Enqueue(ctx, job); err != nil {
+		finished := time.Now().UTC()
+		_ = o.store.UpdateJobStatus(ctx, job.ID, domain.JobFailed, nil, &finished, err.Error())
+		return o.reply(ctx, key, "job rejected: "+err.Error())
+	}
+	if o.react(ctx, job, domain.JobPending) && o.reactions == ReactionsOnly {
+		return nil
+	}
+	ref, err := o.send(ctx, domain.OutboundMessage{
+		SessionKey: key,
+		Text:       "job queued: " + job.ID,
+		Actions: []domain.Action{
+			{Name: domain.ActionStopJob, Label: "Stop", Data: job.ID},
+			{Name: domain.ActionRetryJob, Label: "Retry", Data: job.ID},
+			{Name: domain.ActionShowLog, Label: "Show log", Data: job.ID},
+		},
+		ReplyToMessageID: job.ReplyToMessageID,
+	})
+	if err != nil {
+		return err
+	}
+	return o.store.AddJobMessage(ctx, job.ID, ref)
+}
+
+// Idle reports whether no job is queued or running.
+func (o *Orchestrator) Idle() bool {
+	return o.dispatcher.Idle()
+}
+
+// Wait blocks until no job is queued or running, or ctx is done.
+func (o *Orchestrator) Wait(ctx context.Context) error {
+	return o.dispatcher.Wait(ctx)
+}
+
+// Recover restores the job queue persisted by a previous run. It should be
+// called once at startup, before transports begin delivering new messages.
+func (o *Orchestrator) Recover(ctx context.Context) error {
+	interrupted, err := o.dispatcher.Restore(ctx, o.store)
+	for _, job := range interrupted {
+		slog.Warn("job interrupted by restart", "job_id", job.ID, "session_key", job.SessionKey.String())
+		o.react(ctx, job, domain.JobInterrupted)
+		_, replyErr := o.send(ctx, domain.OutboundMessage{
+			SessionKey:       job.SessionKey,
+			Text:             "job interrupted by restart: " + job.ID + "\nsend the prompt again to re-run it",
+			ReplyToMessageID: job.ReplyToMessageID,
+		})
+		if replyErr != nil {
+			slog.Error("notify interrupted job failed", "job_id", job.ID, "error", replyErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("restore job queue: %w", err)
+	}
+	return nil
 }
 
 func (o *Orchestrator) runJob(ctx context.Context, job domain.Job) {
 	ex, ok := o.executors[job.Executor]
 	if !ok {
-		_ = o.reply(ctx, job.SessionKey, "unknown executor: "+job.Executor)
+		o.notify(ctx, domain.OutboundMessage{SessionKey: job.SessionKey, Text: "unknown executor: " + job.Executor})
 		return
 	}
-	started := time.Now().UTC()
-	_ = o.store.UpdateJobStatus(ctx, job.ID, domain.JobRunning, &started, nil, "")
-
 	runCtx, cancel := context.WithCancel(ctx)
 	o.jobs.Store(job.ID, cancel)
 	defer func() {
 		o.jobs.Delete(job.ID)
+		o.stops.Delete(job.ID)
 		cancel()
 	}()
+	if _, ok := o.stops.LoadAndDelete(job.ID); ok {
+		cancel()
+	}
+
+	started := time.Now().UTC()
+	_ = o.store.UpdateJobStatus(ctx, job.ID, domain.JobRunning, &started, nil, "")
+	o.react(ctx, job, domain.JobRunning)
 
 	transport, ok := o.transport[job.SessionKey.Platform]
 	if !ok {
-		_ = o.reply(ctx, job.SessionKey, "transport missing for platform")
+		o.notify(ctx, domain.OutboundMessage{SessionKey: job.SessionKey, Text: "transport missing for platform"})
 		return
 	}
-	batcher := stream.NewBatcher(o.batchInterval, o.maxChunkBytes, transport, job.SessionKey)
+	sender := &jobSender{transport: transport, store: o.store, job: job}
+	output := o.newJobOutput(sender)
 	sessionAware, hasSessionAware := ex.(executor.SessionAware)
 	var sessionMu sync.Mutex
 	sessionID := ""
-	sink := &persistSink{store: o.store, downstream: batcher, onEvent: func(ev *domain.StreamEvent) {
+	sink := &persistSink{store: o.store, downstream: output, onEvent: func(ev *domain.StreamEvent) {
 		if hasSessionAware {
 			if sid := sessionAware.HandleEvent(ev); sid != "" {
 				sessionMu.Lock()
@@ -342,7 +661,21 @@ func (o *Orchestrator) runJob(ctx context.Context, job domain.Job) {
 	}}
 
 	err := o.runner.RunJob(runCtx, ex, job, sink)
-	_ = batcher.Flush(ctx)
+	var (
+		text   string
+		status domain.JobStatus
+	)
+	switch {
+	case err != nil && runCtx.Err() == context.Canceled && ctx.Err() == nil:
+		status, text = domain.JobStopped, "job stopped: "+job.ID
+	case err != nil:
+		status, text = domain.JobFailed, fmt.Sprintf("job failed: %s", err.Error())
+	default:
+		status, text = domain.JobDone, "job done: "+job.ID
+	}
+	if flushErr := finishOutput(ctx, output, status); flushErr != nil {
+		slog.Error("flush job output failed", "job_id", job.ID, "error", flushErr)
+	}
 	if hasSessionAware {
 		sessionMu.Lock()
 		sid := sessionID
@@ -356,24 +689,100 @@ func (o *Orchestrator) runJob(ctx context.Context, job domain.Job) {
 		}
 	}
 	finished := time.Now().UTC()
+	followUp := []domain.Action{
+		{Name: domain.ActionRetryJob, Label: "Retry", Data: job.ID},
+		{Name: domain.ActionShowLog, Label: "Show log", Data: job.ID},
+	}
+	docs, hasDocs := output.(*documentOutput)
+	if hasDocs {
+		followUp = append(followUp, domain.Action{Name: domain.ActionTranscript, Label: "Transcript", Data: job.ID})
+	}
+	errMsg := ""
 	if err != nil {
-		_ = o.store.UpdateJobStatus(ctx, job.ID, domain.JobFailed, &started, &finished, err.Error())
-		_ = o.reply(ctx, job.SessionKey, fmt.Sprintf("job failed: %s", err.Error()))
-		return
+		errMsg = err.Error()
+	}
+	_ = o.store.UpdateJobStatus(ctx, job.ID, status, &started, &finished, errMsg)
+	o.renewEditOffer(ctx, job, status)
+	if !o.react(ctx, job, status) || o.reactions != ReactionsOnly || status != domain.JobDone {
+		sender.notify(ctx, domain.OutboundMessage{SessionKey: job.SessionKey, Text: text, Actions: followUp})
+	}
+	// Output that did not fit in one message is also delivered whole.
+	if hasDocs && docs.totalBytes() > o.maxChunkBytes {
+		if err := o.sendTranscript(ctx, job.SessionKey, job.ID); err != nil {
+			slog.Error("send transcript failed", "job_id", job.ID, "error", err)
+		}
 	}
-	_ = o.store.UpdateJobStatus(ctx, job.ID, domain.JobDone, &started, &finished, "")
-	_ = o.reply(ctx, job.SessionKey, "job done: "+job.ID)
 }
 
-func (o *Orchestrator) reply(ctx context.Context, key domain.SessionKey, text string) error {
-	t, ok := o.transport[key.Platform]
-	if !ok {
-		return nil
+type jobOutput interface {
+	executor.Sink
+	Flush(context.Context) error
+}
+
+// finisher is implemented by outputs whose last rendering shows how the
+// job ended.
+type finisher interface {
+	Finish(context.Context, domain.JobStatus) error
+}
+
+// finishOutput flushes out once the job ended with status.
+func finishOutput(ctx context.Context, out jobOutput, status domain.JobStatus) error {
+	inner := out
+	if docs, ok := out.(*documentOutput); ok {
+		inner = docs.jobOutput
+	}
+	if f, ok := inner.(finisher); ok {
+		return f.Finish(ctx, status)
 	}
-	return t.Send(ctx, domain.OutboundMessage{
+	return out.Flush(ctx)
+}
+
+func (o *Orchestrator) newJobOutput(sender *jobSender) jobOutput {
+	transport, key := sender.transport, sender.job.SessionKey
+	var out jobOutput
+	batched := true
+	if editor, ok := transport.(domain.MessageEditor); ok && o.streamMode == StreamModeEdit {
+		out = stream.NewProgress(o.batchInterval, o.maxChunkBytes, sender, editor, key)
+		batched = false
+	} else {
+		out = stream.NewBatcher(o.batchInterval, o.maxChunkBytes, sender, key)
+	}
+	if files, ok := transport.(domain.FileSender); ok {
+		return &documentOutput{jobOutput: out, files: files, key: key, maxChunk: o.maxChunkBytes, flushBefore: batched}
+	}
+	return out
+}
+
+func (o *Orchestrator) reply(ctx context.Context, key domain.SessionKey, text string) error {
+	_, err := o.send(ctx, domain.OutboundMessage{
 		SessionKey: key,
 		Text:       text,
 	})
+	return err
+}
+
+// notify sends a message nobody is waiting on and logs a failed delivery
+// instead of dropping it silently.
+func (o *Orchestrator) notify(ctx context.Context, msg domain.OutboundMessage) {
+	if _, err := o.send(ctx, msg); err != nil {
+		slog.Error("send message failed",
+			"platform", msg.SessionKey.Platform,
+			"chat_id", msg.SessionKey.ChatID,
+			"text", shorten(msg.Text, 200),
+			"error", err,
+		)
+	}
+}
+
+func (o *Orchestrator) send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	t, ok := o.transport[msg.SessionKey.Platform]
+	if !ok {
+		return domain.MessageRef{}, nil
+	}
+	if msg.ReplyToMessageID == "" {
+		msg.ReplyToMessageID = replyToFrom(ctx, msg.SessionKey)
+	}
+	return t.Send(ctx, msg)
 }
 
 type persistSink struct {
@@ -389,7 +798,13 @@ func (p *persistSink) OnEvent(ctx context.Context, ev domain.StreamEvent) error
 	if err := p.store.AppendEvent(ctx, ev); err != nil {
 		return err
 	}
-	return p.downstream.OnEvent(ctx, ev)
+	if err := p.downstream.OnEvent(ctx, ev); err != nil {
+		// The runner does not act on sink errors; log so lost output is
+		// visible. The event itself is already persisted.
+		slog.Error("deliver job output failed", "job_id", ev.JobID, "seq", ev.Seq, "error", err)
+		return err
+	}
+	return nil
 }
 
 func newJobID() string {
@@ -2,8 +2,10 @@ package service
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -17,17 +19,19 @@ import (
 )
 
 type fakeTransport struct {
-	mu   sync.Mutex
-	msgs []string
+	mu      sync.Mutex
+	msgs    []string
+	replyTo []string
 }
 
 func (f *fakeTransport) Name() string                                       { return "fake" }
 func (f *fakeTransport) Start(context.Context, domain.MessageHandler) error { return nil }
-func (f *fakeTransport) Send(_ context.Context, msg domain.OutboundMessage) error {
+func (f *fakeTransport) Send(_ context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.msgs = append(f.msgs, msg.Text)
-	return nil
+	f.replyTo = append(f.replyTo, msg.ReplyToMessageID)
+	return domain.MessageRef{SessionKey: msg.SessionKey, ID: strconv.Itoa(len(f.msgs))}, nil
 }
 
 type fakeExec struct{}
@@ -582,3 +586,998 @@ func TestOrchestratorModeRejectsInvalidValue(t *testing.T) {
 		t.Fatalf("unexpected mode usage response: %#v", tg.msgs)
 	}
 }
+
+func TestOrchestratorRecoverRequeuesPendingAndReportsInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1", ThreadID: "7"}
+	now := time.Now().UTC()
+	running := domain.Job{ID: "running-job", SessionKey: key, Executor: "codex", Prompt: "a", Workdir: "/tmp", Status: domain.JobPending, CreatedAt: now}
+	pending := domain.Job{ID: "pending-job", SessionKey: key, Executor: "codex", Prompt: "b", Workdir: "/tmp", Status: domain.JobPending, CreatedAt: now.Add(time.Second)}
+	for _, job := range []domain.Job{running, pending} {
+		if err := st.CreateJob(ctx, job); err != nil {
+			t.Fatalf("create job: %v", err)
+		}
+	}
+	if err := st.UpdateJobStatus(ctx, running.ID, domain.JobRunning, &now, nil, ""); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": fakeExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		3500,
+	)
+	if err := o.Recover(ctx); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	job, ok, err := st.GetJob(ctx, running.ID)
+	if err != nil || !ok {
+		t.Fatalf("get running job: ok=%v err=%v", ok, err)
+	}
+	if job.Status != domain.JobInterrupted {
+		t.Fatalf("expected interrupted status, got %q", job.Status)
+	}
+	job, ok, err = st.GetJob(ctx, pending.ID)
+	if err != nil || !ok {
+		t.Fatalf("get pending job: ok=%v err=%v", ok, err)
+	}
+	if job.Status != domain.JobDone || job.SessionKey != key {
+		t.Fatalf("expected restored job done on %v, got %q on %v", key, job.Status, job.SessionKey)
+	}
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	if len(tg.msgs) == 0 || !strings.HasPrefix(tg.msgs[0], "job interrupted by restart: running-job") {
+		t.Fatalf("expected interrupted notice first, got %#v", tg.msgs)
+	}
+}
+
+func TestOrchestratorJobActions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": fakeExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		3500,
+	)
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "/cd /tmp"}); err != nil {
+		t.Fatalf("cd: %v", err)
+	}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "hello"}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	tg.mu.Lock()
+	jobID := ""
+	for _, m := range tg.msgs {
+		if strings.HasPrefix(m, "job queued: ") {
+			jobID = strings.TrimPrefix(m, "job queued: ")
+		}
+	}
+	tg.mu.Unlock()
+	if jobID == "" {
+		t.Fatalf("expected queued job")
+	}
+
+	other := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "2"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: other, Action: &domain.Action{Name: domain.ActionShowLog, Data: jobID}}); err != nil {
+		t.Fatalf("foreign log: %v", err)
+	}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Action: &domain.Action{Name: domain.ActionShowLog, Data: jobID}}); err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Action: &domain.Action{Name: domain.ActionRetryJob, Data: jobID}}); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	n := len(tg.msgs)
+	if n < 3 {
+		t.Fatalf("expected action responses, got %#v", tg.msgs)
+	}
+	if tg.msgs[n-3] != "job not found: "+jobID {
+		t.Fatalf("expected foreign session to be rejected, got %q", tg.msgs[n-3])
+	}
+	if !strings.Contains(tg.msgs[n-2], "job "+jobID+"</b> · done") || !strings.Contains(tg.msgs[n-2], "ok") {
+		t.Fatalf("unexpected log output: %q", tg.msgs[n-2])
+	}
+	if !strings.HasPrefix(tg.msgs[n-1], "job queued: ") || tg.msgs[n-1] == "job queued: "+jobID {
+		t.Fatalf("expected retry to queue a new job, got %q", tg.msgs[n-1])
+	}
+}
+
+func TestOrchestratorStopIsLimitedToSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	sm := session.NewManager(st, time.Hour)
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: 5 * time.Second},
+		map[string]executor.Executor{"codex": slowPromptExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		50*time.Millisecond,
+		3500,
+	)
+	meta := domain.InboundMessageMeta{MessageID: "1", ReplyToMessageID: "1"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "task", Meta: meta}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	job, ok, err := st.LatestJobForMessage(ctx, key, "1")
+	if err != nil || !ok {
+		t.Fatalf("job: %v %v", ok, err)
+	}
+
+	other := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "2"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: other, Text: "/stop " + job.ID}); err != nil {
+		t.Fatalf("foreign stop: %v", err)
+	}
+	tg.mu.Lock()
+	reply := tg.msgs[len(tg.msgs)-1]
+	tg.mu.Unlock()
+	if reply != "job not found: "+job.ID {
+		t.Fatalf("expected foreign session to be rejected, got %q", reply)
+	}
+	// Stopped right after it was queued, whether it is still queued, on
+	// its way to a worker or running.
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "/stop " + job.ID}); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	waitFor(t, func() bool {
+		job, _, _ := st.GetJob(ctx, job.ID)
+		return job.Status == domain.JobStopped
+	})
+
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "/stop " + job.ID}); err != nil {
+		t.Fatalf("stop again: %v", err)
+	}
+	// The stopped job may still be reporting, so the reply is not
+	// necessarily the last message.
+	want := "job " + job.ID + " has already finished (stopped)"
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		for _, m := range tg.msgs {
+			if m == want {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestOrchestratorIgnoresRedeliveredMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": fakeExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		3500,
+	)
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	msg := domain.Message{SessionKey: key, Text: "hello", Meta: domain.InboundMessageMeta{MessageID: "42"}}
+	for i := 0; i < 2; i++ {
+		if err := o.HandleIncomingMessage(ctx, msg); err != nil {
+			t.Fatalf("handle %d: %v", i, err)
+		}
+	}
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	queued := 0
+	for _, m := range tg.msgs {
+		if strings.HasPrefix(m, "job queued: ") {
+			queued++
+		}
+	}
+	if queued != 1 {
+		t.Fatalf("expected exactly one job for a redelivered message, got %d in %#v", queued, tg.msgs)
+	}
+}
+
+type fileTransport struct {
+	fakeTransport
+	files map[string]string
+	sent  []domain.OutboundFile
+}
+
+func (f *fileTransport) SendFile(_ context.Context, file domain.OutboundFile) (domain.MessageRef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, file)
+	return domain.MessageRef{SessionKey: file.SessionKey, ID: "file"}, nil
+}
+
+func (f *fileTransport) FetchAttachment(_ context.Context, att domain.Attachment) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(f.files[att.FileID])), nil
+}
+
+func TestOrchestratorSavesAttachmentsIntoWorkdir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	wd := t.TempDir()
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{wd})
+	tg := &fileTransport{files: map[string]string{"doc1": "panic: boom\n", "ph1": "jpeg"}}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": fakeExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		3500,
+		WithAttachments("uploads", 1024),
+	)
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := sm.SetWorkdir(ctx, key, wd); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	msg := domain.Message{
+		SessionKey: key,
+		SenderID:   "alice",
+		Text:       "why does this crash?",
+		Attachments: []domain.Attachment{
+			{Kind: domain.AttachmentDocument, FileID: "doc1", FileName: "../crash.log"},
+			{Kind: domain.AttachmentPhoto, FileID: "ph1", FileName: "photo-x.jpg"},
+		},
+	}
+	if err := o.HandleIncomingMessage(ctx, msg); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+
+	tg.mu.Lock()
+	jobID := ""
+	for _, m := range tg.msgs {
+		if strings.HasPrefix(m, "job queued: ") {
+			jobID = strings.TrimPrefix(m, "job queued: ")
+		}
+	}
+	tg.mu.Unlock()
+	job, ok, err := st.GetJob(ctx, jobID)
+	if err != nil || !ok {
+		t.Fatalf("expected queued job, got ok=%v err=%v msgs=%#v", ok, err, tg.msgs)
+	}
+	if !strings.HasPrefix(job.Prompt, "why does this crash?\n\nAttached files") ||
+		!strings.Contains(job.Prompt, "-crash.log") || !strings.Contains(job.Prompt, "-photo-x.jpg") {
+		t.Fatalf("unexpected prompt: %q", job.Prompt)
+	}
+	if job.SenderID != "alice" {
+		t.Fatalf("expected sender attribution on job, got %q", job.SenderID)
+	}
+	if len(job.Images) != 1 || !strings.HasPrefix(job.Images[0], filepath.Join(wd, "uploads")) {
+		t.Fatalf("expected photo passed as image, got %#v", job.Images)
+	}
+	logs, _ := filepath.Glob(filepath.Join(wd, "uploads", "*-crash.log"))
+	if len(logs) != 1 {
+		t.Fatalf("expected document saved under uploads, got %#v", logs)
+	}
+	if data, _ := os.ReadFile(logs[0]); string(data) != "panic: boom\n" {
+		t.Fatalf("unexpected file content: %q", data)
+	}
+}
+
+type longOutputExec struct{}
+
+func (longOutputExec) Name() string { return "codex" }
+func (longOutputExec) BuildCommand(context.Context, domain.Job) ([]string, error) {
+	return []string{"/bin/sh", "-c", "echo start; head -c 5000 /dev/zero | tr '\\0' x; echo"}, nil
+}
+
+func TestOrchestratorSendsLongOutputAsFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fileTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: 5 * time.Second},
+		map[string]executor.Executor{"codex": longOutputExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		3500,
+	)
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "hello"}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	time.Sleep(time.Second)
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	if len(tg.sent) != 2 {
+		t.Fatalf("expected the long line and the transcript as files, got %d: %#v", len(tg.sent), tg.msgs)
+	}
+	if !strings.HasPrefix(tg.sent[0].Name, "output-") || len(tg.sent[0].Content) != 5001 {
+		t.Fatalf("unexpected output file: %s (%d bytes)", tg.sent[0].Name, len(tg.sent[0].Content))
+	}
+	if !strings.HasPrefix(tg.sent[1].Name, "job-") || !strings.HasPrefix(string(tg.sent[1].Content), "start\n") {
+		t.Fatalf("unexpected transcript: %s", tg.sent[1].Name)
+	}
+	for _, m := range tg.msgs {
+		if strings.Contains(m, "xxxxxxxxxx") {
+			t.Fatalf("long output should not be sent as a message: %q", shorten(m, 80))
+		}
+	}
+}
+
+type chattyExec struct{}
+
+func (chattyExec) Name() string { return "codex" }
+func (chattyExec) BuildCommand(context.Context, domain.Job) ([]string, error) {
+	return []string{"/bin/sh", "-c", "for i in $(seq 1 200); do echo \"line $i of a long build log\"; done"}, nil
+}
+
+func TestOrchestratorLeavesLongOutputToTranscript(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fileTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: 5 * time.Second},
+		map[string]executor.Executor{"codex": chattyExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		1000,
+	)
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "hello"}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		return len(tg.sent) > 0
+	})
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	if len(tg.sent) != 1 || !strings.HasPrefix(tg.sent[0].Name, "job-") || !strings.Contains(string(tg.sent[0].Content), "line 200 ") {
+		t.Fatalf("expected only the transcript as a file, got %d", len(tg.sent))
+	}
+	streamed := strings.Join(tg.msgs, "\n")
+	if !strings.Contains(streamed, "line 1 ") || strings.Contains(streamed, "line 200 ") || !strings.Contains(streamed, "follows in the transcript") {
+		t.Fatalf("expected output to be cut off in favour of the transcript, got %q", shorten(streamed, 200))
+	}
+}
+
+type editingFileTransport struct {
+	fileTransport
+	edits []string
+}
+
+func (f *editingFileTransport) Edit(_ context.Context, _ domain.MessageRef, msg domain.OutboundMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits = append(f.edits, msg.Text)
+	return nil
+}
+
+func TestOrchestratorKeepsProgressLiveForLongOutput(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &editingFileTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: 5 * time.Second},
+		map[string]executor.Executor{"codex": chattyExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		1000,
+		WithStreamMode(StreamModeEdit),
+	)
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "hello"}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		return len(tg.sent) > 0
+	})
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	shown := strings.Join(append(append([]string(nil), tg.msgs...), tg.edits...), "\n")
+	if !strings.Contains(shown, "line 200 ") || strings.Contains(shown, "follows in the transcript") {
+		t.Fatalf("expected the progress message to show the end of the output, got %q", shorten(shown, 200))
+	}
+	if len(tg.sent) != 1 || !strings.HasPrefix(tg.sent[0].Name, "job-") {
+		t.Fatalf("expected the transcript as a file, got %d files", len(tg.sent))
+	}
+}
+
+type topicTransport struct {
+	fakeTransport
+	created []string
+}
+
+func (f *topicTransport) CreateTopic(_ context.Context, key domain.SessionKey, name string) (domain.SessionKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, name)
+	key.ThreadID = "77"
+	return key, nil
+}
+
+func TestOrchestratorForumTopics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "existing"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	sm := session.NewManager(st, time.Hour)
+	pol := security.New([]string{"codex"}, []string{root})
+	tg := &topicTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": fakeExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		300*time.Millisecond,
+		3500,
+	)
+	general := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "-100"}
+	forum := domain.InboundMessageMeta{Forum: true}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: general, Text: "/new web-app", Meta: forum}); err != nil {
+		t.Fatalf("new in forum: %v", err)
+	}
+	if len(tg.created) != 1 || tg.created[0] != "web-app" {
+		t.Fatalf("expected topic web-app to be created, got %v", tg.created)
+	}
+	topic := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "-100", ThreadID: "77"}
+	wd, err := sm.Workdir(ctx, topic)
+	if err != nil {
+		t.Fatalf("workdir: %v", err)
+	}
+	if want := filepath.Join(root, "web-app"); wd != want {
+		t.Fatalf("expected topic workdir %q, got %q", want, wd)
+	}
+	if wd, _ := sm.Workdir(ctx, general); wd != "" {
+		t.Fatalf("expected general thread to stay unbound, got %q", wd)
+	}
+
+	existing := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "-100", ThreadID: "5"}
+	meta := domain.InboundMessageMeta{MessageID: "10", Forum: true, TopicName: "existing"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: existing, Meta: meta}); err != nil {
+		t.Fatalf("topic message: %v", err)
+	}
+	wd, err = sm.Workdir(ctx, existing)
+	if err != nil {
+		t.Fatalf("workdir: %v", err)
+	}
+	if want := filepath.Join(root, "existing"); wd != want {
+		t.Fatalf("expected auto-bound workdir %q, got %q", want, wd)
+	}
+
+	unknown := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "-100", ThreadID: "6"}
+	meta = domain.InboundMessageMeta{MessageID: "11", Forum: true, TopicName: "chit-chat"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: unknown, Meta: meta}); err != nil {
+		t.Fatalf("topic message: %v", err)
+	}
+	if wd, _ := sm.Workdir(ctx, unknown); wd != "" {
+		t.Fatalf("expected topic without project to stay unbound, got %q", wd)
+	}
+}
+
+type promptExec struct{}
+
+func (promptExec) Name() string { return "codex" }
+func (promptExec) BuildCommand(_ context.Context, job domain.Job) ([]string, error) {
+	return []string{"/bin/sh", "-c", `printf '%s\n' "$1"`, "sh", job.Prompt}, nil
+}
+
+func TestOrchestratorThreadsRepliesAndQuotesEarlierJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	sm := session.NewManager(st, time.Hour)
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": promptExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		50*time.Millisecond,
+		3500,
+	)
+
+	first := domain.Message{SessionKey: key, Text: "count the files", Meta: domain.InboundMessageMeta{MessageID: "100", ReplyToMessageID: "100"}}
+	if err := o.HandleIncomingMessage(ctx, first); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	var doneID string
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		for i, m := range tg.msgs {
+			if strings.HasPrefix(m, "job done: ") {
+				doneID = strconv.Itoa(i + 1)
+				return true
+			}
+		}
+		return false
+	})
+	tg.mu.Lock()
+	for i, m := range tg.msgs {
+		if tg.replyTo[i] != "100" {
+			t.Errorf("expected %q to reply to the prompt, got %q", m, tg.replyTo[i])
+		}
+	}
+	tg.mu.Unlock()
+
+	second := domain.Message{SessionKey: key, Text: "now only go files", Meta: domain.InboundMessageMeta{MessageID: "101", ReplyToMessageID: "101", QuotedMessageID: doneID}}
+	if err := o.HandleIncomingMessage(ctx, second); err != nil {
+		t.Fatalf("handle reply: %v", err)
+	}
+	var prompt string
+	for _, status := range []domain.JobStatus{domain.JobPending, domain.JobRunning, domain.JobDone} {
+		jobs, err := st.ListJobsByStatus(ctx, status)
+		if err != nil {
+			t.Fatalf("list jobs: %v", err)
+		}
+		for _, j := range jobs {
+			if j.ReplyToMessageID == "101" {
+				prompt = j.Prompt
+			}
+		}
+	}
+	for _, want := range []string{"Earlier request:\ncount the files", "Its final output:\n", "Follow-up request:\nnow only go files"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected prompt to contain %q, got:\n%s", want, prompt)
+		}
+	}
+	// Let the second job finish before the store is closed.
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		done := 0
+		for _, m := range tg.msgs {
+			if strings.HasPrefix(m, "job done: ") {
+				done++
+			}
+		}
+		return done == 2
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+type slowPromptExec struct{}
+
+func (slowPromptExec) Name() string { return "codex" }
+func (slowPromptExec) BuildCommand(_ context.Context, job domain.Job) ([]string, error) {
+	return []string{"/bin/sh", "-c", `sleep 0.5; printf '%s\n' "$1"`, "sh", job.Prompt}, nil
+}
+
+func TestOrchestratorAppliesEditedPrompts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	sm := session.NewManager(st, time.Hour)
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: 5 * time.Second},
+		map[string]executor.Executor{"codex": slowPromptExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		50*time.Millisecond,
+		3500,
+	)
+	send := func(id, edit, text string) {
+		t.Helper()
+		meta := domain.InboundMessageMeta{MessageID: id, ReplyToMessageID: id, EditID: edit}
+		if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: text, Meta: meta}); err != nil {
+			t.Fatalf("handle %s: %v", text, err)
+		}
+	}
+	jobFor := func(id string) domain.Job {
+		t.Helper()
+		job, ok, err := st.LatestJobForMessage(ctx, key, id)
+		if err != nil || !ok {
+			t.Fatalf("job for message %s: %v %v", id, ok, err)
+		}
+		return job
+	}
+
+	send("1", "", "first task")
+	send("2", "", "secnd task")
+	waitFor(t, func() bool { return jobFor("1").Status == domain.JobRunning })
+
+	// The second job is still queued behind the first.
+	send("2", "100", "second task")
+	if got := jobFor("2").Prompt; got != "second task" {
+		t.Fatalf("expected queued prompt to be replaced, got %q", got)
+	}
+
+	// The first job is running, so the edit is offered as a re-run.
+	send("1", "101", "first task, fixed")
+	running := jobFor("1")
+	if running.Prompt != "first task" {
+		t.Fatalf("expected running job to keep its prompt, got %q", running.Prompt)
+	}
+	tg.mu.Lock()
+	offer := tg.msgs[len(tg.msgs)-1]
+	tg.mu.Unlock()
+	if !strings.Contains(offer, "still running") {
+		t.Fatalf("expected re-run offer, got %q", offer)
+	}
+	// Redelivered edits are ignored.
+	send("1", "101", "first task, fixed")
+
+	action := &domain.Action{Name: domain.ActionRunEdited, Data: running.ID}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Action: action}); err != nil {
+		t.Fatalf("run edited: %v", err)
+	}
+	waitFor(t, func() bool {
+		job := jobFor("1")
+		return job.ID != running.ID && job.Prompt == "first task, fixed" && job.Status == domain.JobDone
+	})
+	stopped, _, err := st.GetJob(ctx, running.ID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if stopped.Status != domain.JobStopped {
+		t.Fatalf("expected original job to be stopped, got %s", stopped.Status)
+	}
+	events, err := st.ListJobEvents(ctx, jobFor("2").ID)
+	if err != nil {
+		t.Fatalf("events: %v", err)
+	}
+	if out := tailEventsText(events, 1000); !strings.Contains(out, "second task") {
+		t.Fatalf("expected edited queued prompt to run, got %q", out)
+	}
+}
+
+func TestOrchestratorRenewsEditOfferWhenJobFinishes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	sm := session.NewManager(st, time.Hour)
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &fakeTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: 5 * time.Second},
+		map[string]executor.Executor{"codex": slowPromptExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		50*time.Millisecond,
+		3500,
+	)
+	send := func(edit, text string) {
+		t.Helper()
+		meta := domain.InboundMessageMeta{MessageID: "1", ReplyToMessageID: "1", EditID: edit}
+		if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: text, Meta: meta}); err != nil {
+			t.Fatalf("handle %s: %v", text, err)
+		}
+	}
+	send("", "first task")
+	job, ok, err := st.LatestJobForMessage(ctx, key, "1")
+	if err != nil || !ok {
+		t.Fatalf("job: %v %v", ok, err)
+	}
+	waitFor(t, func() bool {
+		job, _, _ := st.GetJob(ctx, job.ID)
+		return job.Status == domain.JobRunning
+	})
+	send("100", "first task, fixed")
+	if _, ok := o.edits.Load(job.ID); !ok {
+		t.Fatal("expected an edit offer")
+	}
+	// The job ends before the offer to stop it is accepted, so the user is
+	// offered to just run the edited prompt.
+	want := "job " + job.ID + " finished with the original prompt (done), run the edited prompt?"
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		for _, m := range tg.msgs {
+			if m == want {
+				return true
+			}
+		}
+		return false
+	})
+
+	action := &domain.Action{Name: domain.ActionRunEdited, Data: job.ID}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Action: action}); err != nil {
+		t.Fatalf("run edited: %v", err)
+	}
+	waitFor(t, func() bool {
+		edited, ok, _ := st.LatestJobForMessage(ctx, key, "1")
+		return ok && edited.ID != job.ID && edited.Prompt == "first task, fixed" && edited.Status == domain.JobDone
+	})
+	if _, ok := o.edits.Load(job.ID); ok {
+		t.Fatal("expected the accepted offer to be consumed")
+	}
+}
+
+type reactorTransport struct {
+	fakeTransport
+	reactions []string
+}
+
+func (f *reactorTransport) React(_ context.Context, ref domain.MessageRef, emoji string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reactions = append(f.reactions, ref.ID+" "+emoji)
+	return nil
+}
+
+func TestOrchestratorReactionsReplaceStatusMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	sm := session.NewManager(st, time.Hour)
+	if err := sm.SetWorkdir(ctx, key, "/tmp"); err != nil {
+		t.Fatalf("set workdir: %v", err)
+	}
+	pol := security.New([]string{"codex"}, []string{"/tmp"})
+	tg := &reactorTransport{}
+	o := NewOrchestrator(
+		ctx,
+		st,
+		sm,
+		pol,
+		executor.Runner{Timeout: time.Second},
+		map[string]executor.Executor{"codex": fakeExec{}},
+		map[domain.Platform]domain.Transport{domain.PlatformTelegram: tg},
+		2,
+		8,
+		50*time.Millisecond,
+		3500,
+		WithStatusReactions(ReactionsOnly),
+	)
+	meta := domain.InboundMessageMeta{MessageID: "9", ReplyToMessageID: "9"}
+	if err := o.HandleIncomingMessage(ctx, domain.Message{SessionKey: key, Text: "hello", Meta: meta}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	waitFor(t, func() bool {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+		return len(tg.reactions) == 3
+	})
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	want := []string{"9 👀", "9 👨‍💻", "9 👍"}
+	for i := range want {
+		if tg.reactions[i] != want[i] {
+			t.Fatalf("expected reactions %q, got %q", want, tg.reactions)
+		}
+	}
+	for _, m := range tg.msgs {
+		if strings.HasPrefix(m, "job queued") || strings.HasPrefix(m, "job done") {
+			t.Fatalf("expected status messages to be replaced by reactions, got %q", m)
+		}
+	}
+}
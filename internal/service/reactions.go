@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"chatcode/internal/domain"
+)
+
+const (
+	// ReactionsOff leaves prompt messages alone.
+	ReactionsOff = "off"
+	// ReactionsOn marks prompts with their job's status in addition to the
+	// "job queued" and "job done" messages.
+	ReactionsOn = "on"
+	// ReactionsOnly marks prompts instead of sending "job queued" and
+	// "job done", and so without their Stop, Retry and Show log buttons;
+	// other status messages are still sent.
+	ReactionsOnly = "only"
+)
+
+// statusReactions are picked from the emojis Telegram accepts as message
+// reactions.
+var statusReactions = map[domain.JobStatus]string{
+	domain.JobPending:     "👀",
+	domain.JobRunning:     "👨‍💻",
+	domain.JobDone:        "👍",
+	domain.JobFailed:      "👎",
+	domain.JobStopped:     "🤷",
+	domain.JobInterrupted: "🤷",
+}
+
+// WithStatusReactions sets whether, and instead of which messages, the
+// prompt that started a job shows the job's status as a reaction. It
+// defaults to ReactionsOff: on Telegram every reaction is a request through
+// the rate-limited outbox, which would delay job output.
+func WithStatusReactions(mode string) Option {
+	return func(o *Orchestrator) {
+		o.reactions = mode
+	}
+}
+
+// react marks the prompt of job with the reaction for status. It reports
+// whether the reaction was set, so callers can fall back to a message.
+func (o *Orchestrator) react(ctx context.Context, job domain.Job, status domain.JobStatus) bool {
+	if o.reactions == ReactionsOff || job.ReplyToMessageID == "" {
+		return false
+	}
+	reactor, ok := o.transport[job.SessionKey.Platform].(domain.MessageReactor)
+	if !ok {
+		return false
+	}
+	ref := domain.MessageRef{SessionKey: job.SessionKey, ID: job.ReplyToMessageID}
+	if err := reactor.React(ctx, ref, statusReactions[status]); err != nil {
+		slog.Error("set status reaction failed", "job_id", job.ID, "status", status, "error", err)
+		return false
+	}
+	return true
+}
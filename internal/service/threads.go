@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+	"chatcode/internal/store"
+)
+
+// replyContextBytes bounds the earlier prompt and output quoted into a
+// follow-up prompt.
+const replyContextBytes = 4000
+
+type replyToKey struct{}
+
+type replyTarget struct {
+	key       domain.SessionKey
+	messageID string
+}
+
+// withReplyTo makes replies sent while handling msg thread under it.
+func withReplyTo(ctx context.Context, msg domain.Message) context.Context {
+	if msg.Meta.ReplyToMessageID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, replyToKey{}, replyTarget{key: msg.SessionKey, messageID: msg.Meta.ReplyToMessageID})
+}
+
+// replyToFrom returns the message replies to key should thread under while
+// handling an inbound message. Messages to other sessions, such as a newly
+// created topic, are not threaded.
+func replyToFrom(ctx context.Context, key domain.SessionKey) string {
+	t, ok := ctx.Value(replyToKey{}).(replyTarget)
+	if !ok || t.key != key {
+		return ""
+	}
+	return t.messageID
+}
+
+// jobSender threads a job's messages under the prompt that started it and
+// records their IDs, so replies to any of them can be traced to the job.
+type jobSender struct {
+	transport domain.Transport
+	store     *store.SQLiteStore
+	job       domain.Job
+}
+
+func (s *jobSender) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	if msg.ReplyToMessageID == "" {
+		msg.ReplyToMessageID = s.job.ReplyToMessageID
+	}
+	ref, err := s.transport.Send(ctx, msg)
+	if err != nil {
+		return ref, err
+	}
+	if err := s.store.AddJobMessage(ctx, s.job.ID, ref); err != nil {
+		slog.Error("record job message failed", "job_id", s.job.ID, "message_id", ref.ID, "error", err)
+	}
+	return ref, nil
+}
+
+// notify is Orchestrator.notify for job messages.
+func (s *jobSender) notify(ctx context.Context, msg domain.OutboundMessage) {
+	if _, err := s.Send(ctx, msg); err != nil {
+		slog.Error("send message failed",
+			"platform", msg.SessionKey.Platform,
+			"chat_id", msg.SessionKey.ChatID,
+			"job_id", s.job.ID,
+			"text", shorten(msg.Text, 200),
+			"error", err,
+		)
+	}
+}
+
+// withReplyContext prefixes prompt with the prompt and final output of the
+// job msg replies to, if it quotes one of the bot's job messages.
+func (o *Orchestrator) withReplyContext(ctx context.Context, msg domain.Message, prompt string) (string, error) {
+	quoted := msg.Meta.QuotedMessageID
+	if quoted == "" || prompt == "" {
+		return prompt, nil
+	}
+	key := msg.SessionKey
+	jobID, ok, err := o.store.JobIDForMessage(ctx, key.Platform, key.ChatID, quoted)
+	if err != nil || !ok {
+		return prompt, err
+	}
+	job, ok, err := o.sessionJob(ctx, key, jobID)
+	if err != nil || !ok {
+		return prompt, err
+	}
+	events, err := o.store.ListJobEvents(ctx, jobID)
+	if err != nil {
+		return prompt, err
+	}
+	output := tailEventsText(events, replyContextBytes)
+	if output == "" {
+		output = "(no output)"
+	}
+	slog.Info("reply context added", "job_id", jobID, "session_key", key.String())
+	return "This message follows up on an earlier request in this chat.\n\n" +
+		"Earlier request:\n" + headText(job.Prompt, replyContextBytes) + "\n\n" +
+		"Its final output:\n" + output + "\n\n" +
+		"Follow-up request:\n" + prompt, nil
+}
+
+// tailEventsText returns the last limit bytes of a job's output as plain
+// text.
+func tailEventsText(events []domain.StreamEvent, limit int) string {
+	var b strings.Builder
+	for _, ev := range events {
+		if ev.Format == "html" {
+			b.WriteString(render.PlainText(ev.Chunk))
+		} else {
+			b.WriteString(ev.Chunk)
+		}
+	}
+	text := strings.TrimSpace(b.String())
+	if len(text) <= limit {
+		return text
+	}
+	cut := len(text) - limit
+	for cut < len(text) && !utf8.RuneStart(text[cut]) {
+		cut++
+	}
+	return "…" + text[cut:]
+}
+
+// headText returns the first limit bytes of text.
+func headText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chatcode/internal/domain"
+)
+
+// createTopic opens a topic named after the project directory target and
+// binds its session to target.
+func (o *Orchestrator) createTopic(ctx context.Context, topics domain.TopicCreator, key domain.SessionKey, target string) error {
+	topicKey, err := topics.CreateTopic(ctx, key, filepath.Base(target))
+	if err != nil {
+		return o.reply(ctx, key, "create topic failed: "+err.Error())
+	}
+	if err := o.sessions.SetWorkdir(ctx, topicKey, target); err != nil {
+		return err
+	}
+	slog.Info("topic created", "session", topicKey.String(), "workdir", target)
+	if err := o.reply(ctx, topicKey, "workdir created and set: "+target); err != nil {
+		return err
+	}
+	return o.reply(ctx, key, "workdir created: "+target+"\ncontinue in the topic "+filepath.Base(target))
+}
+
+// bindTopic sets the workdir of a topic session that has none to the
+// directory under the project root named like the topic, if there is one.
+func (o *Orchestrator) bindTopic(ctx context.Context, key domain.SessionKey, name string) error {
+	root := o.policy.PrimaryRoot()
+	if root == "" || key.ThreadID == "" {
+		return nil
+	}
+	name = strings.TrimSpace(name)
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) {
+		return nil
+	}
+	wd, err := o.sessions.Workdir(ctx, key)
+	if err != nil || wd != "" {
+		return err
+	}
+	target := filepath.Join(root, name)
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		return nil
+	}
+	if err := o.policy.ValidateWorkdir(target); err != nil {
+		return nil
+	}
+	if err := o.sessions.SetWorkdir(ctx, key, target); err != nil {
+		return err
+	}
+	slog.Info("topic bound to workdir", "session", key.String(), "workdir", target)
+	return o.reply(ctx, key, "topic bound to workdir: "+target)
+}
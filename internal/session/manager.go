@@ -2,6 +2,7 @@ package session
 
 import (
 	"context"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -30,6 +31,36 @@ func NewManager(st *store.SQLiteStore, retention time.Duration) *Manager {
 	}
 }
 
+// Sweep deletes what is only kept for the retention period: the IDs of
+// handled inbound messages, recorded to drop redeliveries.
+func (m *Manager) Sweep(ctx context.Context) error {
+	n, err := m.store.DeleteInboundMessagesBefore(ctx, time.Now().Add(-m.retention))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		slog.Info("inbound message IDs pruned", "count", n)
+	}
+	return nil
+}
+
+// RunSweeper calls Sweep right away and then every interval until ctx is
+// done.
+func (m *Manager) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Sweep(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("session retention sweep failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (m *Manager) SetWorkdir(ctx context.Context, key domain.SessionKey, workdir string) error {
 	m.mu.Lock()
 	m.workdirs[key.String()] = workdir
@@ -81,3 +81,32 @@ func TestPermissionModeResetClearsCacheButKeepsPersistedValue(t *testing.T) {
 		t.Fatalf("expected full-access from store after reset, got %q", mode)
 	}
 }
+
+func TestSweepForgetsOldInboundMessages(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "test.db")
+	st, err := store.NewSQLiteStore(db)
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	if _, err := st.MarkInboundMessage(ctx, domain.PlatformTelegram, "1", "old"); err != nil {
+		t.Fatalf("mark: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := st.MarkInboundMessage(ctx, domain.PlatformTelegram, "1", "new"); err != nil {
+		t.Fatalf("mark: %v", err)
+	}
+
+	m := NewManager(st, 25*time.Millisecond)
+	if err := m.Sweep(ctx); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if first, _ := st.MarkInboundMessage(ctx, domain.PlatformTelegram, "1", "old"); !first {
+		t.Fatal("expected old message ID to be forgotten")
+	}
+	if first, _ := st.MarkInboundMessage(ctx, domain.PlatformTelegram, "1", "new"); first {
+		t.Fatal("expected recent message ID to be kept")
+	}
+}
@@ -65,8 +65,54 @@ CREATE TABLE IF NOT EXISTS executor_sessions (
     PRIMARY KEY (executor, platform, chat_id, thread_id, workdir)
 );
 CREATE INDEX IF NOT EXISTS idx_executor_sessions_updated_at ON executor_sessions(updated_at);
+
+CREATE TABLE IF NOT EXISTS transport_state (
+    platform TEXT NOT NULL,
+    state_key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, state_key)
+);
+
+CREATE TABLE IF NOT EXISTS inbound_messages (
+    platform TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    received_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, chat_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS idx_inbound_messages_received_at ON inbound_messages(received_at);
+
+CREATE TABLE IF NOT EXISTS job_messages (
+    platform TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, chat_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS idx_job_messages_job_id ON job_messages(job_id);
 `
 
+// columnMigrations adds columns introduced after the initial schema. SQLite
+// has no "ADD COLUMN IF NOT EXISTS", so each entry is applied only when the
+// column is missing from the table.
+var columnMigrations = []struct {
+	table  string
+	column string
+	def    string
+}{
+	{"jobs", "platform", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "chat_id", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "thread_id", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "permission_mode", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "executor_session", "TEXT NOT NULL DEFAULT ''"},
+	{"events", "format", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "images", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "sender_id", "TEXT NOT NULL DEFAULT ''"},
+	{"jobs", "reply_to_message_id", "TEXT NOT NULL DEFAULT ''"},
+}
+
 type SQLiteStore struct {
 	db *sql.DB
 }
@@ -97,6 +143,35 @@ func (s *SQLiteStore) Migrate(ctx context.Context) error {
 	if _, err := s.db.ExecContext(ctx, initSQL); err != nil {
 		return fmt.Errorf("run migration: %w", err)
 	}
+	for _, m := range columnMigrations {
+		if err := s.ensureColumn(ctx, m.table, m.column, m.def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ensureColumn(ctx context.Context, table, column, def string) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("inspect %s columns: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -129,15 +204,101 @@ func (s *SQLiteStore) SessionWorkdir(ctx context.Context, key domain.SessionKey)
 
 func (s *SQLiteStore) CreateJob(ctx context.Context, job domain.Job) error {
 	_, err := s.db.ExecContext(ctx, `
-	INSERT INTO jobs(id, session_key, executor, prompt, workdir, status, created_at, error_message)
-	VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
-		job.ID, job.SessionKey.String(), job.Executor, job.Prompt, job.Workdir, job.Status, job.CreatedAt.UTC(), job.ErrorMessage)
+	INSERT INTO jobs(id, session_key, platform, chat_id, thread_id, sender_id, executor, permission_mode, executor_session, prompt, images, reply_to_message_id, workdir, status, created_at, error_message)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.SessionKey.String(), string(job.SessionKey.Platform), job.SessionKey.ChatID, job.SessionKey.ThreadID, job.SenderID,
+		job.Executor, job.PermissionMode, job.Session, job.Prompt, strings.Join(job.Images, "\n"), job.ReplyToMessageID, job.Workdir, job.Status, job.CreatedAt.UTC(), job.ErrorMessage)
 	if err != nil {
 		return fmt.Errorf("create job: %w", err)
 	}
 	return nil
 }
 
+const jobColumns = `id, session_key, platform, chat_id, thread_id, sender_id, executor, permission_mode, executor_session,
+	prompt, images, reply_to_message_id, workdir, status, created_at, started_at, finished_at, error_message`
+
+// ListJobsByStatus returns jobs in the given status ordered by creation time,
+// so callers re-enqueueing them preserve per-session submission order.
+func (s *SQLiteStore) ListJobsByStatus(ctx context.Context, status domain.JobStatus) ([]domain.Job, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE status=? ORDER BY created_at, rowid`, status)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	defer rows.Close()
+	var jobs []domain.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list jobs: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (s *SQLiteStore) GetJob(ctx context.Context, jobID string) (domain.Job, bool, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id=?`, jobID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Job{}, false, nil
+	}
+	if err != nil {
+		return domain.Job{}, false, fmt.Errorf("get job: %w", err)
+	}
+	return job, true, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row rowScanner) (domain.Job, error) {
+	var (
+		job        domain.Job
+		sessionKey string
+		platform   string
+		images     string
+		startedAt  sql.NullTime
+		finishedAt sql.NullTime
+	)
+	if err := row.Scan(&job.ID, &sessionKey, &platform, &job.SessionKey.ChatID, &job.SessionKey.ThreadID, &job.SenderID,
+		&job.Executor, &job.PermissionMode, &job.Session, &job.Prompt, &images, &job.ReplyToMessageID, &job.Workdir, &job.Status,
+		&job.CreatedAt, &startedAt, &finishedAt, &job.ErrorMessage); err != nil {
+		return domain.Job{}, err
+	}
+	job.SessionKey.Platform = domain.Platform(platform)
+	if platform == "" {
+		// Rows written before the key columns existed only carry session_key.
+		job.SessionKey = parseSessionKey(sessionKey)
+	}
+	if images != "" {
+		job.Images = strings.Split(images, "\n")
+	}
+	if startedAt.Valid {
+		t := startedAt.Time
+		job.StartedAt = &t
+	}
+	if finishedAt.Valid {
+		t := finishedAt.Time
+		job.FinishedAt = &t
+	}
+	return job, nil
+}
+
+func parseSessionKey(v string) domain.SessionKey {
+	parts := strings.SplitN(v, ":", 3)
+	key := domain.SessionKey{Platform: domain.Platform(parts[0])}
+	if len(parts) > 1 {
+		key.ChatID = parts[1]
+	}
+	if len(parts) > 2 {
+		key.ThreadID = parts[2]
+	}
+	return key
+}
+
 func (s *SQLiteStore) UpdateJobStatus(ctx context.Context, jobID string, status domain.JobStatus, startedAt, finishedAt *time.Time, errMsg string) error {
 	_, err := s.db.ExecContext(ctx, `
 	UPDATE jobs SET status=?, started_at=?, finished_at=?, error_message=? WHERE id=?`,
@@ -150,15 +311,44 @@ func (s *SQLiteStore) UpdateJobStatus(ctx context.Context, jobID string, status
 
 func (s *SQLiteStore) AppendEvent(ctx context.Context, ev domain.StreamEvent) error {
 	_, err := s.db.ExecContext(ctx, `
-	INSERT INTO events(job_id, seq, chunk, stream, is_final, ts, exit_code)
-	VALUES(?, ?, ?, ?, ?, ?, ?)`,
-		ev.JobID, ev.Seq, ev.Chunk, ev.Stream, ev.IsFinal, ev.TS.UTC(), ev.ExitCode)
+	INSERT INTO events(job_id, seq, chunk, format, stream, is_final, ts, exit_code)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		ev.JobID, ev.Seq, ev.Chunk, ev.Format, ev.Stream, ev.IsFinal, ev.TS.UTC(), ev.ExitCode)
 	if err != nil {
 		return fmt.Errorf("append event: %w", err)
 	}
 	return nil
 }
 
+func (s *SQLiteStore) ListJobEvents(ctx context.Context, jobID string) ([]domain.StreamEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT job_id, seq, chunk, format, stream, is_final, ts, exit_code
+	FROM events WHERE job_id=? ORDER BY seq, id`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("list job events: %w", err)
+	}
+	defer rows.Close()
+	var events []domain.StreamEvent
+	for rows.Next() {
+		var (
+			ev       domain.StreamEvent
+			exitCode sql.NullInt64
+		)
+		if err := rows.Scan(&ev.JobID, &ev.Seq, &ev.Chunk, &ev.Format, &ev.Stream, &ev.IsFinal, &ev.TS, &exitCode); err != nil {
+			return nil, fmt.Errorf("list job events: %w", err)
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			ev.ExitCode = &code
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list job events: %w", err)
+	}
+	return events, nil
+}
+
 func (s *SQLiteStore) GetExecutorSession(ctx context.Context, executor string, key domain.SessionKey, workdir string) (string, error) {
 	var sessionID string
 	err := s.db.QueryRowContext(ctx, `
@@ -188,6 +378,120 @@ func (s *SQLiteStore) UpsertExecutorSession(ctx context.Context, executor string
 	return nil
 }
 
+// TransportState returns a value a transport persisted with
+// SetTransportState, or "" when none was stored.
+func (s *SQLiteStore) TransportState(ctx context.Context, platform domain.Platform, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM transport_state WHERE platform=? AND state_key=?`, string(platform), key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get transport state: %w", err)
+	}
+	return value, nil
+}
+
+func (s *SQLiteStore) SetTransportState(ctx context.Context, platform domain.Platform, key, value string) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO transport_state(platform, state_key, value, updated_at)
+	VALUES(?, ?, ?, ?)
+	ON CONFLICT(platform, state_key) DO UPDATE SET
+	value=excluded.value,
+	updated_at=excluded.updated_at`,
+		string(platform), key, value, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("set transport state: %w", err)
+	}
+	return nil
+}
+
+// MarkInboundMessage records a platform message ID and reports whether it is
+// seen for the first time. Redelivered messages return false.
+func (s *SQLiteStore) MarkInboundMessage(ctx context.Context, platform domain.Platform, chatID, messageID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+	INSERT OR IGNORE INTO inbound_messages(platform, chat_id, message_id, received_at)
+	VALUES(?, ?, ?, ?)`,
+		string(platform), chatID, messageID, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("mark inbound message: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("mark inbound message: %w", err)
+	}
+	return n == 1, nil
+}
+
+// DeleteInboundMessagesBefore forgets message IDs recorded before t and
+// returns how many were deleted.
+func (s *SQLiteStore) DeleteInboundMessagesBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM inbound_messages WHERE received_at < ?`, t.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete inbound messages: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete inbound messages: %w", err)
+	}
+	return n, nil
+}
+
+// LatestJobForMessage returns the newest job in key's chat that was
+// threaded under messageID, i.e. started by that message.
+func (s *SQLiteStore) LatestJobForMessage(ctx context.Context, key domain.SessionKey, messageID string) (domain.Job, bool, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs
+	WHERE platform=? AND chat_id=? AND reply_to_message_id=? ORDER BY created_at DESC, rowid DESC LIMIT 1`,
+		string(key.Platform), key.ChatID, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Job{}, false, nil
+	}
+	if err != nil {
+		return domain.Job{}, false, fmt.Errorf("get job for message: %w", err)
+	}
+	return job, true, nil
+}
+
+// UpdateJobPrompt replaces the prompt of a job that has not started yet.
+func (s *SQLiteStore) UpdateJobPrompt(ctx context.Context, jobID, prompt string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE jobs SET prompt=? WHERE id=?`, prompt, jobID)
+	if err != nil {
+		return fmt.Errorf("update job prompt: %w", err)
+	}
+	return nil
+}
+
+// AddJobMessage records that ref was sent for a job, so replies to it can be
+// traced back to the job. References without an ID are ignored.
+func (s *SQLiteStore) AddJobMessage(ctx context.Context, jobID string, ref domain.MessageRef) error {
+	if ref.ID == "" {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `
+	INSERT OR IGNORE INTO job_messages(platform, chat_id, message_id, job_id, created_at)
+	VALUES(?, ?, ?, ?, ?)`,
+		string(ref.SessionKey.Platform), ref.SessionKey.ChatID, ref.ID, jobID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("add job message: %w", err)
+	}
+	return nil
+}
+
+// JobIDForMessage returns the job a sent message belongs to.
+func (s *SQLiteStore) JobIDForMessage(ctx context.Context, platform domain.Platform, chatID, messageID string) (string, bool, error) {
+	var jobID string
+	err := s.db.QueryRowContext(ctx, `
+	SELECT job_id FROM job_messages WHERE platform=? AND chat_id=? AND message_id=?`,
+		string(platform), chatID, messageID).Scan(&jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("get job for message: %w", err)
+	}
+	return jobID, true, nil
+}
+
 func (s *SQLiteStore) SessionPermissionMode(ctx context.Context, key domain.SessionKey) (string, error) {
 	var contextJSON string
 	err := s.db.QueryRowContext(ctx, `SELECT context_json FROM sessions WHERE session_key = ?`, key.String()).Scan(&contextJSON)
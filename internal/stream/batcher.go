@@ -2,23 +2,42 @@ package stream
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
 	"chatcode/internal/domain"
+	"chatcode/internal/render"
 )
 
 type Sender interface {
-	Send(context.Context, domain.OutboundMessage) error
+	Send(context.Context, domain.OutboundMessage) (domain.MessageRef, error)
 }
 
+// Batcher coalesces stream events into outbound messages. Events arriving
+// within one interval are joined into a single message; a batch is cut when
+// its timer fires, when the next event would push it past maxChunk, when the
+// event format changes, or on Flush. Cut batches are sent in order without
+// holding up OnEvent, so a throttled transport does not stall the executor
+// until a batch is full.
 type Batcher struct {
 	interval time.Duration
 	maxChunk int
 	sender   Sender
 	key      domain.SessionKey
 
-	mu sync.Mutex
+	mu      sync.Mutex
+	buf     strings.Builder
+	format  string
+	timer   *time.Timer
+	gen     uint64
+	ctx     context.Context
+	pending []domain.OutboundMessage
+	err     error
+
+	// sendMu is held by whoever is draining pending, which keeps batches in
+	// order when the timer and OnEvent cut batches concurrently.
+	sendMu sync.Mutex
 }
 
 func NewBatcher(interval time.Duration, maxChunk int, sender Sender, key domain.SessionKey) *Batcher {
@@ -39,27 +58,106 @@ func (b *Batcher) OnEvent(ctx context.Context, ev domain.StreamEvent) error {
 		return nil
 	}
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	return b.sendLocked(ctx, ev.Chunk, ev.Format)
+	if err := b.takeErrLocked(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	if b.buf.Len() > 0 && (ev.Format != b.format || b.buf.Len()+len(ev.Chunk) > b.maxChunk) {
+		b.cutLocked()
+	}
+	b.format = ev.Format
+	b.buf.WriteString(ev.Chunk)
+	full := b.buf.Len() >= b.maxChunk
+	if full {
+		b.cutLocked()
+	} else if b.timer == nil {
+		// The timer outlives the event's context: a batch collected before a
+		// job is stopped should still reach the chat.
+		b.ctx = context.WithoutCancel(ctx)
+		b.gen++
+		gen := b.gen
+		b.timer = time.AfterFunc(b.interval, func() { b.onTimer(gen) })
+	}
+	b.mu.Unlock()
+	if full {
+		return b.drain(ctx)
+	}
+	return nil
 }
 
+// Flush sends whatever is buffered and reports any error from a send that
+// happened on the batch timer since the last call.
 func (b *Batcher) Flush(ctx context.Context) error {
-	_ = ctx
-	return nil
+	b.mu.Lock()
+	b.cutLocked()
+	b.mu.Unlock()
+	if err := b.drain(ctx); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.takeErrLocked()
 }
 
-func (b *Batcher) sendLocked(ctx context.Context, msg, format string) error {
-	if b.maxChunk <= 0 || len(msg) <= b.maxChunk {
-		return b.sender.Send(ctx, domain.OutboundMessage{SessionKey: b.key, Text: msg, Format: format})
+func (b *Batcher) onTimer(gen uint64) {
+	b.mu.Lock()
+	if b.timer == nil || gen != b.gen {
+		// The batch this timer was armed for has already been cut.
+		b.mu.Unlock()
+		return
 	}
-	for len(msg) > b.maxChunk {
-		if err := b.sender.Send(ctx, domain.OutboundMessage{SessionKey: b.key, Text: msg[:b.maxChunk], Format: format}); err != nil {
-			return err
+	b.cutLocked()
+	ctx := b.ctx
+	b.mu.Unlock()
+	if err := b.drain(ctx); err != nil {
+		b.mu.Lock()
+		if b.err == nil {
+			b.err = err
 		}
-		msg = msg[b.maxChunk:]
+		b.mu.Unlock()
 	}
-	if msg != "" {
-		return b.sender.Send(ctx, domain.OutboundMessage{SessionKey: b.key, Text: msg, Format: format})
+}
+
+// cutLocked moves the buffer to the pending queue, split to maxChunk
+// without breaking runes or HTML tags.
+func (b *Batcher) cutLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
 	}
-	return nil
+	msg := b.buf.String()
+	b.buf.Reset()
+	if strings.TrimSpace(msg) == "" {
+		return
+	}
+	for _, part := range render.Split(msg, b.format, b.maxChunk) {
+		b.pending = append(b.pending, domain.OutboundMessage{SessionKey: b.key, Text: part, Format: b.format})
+	}
+}
+
+// drain sends pending batches in order and returns the first send error.
+// Later batches are still attempted after a failure.
+func (b *Batcher) drain(ctx context.Context) error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	var firstErr error
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.mu.Unlock()
+			return firstErr
+		}
+		msg := b.pending[0]
+		b.pending = b.pending[1:]
+		b.mu.Unlock()
+		if _, err := b.sender.Send(ctx, msg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+}
+
+func (b *Batcher) takeErrLocked() error {
+	err := b.err
+	b.err = nil
+	return err
 }
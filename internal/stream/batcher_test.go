@@ -2,35 +2,116 @@ package stream
 
 import (
 	"context"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"chatcode/internal/domain"
 )
 
 type fakeSender struct {
-	mu   sync.Mutex
-	msgs []string
+	mu      sync.Mutex
+	msgs    []string
+	formats []string
 }
 
-func (f *fakeSender) Send(_ context.Context, msg domain.OutboundMessage) error {
+func (f *fakeSender) Send(_ context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.msgs = append(f.msgs, msg.Text)
-	return nil
+	f.formats = append(f.formats, msg.Format)
+	return domain.MessageRef{SessionKey: msg.SessionKey, ID: strconv.Itoa(len(f.msgs))}, nil
 }
 
-func TestBatcherSendImmediately(t *testing.T) {
+func (f *fakeSender) snapshot() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.msgs...)
+}
+
+func TestBatcherCoalescesWithinInterval(t *testing.T) {
+	s := &fakeSender{}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	b := NewBatcher(300*time.Millisecond, 100, s, key)
+	ctx := context.Background()
+
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "a\n"})
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "b\n"})
+	if got := s.snapshot(); len(got) != 0 {
+		t.Fatalf("expected no send before interval, got %#v", got)
+	}
+
+	time.Sleep(450 * time.Millisecond)
+	got := s.snapshot()
+	if len(got) != 1 || got[0] != "a\nb\n" {
+		t.Fatalf("expected one coalesced message, got %#v", got)
+	}
+}
+
+func TestBatcherFlushSendsBufferedEvents(t *testing.T) {
+	s := &fakeSender{}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	b := NewBatcher(500*time.Millisecond, 100, s, key)
+	ctx := context.Background()
+
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "a\n"})
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	time.Sleep(600 * time.Millisecond)
+
+	got := s.snapshot()
+	if len(got) != 1 || got[0] != "a\n" {
+		t.Fatalf("expected exactly one flushed message, got %#v", got)
+	}
+}
+
+func TestBatcherRespectsMaxChunkAndFormat(t *testing.T) {
+	s := &fakeSender{}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	b := NewBatcher(300*time.Millisecond, 10, s, key)
+	ctx := context.Background()
+
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "123456\n"})
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "7890\n"})
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "<b>x</b>\n", Format: "html"})
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: strings.Repeat("z", 15)})
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := []string{"123456\n", "7890\n", "<b>x</b>\n", "zzzzzzzzzz", "zzzzz"}
+	if strings.Join(s.msgs, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected messages: %#v", s.msgs)
+	}
+	if s.formats[2] != "html" || s.formats[3] != "" {
+		t.Fatalf("unexpected formats: %#v", s.formats)
+	}
+}
+
+func TestBatcherSplitsHTMLWithBalancedTags(t *testing.T) {
 	s := &fakeSender{}
 	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
-	b := NewBatcher(300, 100, s, key)
+	b := NewBatcher(300*time.Millisecond, 40, s, key)
 	ctx := context.Background()
 
-	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "a"})
+	_ = b.OnEvent(ctx, domain.StreamEvent{Chunk: "<pre>" + strings.Repeat("line\n", 12) + "</pre>", Format: "html"})
+	if err := b.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if len(s.msgs) != 1 || s.msgs[0] != "a" {
-		t.Fatalf("expected immediate single send, got %#v", s.msgs)
+	if len(s.msgs) < 2 {
+		t.Fatalf("expected the block to be split, got %#v", s.msgs)
+	}
+	for _, msg := range s.msgs {
+		if len(msg) > 40 || !strings.HasPrefix(msg, "<pre>") || !strings.HasSuffix(msg, "</pre>") {
+			t.Fatalf("unbalanced or oversized part: %q", msg)
+		}
 	}
 }
@@ -0,0 +1,267 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+type Editor interface {
+	Edit(context.Context, domain.MessageRef, domain.OutboundMessage) error
+}
+
+const (
+	// Chat platforms throttle edits far more aggressively than the batch
+	// interval, so the progress message is redrawn at most this often.
+	progressMinInterval = time.Second
+	// Without new output the message is still redrawn periodically so the
+	// elapsed time keeps moving.
+	progressHeartbeat = 5 * time.Second
+	progressStepLimit = 80
+)
+
+// Progress renders a job's output into a single message that is edited in
+// place as events arrive. When the body approaches maxChunk the current
+// message is finalized and a new one is started below it. Messages are sent
+// and edited from the redraw loop, so OnEvent never waits on a throttled
+// transport.
+type Progress struct {
+	interval time.Duration
+	maxChunk int
+	sender   Sender
+	editor   Editor
+	key      domain.SessionKey
+	started  time.Time
+
+	mu   sync.Mutex
+	body strings.Builder
+	// full holds the bodies of messages that reached maxChunk and still
+	// have to be finalized in the chat.
+	full       []string
+	step       string
+	lastRender time.Time
+	dirty      bool
+	stop       chan struct{}
+	finished   bool
+	err        error
+
+	// renderMu is held while talking to the transport and guards the state
+	// of the message being edited.
+	renderMu sync.Mutex
+	ref      domain.MessageRef
+	sent     bool
+	lastText string
+}
+
+func NewProgress(interval time.Duration, maxChunk int, sender Sender, editor Editor, key domain.SessionKey) *Progress {
+	if interval < progressMinInterval {
+		interval = progressMinInterval
+	}
+	if maxChunk <= 0 {
+		maxChunk = 3500
+	}
+	return &Progress{
+		interval: interval,
+		maxChunk: maxChunk,
+		sender:   sender,
+		editor:   editor,
+		key:      key,
+		started:  time.Now(),
+	}
+}
+
+func (p *Progress) OnEvent(ctx context.Context, ev domain.StreamEvent) error {
+	if ev.Chunk == "" {
+		return nil
+	}
+	chunk := ev.Chunk
+	if ev.Format != "html" {
+		chunk = html.EscapeString(chunk)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if step := lastStep(chunk); step != "" {
+		p.step = step
+	}
+	for _, piece := range render.SplitHTML(chunk, p.maxChunk) {
+		if p.body.Len() > 0 && p.body.Len()+len(piece) > p.maxChunk {
+			p.full = append(p.full, p.body.String())
+			p.body.Reset()
+		}
+		p.body.WriteString(piece)
+	}
+	p.dirty = true
+	if p.stop == nil && !p.finished {
+		p.stop = make(chan struct{})
+		go p.run(context.WithoutCancel(ctx), p.stop)
+	}
+	err := p.err
+	p.err = nil
+	return err
+}
+
+// Flush stops the redraw loop and renders the final state of the message
+// for a job that finished successfully.
+func (p *Progress) Flush(ctx context.Context) error {
+	return p.Finish(ctx, domain.JobDone)
+}
+
+// Finish stops the redraw loop and renders the final state of the message,
+// with a header showing how the job ended.
+func (p *Progress) Finish(ctx context.Context, status domain.JobStatus) error {
+	p.mu.Lock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.finished = true
+	p.mu.Unlock()
+	icon, state := "✅", "finished"
+	switch status {
+	case domain.JobFailed:
+		icon, state = "❌", "failed"
+	case domain.JobStopped:
+		icon, state = "⏹", "stopped"
+	case domain.JobInterrupted:
+		icon, state = "⏹", "interrupted"
+	}
+	if err := p.render(ctx, icon, state, true); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.err
+	p.err = nil
+	return err
+}
+
+func (p *Progress) run(ctx context.Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		due := !p.finished && (p.dirty || len(p.full) > 0 || time.Since(p.lastRender) >= progressHeartbeat)
+		p.mu.Unlock()
+		if !due {
+			continue
+		}
+		if err := p.render(ctx, "⏳", "running", false); err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// render brings the chat up to date: messages that filled up are finalized
+// and the current one is redrawn with the given state. The output is
+// snapshotted under mu and sent without it, so events keep arriving while
+// the transport waits out a rate limit. Only the final render runs once
+// the job has finished.
+func (p *Progress) render(ctx context.Context, icon, state string, final bool) error {
+	p.renderMu.Lock()
+	defer p.renderMu.Unlock()
+	var firstErr error
+	for {
+		p.mu.Lock()
+		if p.finished && !final {
+			p.mu.Unlock()
+			return firstErr
+		}
+		if len(p.full) == 0 {
+			break
+		}
+		body := p.full[0]
+		p.full = p.full[1:]
+		header := p.headerLocked("⏬", "continued below")
+		p.mu.Unlock()
+		if err := p.show(ctx, header, body); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		p.ref = domain.MessageRef{}
+		p.sent = false
+		p.lastText = ""
+	}
+	if final && p.body.Len() == 0 && !p.sent {
+		p.mu.Unlock()
+		return firstErr
+	}
+	header := p.headerLocked(icon, state)
+	body := p.body.String()
+	p.dirty = false
+	p.mu.Unlock()
+	if err := p.show(ctx, header, body); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// show sends or edits the current message to header and body. It is called
+// with renderMu held.
+func (p *Progress) show(ctx context.Context, header, body string) error {
+	text := header + "\n\n" + body
+	if text == p.lastText {
+		return nil
+	}
+	msg := domain.OutboundMessage{SessionKey: p.key, Text: text, Format: "html"}
+	switch {
+	case !p.sent:
+		ref, err := p.sender.Send(ctx, msg)
+		if err != nil {
+			return err
+		}
+		p.ref = ref
+		p.sent = true
+	case p.ref.ID != "":
+		if err := p.editor.Edit(ctx, p.ref, msg); err != nil {
+			return err
+		}
+	default:
+		// The transport gave no handle to edit; the first render stands.
+		return nil
+	}
+	p.lastText = text
+	p.mu.Lock()
+	p.lastRender = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Progress) headerLocked(icon, state string) string {
+	elapsed := time.Since(p.started).Truncate(time.Second)
+	header := fmt.Sprintf("%s <b>%s</b> · %s", icon, state, elapsed)
+	if p.step != "" {
+		header += "\n▸ <i>" + html.EscapeString(p.step) + "</i>"
+	}
+	return header
+}
+
+// lastStep returns the first non-empty line of an HTML chunk as plain text,
+// shortened for display in the progress header.
+func lastStep(chunk string) string {
+	text := render.PlainText(chunk)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if r := []rune(line); len(r) > progressStepLimit {
+			line = string(r[:progressStepLimit]) + "…"
+		}
+		return line
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+package stream
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+type fakeEditor struct {
+	mu    sync.Mutex
+	edits map[string]string
+}
+
+func (f *fakeEditor) Edit(_ context.Context, ref domain.MessageRef, msg domain.OutboundMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits[ref.ID] = msg.Text
+	return nil
+}
+
+func TestProgressEditsSingleMessage(t *testing.T) {
+	s := &fakeSender{}
+	e := &fakeEditor{edits: map[string]string{}}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	p := NewProgress(time.Second, 1000, s, e, key)
+	ctx := context.Background()
+
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "step one\n"})
+	time.Sleep(1200 * time.Millisecond)
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "<b>Bash</b>\n<code>ls &amp; pwd</code>\n", Format: "html"})
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	msgs := s.snapshot()
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "running") || !strings.Contains(msgs[0], "step one") {
+		t.Fatalf("expected one initial progress message, got %#v", msgs)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	final := e.edits["1"]
+	if !strings.Contains(final, "finished") || !strings.Contains(final, "▸ <i>Bash</i>") || !strings.Contains(final, "<code>ls &amp; pwd</code>") {
+		t.Fatalf("unexpected final edit: %q", final)
+	}
+}
+
+func TestProgressRollsOverNearLimit(t *testing.T) {
+	s := &fakeSender{}
+	e := &fakeEditor{edits: map[string]string{}}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	p := NewProgress(time.Second, 20, s, e, key)
+	ctx := context.Background()
+
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "aaaaaaaaaaaaaaa\n"})
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "bbbbbbbbbbbbbbb\n"})
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	msgs := s.snapshot()
+	if len(msgs) != 2 {
+		t.Fatalf("expected rollover into a second message, got %#v", msgs)
+	}
+	if !strings.Contains(msgs[0], "continued below") || !strings.Contains(msgs[0], "aaa") {
+		t.Fatalf("unexpected first message: %q", msgs[0])
+	}
+	if !strings.Contains(msgs[1], "finished") || !strings.Contains(msgs[1], "bbb") || strings.Contains(msgs[1], "aaa") {
+		t.Fatalf("unexpected second message: %q", msgs[1])
+	}
+}
+
+func TestProgressShowsFailedJob(t *testing.T) {
+	s := &fakeSender{}
+	e := &fakeEditor{edits: map[string]string{}}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	p := NewProgress(time.Second, 1000, s, e, key)
+	ctx := context.Background()
+
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "compiling\n"})
+	if err := p.Finish(ctx, domain.JobFailed); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	msgs := s.snapshot()
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "❌ <b>failed</b>") || strings.Contains(msgs[0], "finished") || !strings.Contains(msgs[0], "compiling") {
+		t.Fatalf("unexpected failed progress message: %#v", msgs)
+	}
+}
+
+// slowSender blocks every send for delay, like a transport waiting out a
+// rate limit, and reports when a send has started.
+type slowSender struct {
+	fakeSender
+	delay   time.Duration
+	sending chan struct{}
+}
+
+func (s *slowSender) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	select {
+	case s.sending <- struct{}{}:
+	default:
+	}
+	time.Sleep(s.delay)
+	return s.fakeSender.Send(ctx, msg)
+}
+
+func TestProgressOnEventDoesNotWaitForSlowSender(t *testing.T) {
+	s := &slowSender{delay: time.Second, sending: make(chan struct{}, 1)}
+	e := &fakeEditor{edits: map[string]string{}}
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "1"}
+	p := NewProgress(time.Second, 20, s, e, key)
+	ctx := context.Background()
+
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "aaaaaaaaaaaaaaa\n"})
+	select {
+	case <-s.sending:
+	case <-time.After(3 * time.Second):
+		t.Fatal("progress message was never sent")
+	}
+
+	start := time.Now()
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "bbbbbbbbbbbbbbb\n"})
+	_ = p.OnEvent(ctx, domain.StreamEvent{Chunk: "ccccccccccccccc\n"})
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("OnEvent waited %s for the sender", elapsed)
+	}
+
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	msgs := s.snapshot()
+	if len(msgs) != 3 || !strings.Contains(msgs[0], "aaa") || !strings.Contains(msgs[1], "continued below") || !strings.Contains(msgs[2], "finished") || !strings.Contains(msgs[2], "ccc") {
+		t.Fatalf("unexpected progress messages: %#v", msgs)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !strings.Contains(e.edits["1"], "continued below") {
+		t.Fatalf("expected the first message to be finalized, got %q", e.edits["1"])
+	}
+}
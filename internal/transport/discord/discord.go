@@ -0,0 +1,345 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+const (
+	defaultAPIBase        = "https://discord.com/api/v10"
+	defaultGatewayURL     = "wss://gateway.discord.gg/?v=10&encoding=json"
+	defaultRequestTimeout = 30 * time.Second
+	// maxContentChars is Discord's limit for message content.
+	maxContentChars = 2000
+	// partBytes bounds the HTML converted into one message; the margin
+	// covers the markdown added by the conversion.
+	partBytes = 1800
+	// maxRateLimitRetries bounds how often a request is repeated after
+	// Discord answered 429.
+	maxRateLimitRetries = 3
+)
+
+// Bot is a Discord bot that receives messages and application commands
+// over the gateway and answers through the REST API. Messages in a thread
+// get a session keyed by the parent channel and the thread.
+type Bot struct {
+	token          string
+	allowedUsers   map[string]bool
+	allowedRoles   map[string]bool
+	apiBase        string
+	gatewayURL     string
+	httpClient     *http.Client
+	requestTimeout time.Duration
+	inbound        chan domain.Message
+
+	// Gateway session state, filled in from READY. seq is the last
+	// dispatch sequence number, 0 before the first one.
+	seq       atomic.Int64
+	sessionID string
+	resumeURL string
+	userID    string
+	appID     string
+	mention   *regexp.Regexp
+
+	// channels caches channelInfo by channel ID.
+	channels sync.Map
+	// threads holds IDs of threads the bot posted in; messages there
+	// address the bot without a mention.
+	threads sync.Map
+	// reactions holds the emoji the bot last put on "channel:message".
+	reactions sync.Map
+}
+
+// Option configures optional Bot behavior.
+type Option func(*Bot)
+
+// WithAPIBase points the bot at another REST API base URL, such as a local
+// stand-in in tests.
+func WithAPIBase(base string) Option {
+	return func(b *Bot) {
+		if base != "" {
+			b.apiBase = strings.TrimRight(base, "/")
+		}
+	}
+}
+
+// WithGatewayURL points the bot at another gateway, such as a local
+// stand-in in tests. The URL should ask for API v10 and JSON encoding.
+func WithGatewayURL(gateway string) Option {
+	return func(b *Bot) {
+		if gateway != "" {
+			b.gatewayURL = gateway
+		}
+	}
+}
+
+// WithAllowedUsers allows the given user IDs to use the bot anywhere.
+func WithAllowedUsers(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedUsers[id] = true
+		}
+	}
+}
+
+// WithAllowedRoles allows guild members holding any of the given role IDs
+// to use the bot in that guild.
+func WithAllowedRoles(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedRoles[id] = true
+		}
+	}
+}
+
+func New(token string, opts ...Option) *Bot {
+	b := &Bot{
+		token:          token,
+		allowedUsers:   make(map[string]bool),
+		allowedRoles:   make(map[string]bool),
+		apiBase:        defaultAPIBase,
+		gatewayURL:     defaultGatewayURL,
+		httpClient:     &http.Client{},
+		requestTimeout: defaultRequestTimeout,
+		inbound:        make(chan domain.Message, inboundQueueSize),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *Bot) Name() string { return "discord" }
+
+func (b *Bot) Start(ctx context.Context, handler domain.MessageHandler) error {
+	slog.Info("transport started", "transport", "discord")
+	go b.processInbound(ctx, handler)
+	return b.runGateway(ctx)
+}
+
+// Send posts msg as Discord markdown. Text longer than Discord's 2000
+// character limit is sent as several messages; the first one replies to
+// msg.ReplyToMessageID, the last one carries the buttons, and the first
+// one is returned.
+func (b *Bot) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	channel := targetChannel(msg.SessionKey)
+	parts := contentParts(msg)
+	var ref domain.MessageRef
+	for i, content := range parts {
+		payload := map[string]any{
+			"content":          content,
+			"allowed_mentions": map[string]any{"parse": []string{}},
+		}
+		if i == 0 && msg.ReplyToMessageID != "" {
+			payload["message_reference"] = map[string]any{"message_id": msg.ReplyToMessageID, "fail_if_not_exists": false}
+		}
+		if i == len(parts)-1 && len(msg.Actions) > 0 {
+			payload["components"] = components(msg.Actions)
+		}
+		var sent struct {
+			ID string `json:"id"`
+		}
+		if err := b.do(ctx, http.MethodPost, "/channels/"+channel+"/messages", payload, &sent); err != nil {
+			return ref, err
+		}
+		if i == 0 {
+			ref = domain.MessageRef{SessionKey: msg.SessionKey, ID: sent.ID}
+		}
+	}
+	if msg.SessionKey.ThreadID != "" {
+		b.threads.Store(msg.SessionKey.ThreadID, true)
+	}
+	return ref, nil
+}
+
+// Edit replaces the content of a message previously returned by Send.
+// Content over the length limit keeps its end, which is where live
+// progress output grows.
+func (b *Bot) Edit(ctx context.Context, ref domain.MessageRef, msg domain.OutboundMessage) error {
+	parts := contentParts(msg)
+	content := parts[len(parts)-1]
+	if len(parts) > 1 {
+		content = "…\n" + content
+	}
+	payload := map[string]any{"content": content, "components": []any{}}
+	if len(msg.Actions) > 0 {
+		payload["components"] = components(msg.Actions)
+	}
+	return b.do(ctx, http.MethodPatch, "/channels/"+targetChannel(ref.SessionKey)+"/messages/"+ref.ID, payload, nil)
+}
+
+// React replaces the bot's reaction on a message. Discord allows several
+// reactions per user, so the previous one is removed first.
+func (b *Bot) React(ctx context.Context, ref domain.MessageRef, emoji string) error {
+	channel := targetChannel(ref.SessionKey)
+	key := channel + ":" + ref.ID
+	base := "/channels/" + channel + "/messages/" + ref.ID + "/reactions/"
+	if prev, ok := b.reactions.Load(key); ok && prev.(string) != emoji {
+		if err := b.do(ctx, http.MethodDelete, base+url.PathEscape(prev.(string))+"/@me", nil, nil); err != nil {
+			return err
+		}
+		b.reactions.Delete(key)
+	}
+	if emoji == "" {
+		return nil
+	}
+	if err := b.do(ctx, http.MethodPut, base+url.PathEscape(emoji)+"/@me", nil, nil); err != nil {
+		return err
+	}
+	b.reactions.Store(key, emoji)
+	return nil
+}
+
+// targetChannel is the channel messages of key are posted in: threads are
+// channels of their own.
+func targetChannel(key domain.SessionKey) string {
+	if key.ThreadID != "" {
+		return key.ThreadID
+	}
+	return key.ChatID
+}
+
+// contentParts converts msg to Discord markdown in parts that each fit in
+// one message. HTML is split before conversion so every part is well
+// formed; plain text is escaped so it shows as sent.
+func contentParts(msg domain.OutboundMessage) []string {
+	text := msg.Text
+	if msg.Format != "html" {
+		text = html.EscapeString(text)
+	}
+	var parts []string
+	for _, part := range render.SplitHTML(text, partBytes) {
+		content := render.HTMLToDiscord(part)
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		if utf8.RuneCountInString(content) <= maxContentChars {
+			parts = append(parts, content)
+			continue
+		}
+		// Bytes bound runes, so this always fits.
+		parts = append(parts, render.SplitText(content, maxContentChars)...)
+	}
+	if len(parts) == 0 {
+		// Discord rejects messages without content.
+		parts = []string{"\u200b"}
+	}
+	return parts
+}
+
+// components renders actions as one row of buttons whose custom_id is
+// "<name>:<data>", within Discord's 100 character limit.
+func components(actions []domain.Action) []map[string]any {
+	buttons := make([]map[string]any, 0, len(actions))
+	for _, a := range actions {
+		buttons = append(buttons, map[string]any{
+			"type":      2,
+			"style":     2,
+			"label":     a.Label,
+			"custom_id": a.Name + ":" + a.Data,
+		})
+	}
+	return []map[string]any{{"type": 1, "components": buttons}}
+}
+
+// do sends a REST request with a JSON payload, when non-nil, and decodes
+// the response into result when it is non-nil. Rate-limited requests are
+// repeated after the delay Discord asks for.
+func (b *Bot) do(ctx context.Context, method, path string, payload, result any) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("discord %s %s: encode payload: %w", method, path, err)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		err := b.doOnce(ctx, method, path, body, result)
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter == 0 || attempt >= maxRateLimitRetries {
+			return err
+		}
+		slog.Warn("discord rate limited", "method", method, "path", path, "retry_after", apiErr.RetryAfter)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(apiErr.RetryAfter):
+		}
+	}
+}
+
+func (b *Bot) doOnce(ctx context.Context, method, path string, body []byte, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, b.apiBase+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("discord %s %s: %w", method, path, err)
+	}
+	req.Header.Set("Authorization", "Bot "+b.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("discord %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Code       int     `json:"code"`
+			Message    string  `json:"message"`
+			RetryAfter float64 `json:"retry_after"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		apiErr := &apiError{Method: method, Path: path, StatusCode: resp.StatusCode, Code: failure.Code, Message: failure.Message}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retry := time.Duration(failure.RetryAfter * float64(time.Second))
+			if s, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && retry == 0 {
+				retry = time.Duration(s * float64(time.Second))
+			}
+			apiErr.RetryAfter = max(retry, 100*time.Millisecond)
+		}
+		return apiErr
+	}
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("discord %s %s: decode response: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+type apiError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Code and Message are Discord's JSON error code and description.
+	Code    int
+	Message string
+	// RetryAfter is set on 429 responses.
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("discord %s %s status=%d", e.Method, e.Path, e.StatusCode)
+	}
+	return fmt.Sprintf("discord %s %s status=%d: %s (code %d)", e.Method, e.Path, e.StatusCode, e.Message, e.Code)
+}
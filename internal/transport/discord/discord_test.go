@@ -0,0 +1,105 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"chatcode/internal/domain"
+)
+
+func TestContentPartsFitDiscordLimit(t *testing.T) {
+	text := "<b>output</b>\n<pre>" + strings.Repeat("ünïcode line_with_underscores\n", 200) + "</pre>"
+	parts := contentParts(domain.OutboundMessage{Text: text, Format: "html"})
+	if len(parts) < 2 {
+		t.Fatalf("expected several parts, got %d", len(parts))
+	}
+	for _, p := range parts {
+		if n := utf8.RuneCountInString(p); n > maxContentChars {
+			t.Fatalf("part has %d characters", n)
+		}
+		if strings.Count(p, "```")%2 != 0 {
+			t.Fatalf("part has an unbalanced code block: %q", p[:50])
+		}
+	}
+	if !strings.HasPrefix(parts[0], "**output**\n```\n") {
+		t.Fatalf("unexpected first part %q", parts[0][:30])
+	}
+
+	plain := contentParts(domain.OutboundMessage{Text: "job queued: a_b *c*"})
+	if len(plain) != 1 || plain[0] != `job queued: a\_b \*c\*` {
+		t.Fatalf("expected escaped plain text, got %q", plain)
+	}
+}
+
+func TestSendRepliesAndAttachesButtons(t *testing.T) {
+	api := newFakeDiscord(t)
+	b := New("bot-token", WithAPIBase(api.server.URL))
+	key := domain.SessionKey{Platform: domain.PlatformDiscord, ChatID: "C1", ThreadID: "T1"}
+	ref, err := b.Send(context.Background(), domain.OutboundMessage{
+		SessionKey:       key,
+		Text:             "job queued: abc",
+		ReplyToMessageID: "m1",
+		Actions:          []domain.Action{{Name: domain.ActionStopJob, Label: "Stop", Data: "abc"}},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if ref.ID != "900" {
+		t.Fatalf("unexpected ref %+v", ref)
+	}
+	raw, ok := api.called("POST /channels/T1/messages")
+	if !ok {
+		t.Fatalf("expected message posted in the thread, got %v", api.requests)
+	}
+	var body struct {
+		MessageReference struct {
+			MessageID string `json:"message_id"`
+		} `json:"message_reference"`
+		Components []struct {
+			Components []struct {
+				CustomID string `json:"custom_id"`
+			} `json:"components"`
+		} `json:"components"`
+	}
+	_ = json.Unmarshal([]byte(raw), &body)
+	if body.MessageReference.MessageID != "m1" || body.Components[0].Components[0].CustomID != "stop:abc" {
+		t.Fatalf("unexpected payload %s", raw)
+	}
+	if _, ok := b.threads.Load("T1"); !ok {
+		t.Fatal("expected thread to be remembered")
+	}
+}
+
+func TestInteractionsBecomeCommandsAndActions(t *testing.T) {
+	api := newFakeDiscord(t)
+	b := New("bot-token", WithAPIBase(api.server.URL), WithAllowedUsers("U1"))
+	var in interaction
+	_ = json.Unmarshal([]byte(`{"id":"i1","token":"tok","type":2,"channel_id":"C1","guild_id":"G1",
+		"member":{"user":{"id":"U1"},"roles":[]},"data":{"name":"cd","options":[{"name":"project_dir","value":"api"}]}}`), &in)
+	b.handleInteraction(context.Background(), in)
+	msg := <-b.inbound
+	if msg.Text != "/cd api" || msg.SessionKey.ChatID != "C1" || msg.SessionKey.ThreadID != "" {
+		t.Fatalf("unexpected command message %+v", msg)
+	}
+	if _, ok := api.called("POST /interactions/i1/tok/callback"); !ok {
+		t.Fatal("expected interaction to be answered")
+	}
+
+	_ = json.Unmarshal([]byte(`{"id":"i2","token":"tok","type":3,"channel_id":"T1","user":{"id":"U1"},
+		"data":{"custom_id":"retry:abc"},"message":{"id":"m9"}}`), &in)
+	in.Member = nil
+	b.handleInteraction(context.Background(), in)
+	msg = <-b.inbound
+	if msg.Action == nil || msg.Action.Name != domain.ActionRetryJob || msg.Action.Data != "abc" || msg.SessionKey.ThreadID != "T1" || msg.Meta.ReplyToMessageID != "m9" {
+		t.Fatalf("unexpected action message %+v", msg)
+	}
+
+	_ = json.Unmarshal([]byte(`{"id":"i3","token":"tok","type":2,"channel_id":"C1","user":{"id":"U2"},"data":{"name":"stop"}}`), &in)
+	b.handleInteraction(context.Background(), in)
+	if len(b.inbound) != 0 {
+		t.Fatal("expected interaction from unknown user to be refused")
+	}
+}
@@ -0,0 +1,369 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// inboundQueueSize bounds messages read from the gateway but not yet
+// handled; the gateway connection must keep reading meanwhile.
+const inboundQueueSize = 256
+
+type discordMessage struct {
+	ID        string `json:"id"`
+	Type      int    `json:"type"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	Content   string `json:"content"`
+	Author    *user  `json:"author"`
+	Member    *struct {
+		Roles []string `json:"roles"`
+	} `json:"member"`
+	Mentions          []user `json:"mentions"`
+	ReferencedMessage *struct {
+		ID     string `json:"id"`
+		Author user   `json:"author"`
+	} `json:"referenced_message"`
+	// EditedTimestamp is set on edited messages.
+	EditedTimestamp string `json:"edited_timestamp"`
+}
+
+type user struct {
+	ID  string `json:"id"`
+	Bot bool   `json:"bot"`
+}
+
+type channelInfo struct {
+	ID       string `json:"id"`
+	Type     int    `json:"type"`
+	ParentID string `json:"parent_id"`
+}
+
+// isThread reports whether the channel is an announcement, public or
+// private thread.
+func (c channelInfo) isThread() bool {
+	return c.Type == 10 || c.Type == 11 || c.Type == 12
+}
+
+// dispatch handles a gateway event other than READY and RESUMED.
+func (b *Bot) dispatch(ctx context.Context, name string, raw json.RawMessage) {
+	switch name {
+	case "MESSAGE_CREATE", "MESSAGE_UPDATE":
+		var m discordMessage
+		if err := json.Unmarshal(raw, &m); err != nil {
+			slog.Error("discord message event is invalid", "event", name, "error", err)
+			return
+		}
+		if name == "MESSAGE_UPDATE" && m.EditedTimestamp == "" {
+			// Embed and pin updates, not edits.
+			return
+		}
+		msg, ok := b.toDomainMessage(ctx, m)
+		if !ok {
+			return
+		}
+		slog.Info("discord inbound message",
+			"chat_id", msg.SessionKey.ChatID,
+			"thread_id", msg.SessionKey.ThreadID,
+			"sender_id", msg.SenderID,
+			"edited", msg.Meta.EditID != "",
+		)
+		b.enqueue(msg)
+	case "THREAD_CREATE", "THREAD_UPDATE":
+		var c channelInfo
+		if err := json.Unmarshal(raw, &c); err == nil && c.ID != "" {
+			b.channels.Store(c.ID, c)
+		}
+	case "INTERACTION_CREATE":
+		var in interaction
+		if err := json.Unmarshal(raw, &in); err != nil {
+			slog.Error("discord interaction is invalid", "error", err)
+			return
+		}
+		b.handleInteraction(ctx, in)
+	}
+}
+
+// toDomainMessage maps a message event to a domain message, dropping
+// messages from bots, from users who are not allowed and, in guilds,
+// messages not addressed to the bot.
+func (b *Bot) toDomainMessage(ctx context.Context, m discordMessage) (domain.Message, bool) {
+	// 0 is a plain message and 19 a reply; the others are system messages.
+	if m.Author == nil || m.Author.Bot || m.Author.ID == b.userID || (m.Type != 0 && m.Type != 19) {
+		return domain.Message{}, false
+	}
+	var roles []string
+	if m.Member != nil {
+		roles = m.Member.Roles
+	}
+	if !b.allowed(m.Author.ID, roles) {
+		return domain.Message{}, false
+	}
+	key := b.sessionKey(ctx, m.ChannelID)
+	text, ok := b.addressedText(m, key)
+	if !ok {
+		return domain.Message{}, false
+	}
+	quoted := ""
+	if m.ReferencedMessage != nil {
+		quoted = m.ReferencedMessage.ID
+	}
+	return domain.Message{
+		SessionKey: key,
+		SenderID:   m.Author.ID,
+		Text:       text,
+		Meta: domain.InboundMessageMeta{
+			MessageID:        m.ID,
+			ReplyToMessageID: m.ID,
+			QuotedMessageID:  quoted,
+			EditID:           m.EditedTimestamp,
+			Raw:              map[string]string{"discord_message_id": m.ID},
+		},
+		At: time.Now().UTC(),
+	}, true
+}
+
+// addressedText decides whether a message is meant for the bot and returns
+// its text with the bot's mention removed. Direct messages always address
+// the bot. In guilds only mentions, replies to the bot's messages and
+// messages in threads the bot posted in do.
+func (b *Bot) addressedText(m discordMessage, key domain.SessionKey) (string, bool) {
+	text := m.Content
+	mentioned := false
+	for _, u := range m.Mentions {
+		if b.userID != "" && u.ID == b.userID {
+			mentioned = true
+		}
+	}
+	if b.mention != nil && b.mention.MatchString(text) {
+		text = strings.TrimSpace(b.mention.ReplaceAllString(text, ""))
+		mentioned = true
+	}
+	if m.GuildID == "" || mentioned {
+		return text, true
+	}
+	if r := m.ReferencedMessage; r != nil && b.userID != "" && r.Author.ID == b.userID {
+		return text, true
+	}
+	if key.ThreadID != "" {
+		if _, ok := b.threads.Load(key.ThreadID); ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// sessionKey maps a channel to a session key. Threads are channels of their
+// own in Discord; their key is the parent channel plus the thread. Channel
+// types are looked up once and cached.
+func (b *Bot) sessionKey(ctx context.Context, channelID string) domain.SessionKey {
+	key := domain.SessionKey{Platform: domain.PlatformDiscord, ChatID: channelID}
+	info, err := b.channel(ctx, channelID)
+	if err != nil {
+		slog.Error("discord channel lookup failed", "channel_id", channelID, "error", err)
+		return key
+	}
+	if info.isThread() && info.ParentID != "" {
+		key.ChatID, key.ThreadID = info.ParentID, channelID
+	}
+	return key
+}
+
+func (b *Bot) channel(ctx context.Context, id string) (channelInfo, error) {
+	if v, ok := b.channels.Load(id); ok {
+		return v.(channelInfo), nil
+	}
+	var info channelInfo
+	if err := b.do(ctx, http.MethodGet, "/channels/"+id, nil, &info); err != nil {
+		return channelInfo{}, err
+	}
+	b.channels.Store(id, info)
+	return info, nil
+}
+
+// allowed reports whether a user may use the bot, by user ID or, in
+// guilds, by any of the member's roles.
+func (b *Bot) allowed(userID string, roles []string) bool {
+	if b.allowedUsers[userID] {
+		return true
+	}
+	for _, r := range roles {
+		if b.allowedRoles[r] {
+			return true
+		}
+	}
+	return false
+}
+
+type interaction struct {
+	ID        string `json:"id"`
+	Token     string `json:"token"`
+	Type      int    `json:"type"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	Member    *struct {
+		User  user     `json:"user"`
+		Roles []string `json:"roles"`
+	} `json:"member"`
+	// User is set instead of Member in direct messages.
+	User *user `json:"user"`
+	Data struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value any    `json:"value"`
+		} `json:"options"`
+		CustomID string `json:"custom_id"`
+	} `json:"data"`
+	Message *struct {
+		ID string `json:"id"`
+	} `json:"message"`
+}
+
+const (
+	interactionCommand   = 2
+	interactionComponent = 3
+
+	// Interaction callback types.
+	callbackMessage        = 4
+	callbackDeferredUpdate = 6
+	ephemeralFlag          = 1 << 6
+)
+
+// handleInteraction handles application commands and button presses.
+// Interactions must be answered within three seconds: commands are echoed
+// into the channel and handled like "/<name> <options>" sent as a message,
+// button presses are acknowledged and delivered as Message.Action.
+func (b *Bot) handleInteraction(ctx context.Context, in interaction) {
+	userID, roles := "", []string(nil)
+	if in.Member != nil {
+		userID, roles = in.Member.User.ID, in.Member.Roles
+	} else if in.User != nil {
+		userID = in.User.ID
+	}
+	if in.Type != interactionCommand && in.Type != interactionComponent {
+		return
+	}
+	if !b.allowed(userID, roles) {
+		b.respond(ctx, in, callbackMessage, map[string]any{"content": "You are not allowed to use this bot.", "flags": ephemeralFlag})
+		return
+	}
+	msg := domain.Message{
+		SessionKey: b.sessionKey(ctx, in.ChannelID),
+		SenderID:   userID,
+		Meta:       domain.InboundMessageMeta{Raw: map[string]string{"discord_interaction_id": in.ID}},
+		At:         time.Now().UTC(),
+	}
+	if in.Type == interactionComponent {
+		name, data, ok := strings.Cut(in.Data.CustomID, ":")
+		if !ok || name == "" {
+			return
+		}
+		b.respond(ctx, in, callbackDeferredUpdate, nil)
+		msg.Action = &domain.Action{Name: name, Data: data}
+		if in.Message != nil {
+			msg.Meta.ReplyToMessageID = in.Message.ID
+		}
+		slog.Info("discord inbound action", "chat_id", msg.SessionKey.ChatID, "sender_id", userID, "action", name)
+		b.enqueue(msg)
+		return
+	}
+	text := "/" + in.Data.Name
+	for _, opt := range in.Data.Options {
+		text += " " + fmt.Sprint(opt.Value)
+	}
+	msg.Text = text
+	b.respond(ctx, in, callbackMessage, map[string]any{
+		"content":          "`" + strings.ReplaceAll(text, "`", "'") + "`",
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+	slog.Info("discord inbound command", "chat_id", msg.SessionKey.ChatID, "thread_id", msg.SessionKey.ThreadID, "sender_id", userID, "command", in.Data.Name)
+	b.enqueue(msg)
+}
+
+func (b *Bot) respond(ctx context.Context, in interaction, kind int, data map[string]any) {
+	payload := map[string]any{"type": kind}
+	if data != nil {
+		payload["data"] = data
+	}
+	if err := b.do(ctx, http.MethodPost, "/interactions/"+in.ID+"/"+in.Token+"/callback", payload, nil); err != nil {
+		slog.Error("discord interaction response failed", "error", err)
+	}
+}
+
+type commandOption struct {
+	Type        int              `json:"type"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Required    bool             `json:"required,omitempty"`
+	Choices     []map[string]any `json:"choices,omitempty"`
+}
+
+type applicationCommand struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Options     []commandOption `json:"options,omitempty"`
+}
+
+const optionString = 3
+
+// applicationCommands mirror the chat commands of the same name.
+var applicationCommands = []applicationCommand{
+	{Name: "cd", Description: "Set workdir, empty uses project root", Options: []commandOption{
+		{Type: optionString, Name: "project_dir", Description: "Project directory"},
+	}},
+	{Name: "codex", Description: "Use codex or run a prompt with it", Options: []commandOption{
+		{Type: optionString, Name: "prompt", Description: "Prompt to run"},
+	}},
+	{Name: "claude", Description: "Use claude or run a prompt with it", Options: []commandOption{
+		{Type: optionString, Name: "prompt", Description: "Prompt to run"},
+	}},
+	{Name: "mode", Description: "Show or set the session permission mode", Options: []commandOption{
+		{Type: optionString, Name: "mode", Description: "Permission mode", Choices: []map[string]any{
+			{"name": domain.PermissionModeSandbox, "value": domain.PermissionModeSandbox},
+			{"name": domain.PermissionModeFullAccess, "value": domain.PermissionModeFullAccess},
+		}},
+	}},
+	{Name: "stop", Description: "Stop a running job", Options: []commandOption{
+		{Type: optionString, Name: "job_id", Description: "Job ID", Required: true},
+	}},
+}
+
+// registerCommands replaces the bot's global application commands.
+func (b *Bot) registerCommands(ctx context.Context) error {
+	if b.appID == "" {
+		return errors.New("application id unknown")
+	}
+	return b.do(ctx, http.MethodPut, "/applications/"+b.appID+"/commands", applicationCommands, nil)
+}
+
+func (b *Bot) enqueue(msg domain.Message) {
+	select {
+	case b.inbound <- msg:
+	default:
+		slog.Warn("discord inbound queue is full, message dropped", "chat_id", msg.SessionKey.ChatID)
+	}
+}
+
+// processInbound hands queued messages to the handler one at a time, in
+// gateway order.
+func (b *Bot) processInbound(ctx context.Context, handler domain.MessageHandler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-b.inbound:
+			if err := handler(ctx, msg); err != nil {
+				slog.Error("discord message handling failed", "chat_id", msg.SessionKey.ChatID, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// Gateway opcodes.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatAck   = 11
+)
+
+// intents are the gateway events the bot subscribes to: guilds (for
+// threads), guild and direct messages, and message content, which is a
+// privileged intent that has to be enabled for the bot.
+const intents = 1<<0 | 1<<9 | 1<<12 | 1<<15
+
+const (
+	dialTimeout    = 10 * time.Second
+	maxReconnectIn = 30 * time.Second
+)
+
+// fatalCloseCodes end the gateway for good: authentication failed or the
+// bot asked for intents it may not use. Reconnecting would not help.
+var fatalCloseCodes = map[int]bool{4004: true, 4010: true, 4011: true, 4012: true, 4013: true, 4014: true}
+
+type gatewayPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  *int64          `json:"s"`
+	T  string          `json:"t"`
+}
+
+var errReconnect = errors.New("gateway asked to reconnect")
+
+// runGateway keeps a gateway connection open until ctx is done, resuming
+// the session after disconnects where Discord allows it.
+func (b *Bot) runGateway(ctx context.Context) error {
+	wait := time.Second
+	for {
+		ready, err := b.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var ce *closeError
+		if errors.As(err, &ce) {
+			if fatalCloseCodes[ce.Code] {
+				return fmt.Errorf("discord gateway: %w", err)
+			}
+			if ce.Code == 4007 || ce.Code == 4009 {
+				// Invalid sequence or timed out session: start over.
+				b.sessionID = ""
+			}
+		}
+		if ready {
+			wait = time.Second
+		}
+		slog.Warn("discord gateway disconnected", "error", err, "reconnect_in", wait)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxReconnectIn)
+	}
+}
+
+// connect runs one gateway connection: it identifies or resumes, keeps the
+// heartbeat and handles dispatches until the connection ends. ready reports
+// whether the session got going, which resets the reconnect backoff.
+func (b *Bot) connect(ctx context.Context) (ready bool, err error) {
+	gateway := b.gatewayURL
+	resume := b.sessionID != ""
+	if resume && b.resumeURL != "" {
+		gateway = b.resumeURL + "/?v=10&encoding=json"
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	ws, err := dialWebSocket(dialCtx, gateway)
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	connCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		<-connCtx.Done()
+		// Closing with 1000 would end the session; a plain close keeps it
+		// resumable.
+		_ = ws.conn.Close()
+	}()
+
+	raw, err := ws.readMessage()
+	if err != nil {
+		return false, err
+	}
+	var hello struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	var p gatewayPayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.Op != opHello {
+		return false, fmt.Errorf("discord gateway: expected hello, got %q", truncate(raw))
+	}
+	if err := json.Unmarshal(p.D, &hello); err != nil || hello.HeartbeatInterval <= 0 {
+		return false, fmt.Errorf("discord gateway: invalid hello: %q", truncate(raw))
+	}
+	var acked atomic.Bool
+	acked.Store(true)
+	go b.heartbeat(connCtx, ws, time.Duration(hello.HeartbeatInterval)*time.Millisecond, &acked)
+
+	if resume {
+		err = b.send(ws, opResume, map[string]any{"token": b.token, "session_id": b.sessionID, "seq": b.seq.Load()})
+	} else {
+		err = b.send(ws, opIdentify, map[string]any{
+			"token":      b.token,
+			"intents":    intents,
+			"properties": map[string]string{"os": "linux", "browser": "chatcode", "device": "chatcode"},
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		raw, err := ws.readMessage()
+		if err != nil {
+			return ready, err
+		}
+		var p gatewayPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			slog.Error("discord gateway sent invalid payload", "error", err)
+			continue
+		}
+		switch p.Op {
+		case opDispatch:
+			if p.S != nil {
+				b.seq.Store(*p.S)
+			}
+			switch p.T {
+			case "READY":
+				b.handleReady(ctx, p.D)
+				ready = true
+			case "RESUMED":
+				slog.Info("discord gateway session resumed")
+				ready = true
+			default:
+				b.dispatch(ctx, p.T, p.D)
+			}
+		case opHeartbeat:
+			if err := b.sendHeartbeat(ws); err != nil {
+				return ready, err
+			}
+		case opHeartbeatAck:
+			acked.Store(true)
+		case opReconnect:
+			return ready, errReconnect
+		case opInvalidSession:
+			var resumable bool
+			_ = json.Unmarshal(p.D, &resumable)
+			if !resumable {
+				b.sessionID = ""
+			}
+			return ready, errors.New("discord gateway: invalid session")
+		}
+	}
+}
+
+// heartbeat sends heartbeats at interval. A heartbeat that was not
+// acknowledged by the next one means the connection is dead; closing it
+// makes connect return and the gateway reconnect.
+func (b *Bot) heartbeat(ctx context.Context, ws *wsConn, interval time.Duration, acked *atomic.Bool) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !acked.Swap(false) {
+			slog.Warn("discord gateway heartbeat not acknowledged, reconnecting")
+			_ = ws.conn.Close()
+			return
+		}
+		if err := b.sendHeartbeat(ws); err != nil {
+			return
+		}
+	}
+}
+
+func (b *Bot) sendHeartbeat(ws *wsConn) error {
+	var seq any
+	if s := b.seq.Load(); s != 0 {
+		seq = s
+	}
+	return b.send(ws, opHeartbeat, seq)
+}
+
+func (b *Bot) send(ws *wsConn, op int, d any) error {
+	raw, err := json.Marshal(map[string]any{"op": op, "d": d})
+	if err != nil {
+		return fmt.Errorf("discord gateway: encode op %d: %w", op, err)
+	}
+	return ws.writeText(raw)
+}
+
+func (b *Bot) handleReady(ctx context.Context, raw json.RawMessage) {
+	var ready struct {
+		SessionID        string `json:"session_id"`
+		ResumeGatewayURL string `json:"resume_gateway_url"`
+		User             struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Application struct {
+			ID string `json:"id"`
+		} `json:"application"`
+	}
+	if err := json.Unmarshal(raw, &ready); err != nil {
+		slog.Error("discord READY is invalid", "error", err)
+		return
+	}
+	b.sessionID = ready.SessionID
+	b.resumeURL = ready.ResumeGatewayURL
+	b.userID = ready.User.ID
+	b.appID = ready.Application.ID
+	if b.userID != "" {
+		b.mention = regexp.MustCompile(`<@!?` + regexp.QuoteMeta(b.userID) + `>`)
+	}
+	slog.Info("discord gateway ready", "user_id", b.userID)
+	go func() {
+		if err := b.registerCommands(ctx); err != nil {
+			slog.Error("discord command registration failed", "error", err)
+		} else {
+			slog.Info("discord commands registered")
+		}
+	}()
+}
+
+func truncate(raw []byte) string {
+	if len(raw) > 200 {
+		return string(raw[:200]) + "…"
+	}
+	return string(raw)
+}
@@ -0,0 +1,185 @@
+package discord
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// acceptWebSocket upgrades a request of the local gateway stand-in.
+func acceptWebSocket(t *testing.T, rw http.ResponseWriter, req *http.Request) *wsConn {
+	t.Helper()
+	conn, brw, err := rw.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Errorf("hijack: %v", err)
+		return nil
+	}
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(req.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	_ = brw.Flush()
+	return &wsConn{conn: conn, br: bufio.NewReader(brw.Reader)}
+}
+
+// fakeDiscord is a local stand-in for the gateway and the REST API.
+type fakeDiscord struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+	server   *httptest.Server
+	// events are dispatched after READY.
+	events []map[string]any
+}
+
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+	f := &fakeDiscord{bodies: make(map[string]string)}
+	f.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/gateway" {
+			f.serveGateway(t, rw, req)
+			return
+		}
+		raw, _ := io.ReadAll(req.Body)
+		call := req.Method + " " + req.URL.Path
+		f.mu.Lock()
+		f.requests = append(f.requests, call)
+		f.bodies[call] = string(raw)
+		f.mu.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		switch {
+		case call == "GET /channels/T1":
+			_, _ = io.WriteString(rw, `{"id":"T1","type":11,"parent_id":"C1"}`)
+		case call == "GET /channels/C1":
+			_, _ = io.WriteString(rw, `{"id":"C1","type":0}`)
+		case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/messages"):
+			_, _ = io.WriteString(rw, `{"id":"900"}`)
+		default:
+			rw.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeDiscord) serveGateway(t *testing.T, rw http.ResponseWriter, req *http.Request) {
+	ws := acceptWebSocket(t, rw, req)
+	if ws == nil {
+		return
+	}
+	defer ws.conn.Close()
+	send := func(v map[string]any) {
+		raw, _ := json.Marshal(v)
+		_ = ws.writeText(raw)
+	}
+	send(map[string]any{"op": opHello, "d": map[string]any{"heartbeat_interval": 45000}})
+	raw, err := ws.readMessage()
+	if err != nil {
+		t.Errorf("read identify: %v", err)
+		return
+	}
+	var identify struct {
+		Op int `json:"op"`
+		D  struct {
+			Token   string `json:"token"`
+			Intents int    `json:"intents"`
+		} `json:"d"`
+	}
+	_ = json.Unmarshal(raw, &identify)
+	if identify.Op != opIdentify || identify.D.Token != "bot-token" || identify.D.Intents != intents {
+		t.Errorf("unexpected identify: %s", raw)
+		return
+	}
+	send(map[string]any{"op": opDispatch, "t": "READY", "s": 1, "d": map[string]any{
+		"session_id": "s1", "user": map[string]any{"id": "BOT"}, "application": map[string]any{"id": "APP"},
+	}})
+	for i, ev := range f.events {
+		send(map[string]any{"op": opDispatch, "t": ev["t"], "s": i + 2, "d": ev["d"]})
+	}
+	for {
+		if _, err := ws.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeDiscord) called(call string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.bodies[call]
+	return body, ok
+}
+
+func TestGatewayDeliversThreadMessages(t *testing.T) {
+	api := newFakeDiscord(t)
+	api.events = []map[string]any{
+		{"t": "MESSAGE_CREATE", "d": map[string]any{
+			"id": "m0", "type": 0, "channel_id": "C1", "guild_id": "G1", "content": "just chatting",
+			"author": map[string]any{"id": "U1"}, "member": map[string]any{"roles": []string{"R1"}},
+		}},
+		{"t": "MESSAGE_CREATE", "d": map[string]any{
+			"id": "m1", "type": 0, "channel_id": "T1", "guild_id": "G1", "content": "<@BOT> fix the build",
+			"author": map[string]any{"id": "U1"}, "member": map[string]any{"roles": []string{"R1"}},
+			"mentions": []map[string]any{{"id": "BOT"}},
+		}},
+	}
+	b := New("bot-token",
+		WithAllowedRoles("R1"),
+		WithAPIBase(api.server.URL),
+		WithGatewayURL("ws"+strings.TrimPrefix(api.server.URL, "http")+"/gateway"),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan domain.Message, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Start(ctx, func(_ context.Context, msg domain.Message) error {
+			got <- msg
+			return nil
+		})
+	}()
+
+	select {
+	case msg := <-got:
+		want := domain.SessionKey{Platform: domain.PlatformDiscord, ChatID: "C1", ThreadID: "T1"}
+		if msg.SessionKey != want || msg.Text != "fix the build" || msg.SenderID != "U1" || msg.Meta.MessageID != "m1" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		body, ok := api.called("PUT /applications/APP/commands")
+		if ok {
+			for _, name := range []string{`"cd"`, `"codex"`, `"claude"`, `"mode"`, `"stop"`} {
+				if !strings.Contains(body, name) {
+					t.Fatalf("command %s not registered: %s", name, body)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("commands were not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("start returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("gateway did not stop")
+	}
+}
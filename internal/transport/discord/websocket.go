@@ -0,0 +1,280 @@
+package discord
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The gateway only needs a small part of RFC 6455: a client handshake,
+// text frames, fragmented messages and the control frames. This file
+// implements just that, so the transport needs no dependency.
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxMessageBytes bounds a single gateway message; GUILD_CREATE for
+	// large guilds is the biggest one.
+	maxMessageBytes = 16 << 20
+)
+
+// closeError is returned by readMessage when the peer closed the
+// connection. Discord reports why through the close code.
+type closeError struct {
+	Code   int
+	Reason string
+}
+
+func (e *closeError) Error() string {
+	return fmt.Sprintf("websocket closed: code=%d %s", e.Code, e.Reason)
+}
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// client connections mask every frame they send.
+	client bool
+	wmu    sync.Mutex
+}
+
+// dialWebSocket opens a ws:// or wss:// connection to rawURL.
+func dialWebSocket(ctx context.Context, rawURL string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse gateway url: %w", err)
+	}
+	host := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, fmt.Errorf("gateway url scheme must be ws or wss: got %q", u.Scheme)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("dial gateway: %w", err)
+	}
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("gateway tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+	ws, err := clientHandshake(ctx, conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+func clientHandshake(ctx context.Context, conn net.Conn, u *url.URL) (*wsConn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("gateway handshake: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("gateway handshake: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("gateway handshake: status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("gateway handshake: invalid Sec-WebSocket-Accept")
+	}
+	return &wsConn{conn: conn, br: br, client: true}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// readMessage returns the next text or binary message, answering pings on
+// the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			ce := &closeError{Code: 1005}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			_ = c.writeFrame(wsOpClose, payload[:min(len(payload), 2)])
+			return nil, ce
+		case wsOpText, wsOpBinary:
+			if started {
+				return nil, errors.New("websocket: new message inside a fragmented one")
+			}
+			started = true
+			msg = payload
+		case wsOpContinuation:
+			if !started {
+				return nil, errors.New("websocket: continuation without a message")
+			}
+			msg = append(msg, payload...)
+		default:
+			return nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+		if len(msg) > maxMessageBytes {
+			return nil, errors.New("websocket: message too large")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessageBytes {
+		err = errors.New("websocket: frame too large")
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+func (c *wsConn) writeText(payload []byte) error {
+	return c.writeFrame(wsOpText, payload)
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	frame := []byte{0x80 | op}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// close sends a close frame with code and closes the connection.
+func (c *wsConn) close(code int) error {
+	_ = c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, uint16(code)))
+	return c.conn.Close()
+}
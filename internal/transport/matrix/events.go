@@ -0,0 +1,178 @@
+package matrix
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"chatcode/internal/domain"
+)
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []roomEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []roomEvent `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+type roomEvent struct {
+	Type     string       `json:"type"`
+	EventID  string       `json:"event_id"`
+	Sender   string       `json:"sender"`
+	StateKey *string      `json:"state_key"`
+	Content  eventContent `json:"content"`
+}
+
+type eventContent struct {
+	MsgType    string         `json:"msgtype"`
+	Body       string         `json:"body"`
+	Membership string         `json:"membership"`
+	RelatesTo  *eventRelation `json:"m.relates_to"`
+	NewContent *struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"m.new_content"`
+}
+
+type eventRelation struct {
+	RelType   string `json:"rel_type"`
+	EventID   string `json:"event_id"`
+	InReplyTo *struct {
+		EventID string `json:"event_id"`
+	} `json:"m.in_reply_to"`
+	// IsFallingBack marks m.in_reply_to of a thread message as a fallback
+	// for clients without threads rather than a real reply.
+	IsFallingBack bool `json:"is_falling_back"`
+}
+
+func (b *Bot) allowed(userID, roomID string) bool {
+	return b.allowedUsers[userID] || b.allowedRooms[roomID]
+}
+
+// handleSync joins rooms the bot was invited to and, when deliver is set,
+// hands new room messages to handler.
+func (b *Bot) handleSync(ctx context.Context, resp *syncResponse, handler domain.MessageHandler, deliver bool) {
+	for roomID, room := range resp.Rooms.Invite {
+		b.handleInvite(ctx, roomID, room.InviteState.Events)
+	}
+	if !deliver {
+		return
+	}
+	for roomID, room := range resp.Rooms.Join {
+		for _, ev := range room.Timeline.Events {
+			msg, ok := b.toDomainMessage(roomID, ev)
+			if !ok {
+				continue
+			}
+			slog.Info("matrix inbound message",
+				"chat_id", msg.SessionKey.ChatID,
+				"thread_id", msg.SessionKey.ThreadID,
+				"sender_id", msg.SenderID,
+				"edited", msg.Meta.EditID != "",
+			)
+			if err := handler(ctx, msg); err != nil {
+				slog.Error("matrix message handling failed", "chat_id", roomID, "error", err)
+			}
+		}
+	}
+}
+
+// handleInvite joins a room when the invite for the bot came from an
+// allowed user or is for an allowed room. Other invites are left pending.
+func (b *Bot) handleInvite(ctx context.Context, roomID string, events []roomEvent) {
+	inviter := ""
+	for _, ev := range events {
+		if ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == b.userID && ev.Content.Membership == "invite" {
+			inviter = ev.Sender
+		}
+	}
+	if !b.allowed(inviter, roomID) {
+		slog.Warn("matrix invite ignored", "room_id", roomID, "inviter", inviter)
+		return
+	}
+	if err := b.do(ctx, http.MethodPost, "/join/"+url.PathEscape(roomID), map[string]any{}, nil); err != nil {
+		slog.Error("matrix join failed", "room_id", roomID, "error", err)
+		return
+	}
+	slog.Info("matrix room joined", "room_id", roomID, "inviter", inviter)
+}
+
+// toDomainMessage maps a room message from an allowed sender to a
+// message. Messages in a thread are keyed by the thread root; edits keep
+// the original event ID and carry the new text.
+func (b *Bot) toDomainMessage(roomID string, ev roomEvent) (domain.Message, bool) {
+	if ev.Type != "m.room.message" || ev.Sender == b.userID || !b.allowed(ev.Sender, roomID) {
+		return domain.Message{}, false
+	}
+	c := ev.Content
+	msg := domain.Message{
+		SenderID: ev.Sender,
+		Text:     c.Body,
+		Meta: domain.InboundMessageMeta{
+			MessageID:        ev.EventID,
+			ReplyToMessageID: ev.EventID,
+			Raw:              map[string]string{"matrix_event_id": ev.EventID},
+		},
+	}
+	thread := ""
+	if rel := c.RelatesTo; rel != nil {
+		switch {
+		case rel.RelType == "m.replace":
+			if c.NewContent == nil {
+				return domain.Message{}, false
+			}
+			c.MsgType, msg.Text = c.NewContent.MsgType, c.NewContent.Body
+			msg.Meta.MessageID, msg.Meta.ReplyToMessageID, msg.Meta.EditID = rel.EventID, rel.EventID, ev.EventID
+			if v, ok := b.threads.Load(rel.EventID); ok {
+				thread = v.(string)
+			}
+		case rel.RelType == "m.thread":
+			thread = rel.EventID
+			if rel.InReplyTo != nil && !rel.IsFallingBack {
+				msg.Meta.QuotedMessageID = rel.InReplyTo.EventID
+			}
+		case rel.InReplyTo != nil:
+			msg.Meta.QuotedMessageID = rel.InReplyTo.EventID
+		}
+	}
+	if c.MsgType != "m.text" {
+		// Notices come from other bots; emotes and media are not prompts.
+		return domain.Message{}, false
+	}
+	if msg.Meta.QuotedMessageID != "" {
+		msg.Text = stripReplyFallback(msg.Text)
+	}
+	if msg.Meta.EditID == "" {
+		b.threads.Store(ev.EventID, thread)
+	}
+	msg.SessionKey = domain.SessionKey{Platform: domain.PlatformMatrix, ChatID: roomID, ThreadID: thread}
+	return msg, true
+}
+
+// stripReplyFallback removes the quote of the replied-to message that
+// older clients put in front of a reply's body.
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return strings.Join(lines[i:], "\n")
+}
@@ -0,0 +1,57 @@
+package matrix
+
+import (
+	"encoding/json"
+	"testing"
+
+	"chatcode/internal/domain"
+)
+
+func event(t *testing.T, raw string) roomEvent {
+	t.Helper()
+	var ev roomEvent
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	return ev
+}
+
+func TestThreadMessagesAndEdits(t *testing.T) {
+	b := New("https://hs", "secret", WithAllowedRooms("!r:x"))
+	b.userID = "@bot:x"
+
+	msg, ok := b.toDomainMessage("!r:x", event(t, `{"type":"m.room.message","event_id":"$2","sender":"@a:x","content":{
+		"msgtype":"m.text","body":"run tests",
+		"m.relates_to":{"rel_type":"m.thread","event_id":"$1","is_falling_back":true,"m.in_reply_to":{"event_id":"$1"}}}}`))
+	want := domain.SessionKey{Platform: domain.PlatformMatrix, ChatID: "!r:x", ThreadID: "$1"}
+	if !ok || msg.SessionKey != want || msg.Text != "run tests" || msg.Meta.QuotedMessageID != "" || msg.Meta.ReplyToMessageID != "$2" {
+		t.Fatalf("unexpected thread message %+v", msg)
+	}
+
+	msg, ok = b.toDomainMessage("!r:x", event(t, `{"type":"m.room.message","event_id":"$3","sender":"@a:x","content":{
+		"msgtype":"m.text","body":"* run all tests",
+		"m.new_content":{"msgtype":"m.text","body":"run all tests"},
+		"m.relates_to":{"rel_type":"m.replace","event_id":"$2"}}}`))
+	if !ok || msg.SessionKey != want || msg.Text != "run all tests" || msg.Meta.MessageID != "$2" || msg.Meta.EditID != "$3" {
+		t.Fatalf("unexpected edit %+v", msg)
+	}
+
+	msg, ok = b.toDomainMessage("!r:x", event(t, `{"type":"m.room.message","event_id":"$4","sender":"@a:x","content":{
+		"msgtype":"m.text","body":"> <@bot:x> job failed\n\nretry with -v",
+		"m.relates_to":{"m.in_reply_to":{"event_id":"$9"}}}}`))
+	if !ok || msg.SessionKey.ThreadID != "" || msg.Text != "retry with -v" || msg.Meta.QuotedMessageID != "$9" {
+		t.Fatalf("unexpected reply %+v", msg)
+	}
+
+	for _, raw := range []string{
+		`{"type":"m.room.message","event_id":"$5","sender":"@bot:x","content":{"msgtype":"m.text","body":"own"}}`,
+		`{"type":"m.room.message","event_id":"$6","sender":"@other-bot:x","content":{"msgtype":"m.notice","body":"notice"}}`,
+	} {
+		if msg, ok := b.toDomainMessage("!r:x", event(t, raw)); ok {
+			t.Fatalf("expected event to be ignored, got %+v", msg)
+		}
+	}
+	if _, ok := b.toDomainMessage("!elsewhere:x", event(t, `{"type":"m.room.message","event_id":"$7","sender":"@a:x","content":{"msgtype":"m.text","body":"hi"}}`)); ok {
+		t.Fatal("expected message from another room to be refused")
+	}
+}
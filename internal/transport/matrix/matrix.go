@@ -0,0 +1,421 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultSyncTimeout    = 30 * time.Second
+	// maxRateLimitRetries bounds how often a request is repeated after the
+	// homeserver answered M_LIMIT_EXCEEDED.
+	maxRateLimitRetries = 3
+
+	sinceStateKey = "sync_since"
+	htmlFormat    = "org.matrix.custom.html"
+)
+
+// syncFilter limits /sync to room messages and skips presence and account
+// data, which the bot has no use for.
+const syncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},"room":{"timeline":{"types":["m.room.message"],"limit":50},"state":{"lazy_load_members":true},"ephemeral":{"types":[]},"account_data":{"types":[]}}}`
+
+// Bot is a Matrix client that long polls /sync on a homeserver and answers
+// in the rooms it was invited to. Messages in a thread get a session keyed
+// by the room and the thread's root event.
+type Bot struct {
+	homeserver     string
+	token          string
+	allowedUsers   map[string]bool
+	allowedRooms   map[string]bool
+	httpClient     *http.Client
+	requestTimeout time.Duration
+	syncTimeout    time.Duration
+	state          StateStore
+	// since is the next_batch token of the last handled sync.
+	since string
+	// userID is the bot's own user, filled in from whoami on Start and used
+	// to ignore its own events and invites for other users.
+	userID    string
+	txnPrefix string
+	txn       atomic.Int64
+	// threads maps event IDs of inbound messages to their thread root, so
+	// edits, which do not carry the thread, reach the same session.
+	threads sync.Map
+	// reactions maps "room/event" to the reaction event the bot put on it,
+	// so it can be redacted when the status changes.
+	reactions sync.Map
+}
+
+// StateStore persists transport state across restarts.
+type StateStore interface {
+	TransportState(ctx context.Context, platform domain.Platform, key string) (string, error)
+	SetTransportState(ctx context.Context, platform domain.Platform, key, value string) error
+}
+
+// Option configures optional Bot behavior.
+type Option func(*Bot)
+
+// WithStateStore persists the sync token so events handled before a
+// restart are not delivered again.
+func WithStateStore(st StateStore) Option {
+	return func(b *Bot) {
+		b.state = st
+	}
+}
+
+// WithAllowedUsers allows the given user IDs, such as "@me:example.org",
+// to use the bot in any room, and to invite it.
+func WithAllowedUsers(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedUsers[id] = true
+		}
+	}
+}
+
+// WithAllowedRooms allows every member of the given rooms to use the bot
+// there.
+func WithAllowedRooms(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedRooms[id] = true
+		}
+	}
+}
+
+// WithSyncTimeout sets how long /sync long polls. Zero keeps the default.
+func WithSyncTimeout(d time.Duration) Option {
+	return func(b *Bot) {
+		if d > 0 {
+			b.syncTimeout = d
+		}
+	}
+}
+
+// New returns a bot for the account of accessToken on homeserver, the
+// client-server API base URL such as "https://matrix.example.org".
+func New(homeserver, accessToken string, opts ...Option) *Bot {
+	b := &Bot{
+		homeserver:     strings.TrimRight(homeserver, "/"),
+		token:          accessToken,
+		allowedUsers:   make(map[string]bool),
+		allowedRooms:   make(map[string]bool),
+		httpClient:     &http.Client{},
+		requestTimeout: defaultRequestTimeout,
+		syncTimeout:    defaultSyncTimeout,
+		txnPrefix:      strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *Bot) Name() string { return "matrix" }
+
+// Start syncs until ctx is done. Without a stored sync token the first sync
+// only catches up, so messages sent before the bot first started are not
+// run.
+func (b *Bot) Start(ctx context.Context, handler domain.MessageHandler) error {
+	slog.Info("transport started", "transport", "matrix", "homeserver", b.homeserver)
+	for b.userID == "" {
+		// Without its own user ID the bot would answer its own messages.
+		if err := b.whoami(ctx); err != nil {
+			slog.Error("matrix whoami failed", "error", err)
+			if !sleep(ctx, 5*time.Second) {
+				return ctx.Err()
+			}
+		}
+	}
+	b.restoreSince(ctx)
+	catchUp := b.since == ""
+	for {
+		timeout := b.syncTimeout
+		if catchUp {
+			timeout = 0
+		}
+		resp, err := b.sync(ctx, timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Error("matrix sync failed", "error", err)
+			if !sleep(ctx, 2*time.Second) {
+				return ctx.Err()
+			}
+			continue
+		}
+		b.handleSync(ctx, resp, handler, !catchUp)
+		catchUp = false
+		b.since = resp.NextBatch
+		b.saveSince(ctx)
+	}
+}
+
+func (b *Bot) whoami(ctx context.Context) error {
+	var me struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/account/whoami", nil, &me); err != nil {
+		return err
+	}
+	if me.UserID == "" {
+		return errors.New("matrix whoami: empty user_id")
+	}
+	b.userID = me.UserID
+	return nil
+}
+
+func (b *Bot) sync(ctx context.Context, timeout time.Duration) (*syncResponse, error) {
+	q := url.Values{"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)}, "filter": {syncFilter}}
+	if b.since != "" {
+		q.Set("since", b.since)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout+b.requestTimeout)
+	defer cancel()
+	var resp syncResponse
+	if err := b.doOnce(ctx, http.MethodGet, "/sync?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *Bot) restoreSince(ctx context.Context) {
+	if b.state == nil {
+		return
+	}
+	v, err := b.state.TransportState(ctx, domain.PlatformMatrix, sinceStateKey)
+	if err != nil {
+		slog.Error("matrix restore sync token failed", "error", err)
+		return
+	}
+	b.since = v
+}
+
+func (b *Bot) saveSince(ctx context.Context) {
+	if b.state == nil {
+		return
+	}
+	if err := b.state.SetTransportState(ctx, domain.PlatformMatrix, sinceStateKey, b.since); err != nil {
+		slog.Error("matrix save sync token failed", "error", err)
+	}
+}
+
+// Send posts msg to the room, with HTML in the org.matrix.custom.html
+// format. Messages of a thread session are posted in the thread; a reply
+// target is quoted with m.in_reply_to.
+func (b *Bot) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	content := messageContent(msg)
+	if rel := relation(msg); rel != nil {
+		content["m.relates_to"] = rel
+	}
+	id, err := b.sendEvent(ctx, msg.SessionKey.ChatID, "m.room.message", content)
+	if err != nil {
+		return domain.MessageRef{}, err
+	}
+	return domain.MessageRef{SessionKey: msg.SessionKey, ID: id}, nil
+}
+
+// Edit replaces the content of a message previously returned by Send with
+// an m.replace event. Clients without edit support show the fallback body,
+// marked with "* ".
+func (b *Bot) Edit(ctx context.Context, ref domain.MessageRef, msg domain.OutboundMessage) error {
+	newContent := messageContent(msg)
+	content := map[string]any{
+		"msgtype":       newContent["msgtype"],
+		"body":          "* " + newContent["body"].(string),
+		"m.new_content": newContent,
+		"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": ref.ID},
+	}
+	if f, ok := newContent["formatted_body"].(string); ok {
+		content["format"] = htmlFormat
+		content["formatted_body"] = "* " + f
+	}
+	_, err := b.sendEvent(ctx, ref.SessionKey.ChatID, "m.room.message", content)
+	return err
+}
+
+// React annotates a message with emoji, redacting the bot's previous
+// reaction on it.
+func (b *Bot) React(ctx context.Context, ref domain.MessageRef, emoji string) error {
+	room := ref.SessionKey.ChatID
+	key := room + "/" + ref.ID
+	if prev, ok := b.reactions.Load(key); ok {
+		path := "/rooms/" + url.PathEscape(room) + "/redact/" + url.PathEscape(prev.(string)) + "/" + b.nextTxn()
+		if err := b.do(ctx, http.MethodPut, path, map[string]any{}, nil); err != nil {
+			return err
+		}
+		b.reactions.Delete(key)
+	}
+	if emoji == "" {
+		return nil
+	}
+	id, err := b.sendEvent(ctx, room, "m.reaction", map[string]any{
+		"m.relates_to": map[string]any{"rel_type": "m.annotation", "event_id": ref.ID, "key": emoji},
+	})
+	if err != nil {
+		return err
+	}
+	b.reactions.Store(key, id)
+	return nil
+}
+
+func (b *Bot) sendEvent(ctx context.Context, room, eventType string, content map[string]any) (string, error) {
+	var sent struct {
+		EventID string `json:"event_id"`
+	}
+	path := "/rooms/" + url.PathEscape(room) + "/send/" + eventType + "/" + b.nextTxn()
+	if err := b.do(ctx, http.MethodPut, path, content, &sent); err != nil {
+		return "", err
+	}
+	return sent.EventID, nil
+}
+
+// nextTxn returns a transaction ID; the homeserver drops repeated sends
+// with the same ID, which makes retries safe.
+func (b *Bot) nextTxn() string {
+	return b.txnPrefix + "." + strconv.FormatInt(b.txn.Add(1), 10)
+}
+
+// messageContent is the m.room.message content for msg. Bots send
+// m.notice, which other bots do not answer.
+func messageContent(msg domain.OutboundMessage) map[string]any {
+	content := map[string]any{"msgtype": "m.notice", "body": msg.Text}
+	if msg.Format == "html" {
+		content["body"] = render.PlainText(msg.Text)
+		content["format"] = htmlFormat
+		content["formatted_body"] = render.HTMLToMatrix(msg.Text)
+	}
+	return content
+}
+
+// relation threads msg under its session's thread root and quotes its
+// reply target.
+func relation(msg domain.OutboundMessage) map[string]any {
+	thread, reply := msg.SessionKey.ThreadID, msg.ReplyToMessageID
+	switch {
+	case thread != "" && reply != "":
+		return map[string]any{"rel_type": "m.thread", "event_id": thread, "is_falling_back": false, "m.in_reply_to": map[string]any{"event_id": reply}}
+	case thread != "":
+		// Clients without thread support show the message as a reply to
+		// the root.
+		return map[string]any{"rel_type": "m.thread", "event_id": thread, "is_falling_back": true, "m.in_reply_to": map[string]any{"event_id": thread}}
+	case reply != "":
+		return map[string]any{"m.in_reply_to": map[string]any{"event_id": reply}}
+	}
+	return nil
+}
+
+// do sends a client-server API request below /_matrix/client/v3 and
+// decodes the response into result when it is non-nil. Rate-limited
+// requests are repeated after the delay the homeserver asks for.
+func (b *Bot) do(ctx context.Context, method, path string, payload, result any) error {
+	for attempt := 0; ; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+		err := b.doOnce(reqCtx, method, path, payload, result)
+		cancel()
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter == 0 || attempt >= maxRateLimitRetries {
+			return err
+		}
+		slog.Warn("matrix rate limited", "path", path, "retry_after", apiErr.RetryAfter)
+		if !sleep(ctx, apiErr.RetryAfter) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *Bot) doOnce(ctx context.Context, method, path string, payload, result any) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("matrix %s: encode payload: %w", endpoint(path), err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.homeserver+"/_matrix/client/v3"+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("matrix %s: %w", endpoint(path), err)
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("matrix %s: %w", endpoint(path), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			ErrCode      string `json:"errcode"`
+			Error        string `json:"error"`
+			RetryAfterMS int64  `json:"retry_after_ms"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		apiErr := &apiError{Endpoint: endpoint(path), StatusCode: resp.StatusCode, ErrCode: failure.ErrCode, Message: failure.Error}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			apiErr.RetryAfter = time.Duration(failure.RetryAfterMS) * time.Millisecond
+			if apiErr.RetryAfter <= 0 {
+				apiErr.RetryAfter = time.Second
+			}
+		}
+		return apiErr
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("matrix %s: decode response: %w", endpoint(path), err)
+		}
+	}
+	return nil
+}
+
+// endpoint names a request in errors and logs without its query, which
+// may be long.
+func endpoint(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	return path
+}
+
+type apiError struct {
+	Endpoint   string
+	StatusCode int
+	// ErrCode and Message are the homeserver's error, such as
+	// M_FORBIDDEN.
+	ErrCode string
+	Message string
+	// RetryAfter is set on 429 responses.
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.ErrCode == "" {
+		return fmt.Sprintf("matrix %s status=%d", e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("matrix %s status=%d: %s %s", e.Endpoint, e.StatusCode, e.ErrCode, e.Message)
+}
+
+// sleep waits for d and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,229 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// fakeHomeserver is a local stand-in for the client-server API.
+type fakeHomeserver struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+	server   *httptest.Server
+	// syncs are answered in order; later syncs block until the request
+	// is cancelled.
+	syncs      []string
+	sinces     []string
+	rateLimits int
+}
+
+func newFakeHomeserver(t *testing.T) *fakeHomeserver {
+	t.Helper()
+	f := &fakeHomeserver{bodies: make(map[string]string)}
+	f.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(req.URL.EscapedPath(), "/_matrix/client/v3")
+		raw, _ := io.ReadAll(req.Body)
+		rw.Header().Set("Content-Type", "application/json")
+		f.mu.Lock()
+		if path == "/sync" {
+			f.sinces = append(f.sinces, req.URL.Query().Get("since"))
+			if len(f.syncs) == 0 {
+				f.mu.Unlock()
+				<-req.Context().Done()
+				return
+			}
+			resp := f.syncs[0]
+			f.syncs = f.syncs[1:]
+			f.mu.Unlock()
+			_, _ = io.WriteString(rw, resp)
+			return
+		}
+		// Transaction IDs differ per run; record sends by event type.
+		call := req.Method + " " + path
+		if i := strings.LastIndex(path, "/"); strings.Contains(path, "/send/") || strings.Contains(path, "/redact/") {
+			call = req.Method + " " + path[:i]
+		}
+		f.requests = append(f.requests, call)
+		f.bodies[call] = string(raw)
+		eventID := fmt.Sprintf("$sent%d", len(f.requests))
+		limited := f.rateLimits > 0 && strings.Contains(path, "/send/")
+		if limited {
+			f.rateLimits--
+		}
+		f.mu.Unlock()
+		switch {
+		case limited:
+			rw.WriteHeader(http.StatusTooManyRequests)
+			_, _ = io.WriteString(rw, `{"errcode":"M_LIMIT_EXCEEDED","error":"slow down","retry_after_ms":10}`)
+		case path == "/account/whoami":
+			_, _ = io.WriteString(rw, `{"user_id":"@bot:example.org"}`)
+		case strings.Contains(path, "/send/"):
+			_, _ = io.WriteString(rw, `{"event_id":"`+eventID+`"}`)
+		default:
+			_, _ = io.WriteString(rw, `{}`)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeHomeserver) called(call string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.bodies[call]
+	return body, ok
+}
+
+type memoryState map[string]string
+
+func (m memoryState) TransportState(_ context.Context, _ domain.Platform, key string) (string, error) {
+	return m[key], nil
+}
+
+func (m memoryState) SetTransportState(_ context.Context, _ domain.Platform, key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func TestSendPostsHTMLInThread(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	hs.rateLimits = 1
+	b := New(hs.server.URL, "secret")
+	key := domain.SessionKey{Platform: domain.PlatformMatrix, ChatID: "!room:example.org", ThreadID: "$root"}
+	ref, err := b.Send(context.Background(), domain.OutboundMessage{
+		SessionKey:       key,
+		Text:             "<b>done</b>\nall good",
+		Format:           "html",
+		ReplyToMessageID: "$prompt",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if ref.ID == "" || ref.SessionKey != key {
+		t.Fatalf("unexpected ref %+v", ref)
+	}
+	raw, ok := hs.called("PUT /rooms/%21room:example.org/send/m.room.message")
+	if !ok {
+		t.Fatalf("expected message to be sent, got %v", hs.requests)
+	}
+	var content struct {
+		MsgType       string        `json:"msgtype"`
+		Body          string        `json:"body"`
+		Format        string        `json:"format"`
+		FormattedBody string        `json:"formatted_body"`
+		RelatesTo     eventRelation `json:"m.relates_to"`
+	}
+	_ = json.Unmarshal([]byte(raw), &content)
+	if content.Body != "done\nall good" || content.Format != "org.matrix.custom.html" || content.FormattedBody != "<b>done</b><br>all good" {
+		t.Fatalf("unexpected content %s", raw)
+	}
+	rel := content.RelatesTo
+	if rel.RelType != "m.thread" || rel.EventID != "$root" || rel.IsFallingBack || rel.InReplyTo == nil || rel.InReplyTo.EventID != "$prompt" {
+		t.Fatalf("unexpected relation %s", raw)
+	}
+}
+
+func TestEditAndReact(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	b := New(hs.server.URL, "secret")
+	ref := domain.MessageRef{SessionKey: domain.SessionKey{Platform: domain.PlatformMatrix, ChatID: "!r:x"}, ID: "$m1"}
+	if err := b.Edit(context.Background(), ref, domain.OutboundMessage{Text: "<i>running</i>", Format: "html"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	raw, _ := hs.called("PUT /rooms/%21r:x/send/m.room.message")
+	var edit struct {
+		Body       string `json:"body"`
+		NewContent struct {
+			FormattedBody string `json:"formatted_body"`
+		} `json:"m.new_content"`
+		RelatesTo eventRelation `json:"m.relates_to"`
+	}
+	_ = json.Unmarshal([]byte(raw), &edit)
+	if edit.Body != "* running" || edit.NewContent.FormattedBody != "<i>running</i>" || edit.RelatesTo.RelType != "m.replace" || edit.RelatesTo.EventID != "$m1" {
+		t.Fatalf("unexpected edit %s", raw)
+	}
+
+	if err := b.React(context.Background(), ref, "👀"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	if err := b.React(context.Background(), ref, "👍"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	first := hs.requests[1]
+	if _, ok := hs.called("PUT /rooms/%21r:x/redact/$sent2"); !ok || first != "PUT /rooms/%21r:x/send/m.reaction" {
+		t.Fatalf("expected first reaction to be redacted, got %v", hs.requests)
+	}
+	raw, _ = hs.called("PUT /rooms/%21r:x/send/m.reaction")
+	if !strings.Contains(raw, `"key":"👍"`) || !strings.Contains(raw, `"m.annotation"`) {
+		t.Fatalf("unexpected reaction %s", raw)
+	}
+}
+
+func TestStartSkipsHistoryAndDeliversNewMessages(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	hs.syncs = []string{
+		`{"next_batch":"s1","rooms":{"join":{"!r:x":{"timeline":{"events":[
+			{"type":"m.room.message","event_id":"$old","sender":"@me:x","content":{"msgtype":"m.text","body":"old prompt"}}]}}},
+		"invite":{"!new:x":{"invite_state":{"events":[
+			{"type":"m.room.member","state_key":"@bot:example.org","sender":"@me:x","content":{"membership":"invite"}}]}}}}}`,
+		`{"next_batch":"s2","rooms":{"join":{"!r:x":{"timeline":{"events":[
+			{"type":"m.room.message","event_id":"$own","sender":"@bot:example.org","content":{"msgtype":"m.text","body":"echo"}},
+			{"type":"m.room.message","event_id":"$stranger","sender":"@eve:x","content":{"msgtype":"m.text","body":"rm -rf"}},
+			{"type":"m.room.message","event_id":"$new","sender":"@me:x","content":{"msgtype":"m.text","body":"fix the build"}}]}}}}}`,
+	}
+	state := memoryState{}
+	b := New(hs.server.URL, "secret", WithAllowedUsers("@me:x"), WithStateStore(state))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan domain.Message, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Start(ctx, func(_ context.Context, msg domain.Message) error {
+			got <- msg
+			return nil
+		})
+	}()
+
+	select {
+	case msg := <-got:
+		want := domain.SessionKey{Platform: domain.PlatformMatrix, ChatID: "!r:x"}
+		if msg.SessionKey != want || msg.Text != "fix the build" || msg.Meta.MessageID != "$new" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sync did not stop")
+	}
+	if len(got) != 0 {
+		t.Fatalf("unexpected extra message %+v", <-got)
+	}
+	if _, ok := hs.called("POST /join/%21new:x"); !ok {
+		t.Fatalf("expected invite to be accepted, got %v", hs.requests)
+	}
+	if state[sinceStateKey] != "s2" {
+		t.Fatalf("expected sync token to be stored, got %q", state[sinceStateKey])
+	}
+	if hs.sinces[0] != "" || hs.sinces[1] != "s1" {
+		t.Fatalf("unexpected since tokens %q", hs.sinces)
+	}
+}
@@ -0,0 +1,78 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Slack signs every request with the app's signing secret: the signature is
+// "v0=" followed by the hex HMAC-SHA256 of "v0:<timestamp>:<body>".
+const (
+	timestampHeader = "X-Slack-Request-Timestamp"
+	signatureHeader = "X-Slack-Signature"
+	signatureFormat = "v0"
+
+	// replayWindow is the maximum age of a request, as recommended by
+	// Slack.
+	replayWindow    = 5 * time.Minute
+	maxRequestBytes = 1 << 20
+)
+
+type verifier struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func newVerifier(secret string) *verifier {
+	return &verifier{secret: []byte(secret), now: time.Now}
+}
+
+func (v *verifier) sign(ts string, body []byte) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(signatureFormat + ":" + ts + ":"))
+	mac.Write(body)
+	return signatureFormat + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a request signature and rejects timestamps outside the
+// replay window.
+func (v *verifier) verify(ts, signature string, body []byte) bool {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	sent := time.Unix(sec, 0)
+	if now := v.now(); sent.Before(now.Add(-replayWindow)) || sent.After(now.Add(replayWindow)) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(v.sign(ts, body)))
+}
+
+// verified rejects requests that are not signed with the signing secret.
+func (b *Bot) verified(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxRequestBytes))
+		if err != nil {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !b.auth.verify(req.Header.Get(timestampHeader), req.Header.Get(signatureHeader), body) {
+			slog.Warn("slack request rejected", "path", req.URL.Path, "remote_addr", req.RemoteAddr)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		next(rw, req)
+	}
+}
@@ -0,0 +1,44 @@
+package slack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifiedRequiresFreshSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := New("xoxb-token", "s3cret", "127.0.0.1:0")
+	b.auth.now = func() time.Time { return now }
+	calls := 0
+	h := b.verified(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusOK)
+	})
+	body := `{"type":"url_verification","challenge":"abc"}`
+	do := func(ts time.Time, secret string) int {
+		req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		req.Header.Set(timestampHeader, stamp)
+		req.Header.Set(signatureHeader, newVerifier(secret).sign(stamp, []byte(body)))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	if code := do(now, "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected bad signature to be rejected, got %d", code)
+	}
+	if code := do(now.Add(-6*time.Minute), "s3cret"); code != http.StatusUnauthorized {
+		t.Fatalf("expected stale timestamp to be rejected, got %d", code)
+	}
+	if code := do(now.Add(-10*time.Second), "s3cret"); code != http.StatusOK {
+		t.Fatalf("expected signed request to pass, got %d", code)
+	}
+	if calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls)
+	}
+}
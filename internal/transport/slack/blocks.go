@@ -0,0 +1,57 @@
+package slack
+
+import (
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+// sectionBytes bounds the source text of one section block. Slack allows
+// 3000 characters per section; the margin covers markup added by the
+// conversion to mrkdwn.
+const sectionBytes = 2500
+
+// messageBlocks renders msg as Block Kit blocks: HTML output becomes mrkdwn
+// sections, split so every section holds well-formed markup, plain text
+// becomes plain_text sections, and actions become a row of buttons.
+func messageBlocks(msg domain.OutboundMessage) []map[string]any {
+	var blocks []map[string]any
+	if msg.Format == "html" {
+		for _, part := range render.SplitHTML(msg.Text, sectionBytes) {
+			if text := render.HTMLToSlack(part); text != "" {
+				blocks = append(blocks, section("mrkdwn", text))
+			}
+		}
+	} else {
+		for _, part := range render.SplitText(msg.Text, sectionBytes) {
+			if part != "" {
+				blocks = append(blocks, section("plain_text", part))
+			}
+		}
+	}
+	if len(msg.Actions) > 0 {
+		blocks = append(blocks, actionsBlock(msg.Actions))
+	}
+	return blocks
+}
+
+func section(kind, text string) map[string]any {
+	return map[string]any{
+		"type": "section",
+		"text": map[string]any{"type": kind, "text": text},
+	}
+}
+
+// actionsBlock renders actions as buttons. Slack reports a pressed button
+// with its action_id and value, which carry the action's name and data.
+func actionsBlock(actions []domain.Action) map[string]any {
+	elements := make([]map[string]any, 0, len(actions))
+	for _, a := range actions {
+		elements = append(elements, map[string]any{
+			"type":      "button",
+			"text":      map[string]any{"type": "plain_text", "text": a.Label},
+			"action_id": a.Name,
+			"value":     a.Data,
+		})
+	}
+	return map[string]any{"type": "actions", "elements": elements}
+}
@@ -0,0 +1,276 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// inboundQueueSize bounds messages acknowledged to Slack but not yet
+// handled. Slack expects an answer within three seconds, so handling
+// happens after the request is acknowledged.
+const inboundQueueSize = 256
+
+type eventEnvelope struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event     event  `json:"event"`
+}
+
+type event struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+	Edited      *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	// Message is the new version of an edited message.
+	Message *event `json:"message"`
+}
+
+// handleEvents serves the Events API. Slack redelivers events that are not
+// acknowledged in time; the orchestrator drops redelivered messages by ts.
+func (b *Bot) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	var env eventEnvelope
+	if err := json.NewDecoder(req.Body).Decode(&env); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch env.Type {
+	case "url_verification":
+		rw.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(rw, env.Challenge)
+		return
+	case "event_callback":
+	default:
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	msg, ok := b.eventMessage(env.Event)
+	if !ok {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	if !b.enqueue(msg) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	slog.Info("slack inbound message",
+		"chat_id", msg.SessionKey.ChatID,
+		"thread_id", msg.SessionKey.ThreadID,
+		"sender_id", msg.SenderID,
+		"edited", msg.Meta.EditID != "",
+	)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// eventMessage maps a message or app_mention event to a domain message.
+// Edits arrive as message_changed events wrapping the new message. Bot
+// messages, other subtypes and messages not addressed to the bot are
+// dropped.
+func (b *Bot) eventMessage(ev event) (domain.Message, bool) {
+	if ev.Type != "message" && ev.Type != "app_mention" {
+		return domain.Message{}, false
+	}
+	channel, channelType := ev.Channel, ev.ChannelType
+	editID := ""
+	switch ev.Subtype {
+	case "", "thread_broadcast", "file_share":
+	case "message_changed":
+		if ev.Message == nil || ev.Message.Edited == nil {
+			return domain.Message{}, false
+		}
+		ev = *ev.Message
+		editID = ev.Edited.TS
+	default:
+		return domain.Message{}, false
+	}
+	if ev.BotID != "" || ev.User == "" || ev.User == b.userID {
+		return domain.Message{}, false
+	}
+	if !b.allowed(ev.User, channel) {
+		return domain.Message{}, false
+	}
+	text, ok := b.addressedText(ev, channel, channelType)
+	if !ok {
+		return domain.Message{}, false
+	}
+	return domain.Message{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformSlack, ChatID: channel, ThreadID: ev.ThreadTS},
+		SenderID:   ev.User,
+		Text:       text,
+		Meta: domain.InboundMessageMeta{
+			MessageID:        ev.TS,
+			ReplyToMessageID: ev.TS,
+			EditID:           editID,
+			Raw:              map[string]string{"slack_ts": ev.TS},
+		},
+		At: time.Now().UTC(),
+	}, true
+}
+
+// addressedText decides whether a message is meant for the bot and returns
+// its text with the bot's mention removed. Direct messages always address
+// the bot. In channels only mentions and messages in threads the bot posted
+// in do.
+func (b *Bot) addressedText(ev event, channel, channelType string) (string, bool) {
+	text := unescapeText(ev.Text)
+	mentioned := ev.Type == "app_mention"
+	if b.mention != nil && b.mention.MatchString(text) {
+		text = strings.TrimSpace(b.mention.ReplaceAllString(text, ""))
+		mentioned = true
+	}
+	if channelType == "im" || mentioned {
+		return text, true
+	}
+	if ev.ThreadTS != "" {
+		if _, ok := b.threads.Load(channel + ":" + ev.ThreadTS); ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// handleCommand serves slash commands. Commands registered for the app
+// under the names of the chat commands (/cd, /codex, ...) are handled as
+// if "/<command> <text>" had been sent in the channel. Slack does not tell
+// which thread a command was used in, so commands apply to the channel's
+// session; inside threads, mention the bot with the command instead.
+func (b *Bot) handleCommand(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	command, userID, channel := req.PostForm.Get("command"), req.PostForm.Get("user_id"), req.PostForm.Get("channel_id")
+	if !strings.HasPrefix(command, "/") || channel == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !b.allowed(userID, channel) {
+		writeEphemeral(rw, "You are not allowed to use this bot here.")
+		return
+	}
+	msg := domain.Message{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformSlack, ChatID: channel},
+		SenderID:   userID,
+		Text:       strings.TrimSpace(command + " " + unescapeText(req.PostForm.Get("text"))),
+		Meta:       domain.InboundMessageMeta{Raw: map[string]string{"slack_trigger_id": req.PostForm.Get("trigger_id")}},
+		At:         time.Now().UTC(),
+	}
+	if !b.enqueue(msg) {
+		writeEphemeral(rw, "Too many pending messages, try again shortly.")
+		return
+	}
+	slog.Info("slack inbound command", "chat_id", channel, "sender_id", userID, "command", command)
+	rw.WriteHeader(http.StatusOK)
+}
+
+type interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Message struct {
+		TS       string `json:"ts"`
+		ThreadTS string `json:"thread_ts"`
+	} `json:"message"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// handleInteraction serves button presses on messages rendered by
+// actionsBlock and delivers them as Message.Action.
+func (b *Bot) handleInteraction(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var in interaction
+	if err := json.Unmarshal([]byte(req.PostForm.Get("payload")), &in); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if in.Type != "block_actions" || len(in.Actions) == 0 || !b.allowed(in.User.ID, in.Channel.ID) {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	a := in.Actions[0]
+	msg := domain.Message{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformSlack, ChatID: in.Channel.ID, ThreadID: in.Message.ThreadTS},
+		SenderID:   in.User.ID,
+		Action:     &domain.Action{Name: a.ActionID, Data: a.Value},
+		Meta:       domain.InboundMessageMeta{ReplyToMessageID: in.Message.TS},
+		At:         time.Now().UTC(),
+	}
+	if !b.enqueue(msg) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	slog.Info("slack inbound action",
+		"chat_id", msg.SessionKey.ChatID,
+		"thread_id", msg.SessionKey.ThreadID,
+		"sender_id", msg.SenderID,
+		"action", a.ActionID,
+	)
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (b *Bot) allowed(userID, channel string) bool {
+	return b.allowedUsers[userID] || (channel != "" && b.allowedChans[channel])
+}
+
+func (b *Bot) enqueue(msg domain.Message) bool {
+	select {
+	case b.inbound <- msg:
+		return true
+	default:
+		slog.Warn("slack inbound queue is full", "chat_id", msg.SessionKey.ChatID)
+		return false
+	}
+}
+
+// processInbound hands queued messages to the handler one at a time, so
+// messages are handled in the order Slack delivered them.
+func (b *Bot) processInbound(ctx context.Context, handler domain.MessageHandler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-b.inbound:
+			if err := handler(ctx, msg); err != nil {
+				slog.Error("slack message handling failed", "chat_id", msg.SessionKey.ChatID, "error", err)
+			}
+		}
+	}
+}
+
+// writeEphemeral answers a slash command with a message only the user who
+// ran it sees.
+func writeEphemeral(rw http.ResponseWriter, text string) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]string{"response_type": "ephemeral", "text": text})
+}
+
+var textUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// unescapeText undoes Slack's escaping of &, < and > in message text.
+func unescapeText(text string) string {
+	return textUnescaper.Replace(text)
+}
@@ -0,0 +1,124 @@
+package slack
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+func signedRequest(t *testing.T, b *Bot, path, contentType, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, b.auth.sign(ts, []byte(body)))
+	return req
+}
+
+func newTestBot() *Bot {
+	b := New("xoxb-token", "secret", "127.0.0.1:0", WithAllowedUsers("U1"))
+	b.userID = "UBOT"
+	b.mention = regexp.MustCompile(`<@UBOT(\|[^>]*)?>`)
+	return b
+}
+
+func TestEventsURLVerification(t *testing.T) {
+	b := newTestBot()
+	rec := httptest.NewRecorder()
+	b.verified(b.handleEvents)(rec, signedRequest(t, b, "/slack/events", "application/json", `{"type":"url_verification","challenge":"abc"}`))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc" {
+		t.Fatalf("expected challenge echo, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEventMessageAddressing(t *testing.T) {
+	b := newTestBot()
+	b.threads.Store("C1:100.1", true)
+	cases := []struct {
+		name   string
+		ev     event
+		ok     bool
+		text   string
+		thread string
+	}{
+		{"direct message", event{Type: "message", ChannelType: "im", Channel: "D1", User: "U1", Text: "fix &lt;it&gt;", TS: "1.1"}, true, "fix <it>", ""},
+		{"mention", event{Type: "app_mention", Channel: "C1", User: "U1", Text: "<@UBOT> /cd api", TS: "1.2"}, true, "/cd api", ""},
+		{"bot thread", event{Type: "message", ChannelType: "channel", Channel: "C1", User: "U1", Text: "go on", TS: "1.3", ThreadTS: "100.1"}, true, "go on", "100.1"},
+		{"channel chatter", event{Type: "message", ChannelType: "channel", Channel: "C1", User: "U1", Text: "hello", TS: "1.4"}, false, "", ""},
+		{"other user", event{Type: "message", ChannelType: "im", Channel: "D2", User: "U2", Text: "hi", TS: "1.5"}, false, "", ""},
+		{"own message", event{Type: "message", ChannelType: "im", Channel: "D1", User: "UBOT", Text: "done", TS: "1.6"}, false, "", ""},
+		{"bot message", event{Type: "message", Subtype: "bot_message", ChannelType: "im", Channel: "D1", BotID: "B1", Text: "x", TS: "1.7"}, false, "", ""},
+	}
+	for _, c := range cases {
+		msg, ok := b.eventMessage(c.ev)
+		if ok != c.ok {
+			t.Fatalf("%s: expected ok=%v, got %v", c.name, c.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+		if msg.Text != c.text || msg.SessionKey.ThreadID != c.thread || msg.SessionKey.Platform != domain.PlatformSlack {
+			t.Fatalf("%s: unexpected message %+v", c.name, msg)
+		}
+		if msg.Meta.MessageID != c.ev.TS || msg.Meta.ReplyToMessageID != c.ev.TS {
+			t.Fatalf("%s: expected ts as message ID, got %+v", c.name, msg.Meta)
+		}
+	}
+
+	edited := event{Type: "message", Subtype: "message_changed", Channel: "D1", ChannelType: "im", Message: &event{
+		Type: "message", User: "U1", Text: "fixed prompt", TS: "1.1", Edited: &struct {
+			TS string `json:"ts"`
+		}{TS: "1.9"},
+	}}
+	msg, ok := b.eventMessage(edited)
+	if !ok || msg.Meta.MessageID != "1.1" || msg.Meta.EditID != "1.9" || msg.Text != "fixed prompt" {
+		t.Fatalf("unexpected edit mapping: %v %+v", ok, msg)
+	}
+}
+
+func TestSlashCommandIsQueuedAsChatCommand(t *testing.T) {
+	b := newTestBot()
+	form := url.Values{"command": {"/cd"}, "text": {"api"}, "user_id": {"U1"}, "channel_id": {"C1"}}
+	rec := httptest.NewRecorder()
+	b.verified(b.handleCommand)(rec, signedRequest(t, b, "/slack/commands", "application/x-www-form-urlencoded", form.Encode()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	msg := <-b.inbound
+	if msg.Text != "/cd api" || msg.SessionKey.ChatID != "C1" || msg.SessionKey.ThreadID != "" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	form.Set("user_id", "U2")
+	rec = httptest.NewRecorder()
+	b.verified(b.handleCommand)(rec, signedRequest(t, b, "/slack/commands", "application/x-www-form-urlencoded", form.Encode()))
+	if !strings.Contains(rec.Body.String(), "ephemeral") || len(b.inbound) != 0 {
+		t.Fatalf("expected ephemeral refusal, got %q", rec.Body.String())
+	}
+}
+
+func TestInteractionIsDeliveredAsAction(t *testing.T) {
+	b := newTestBot()
+	payload := `{"type":"block_actions","user":{"id":"U1"},"channel":{"id":"C1"},"message":{"ts":"2.1","thread_ts":"100.1"},"actions":[{"action_id":"stop","value":"job1"}]}`
+	form := url.Values{"payload": {payload}}
+	rec := httptest.NewRecorder()
+	b.verified(b.handleInteraction)(rec, signedRequest(t, b, "/slack/interactivity", "application/x-www-form-urlencoded", form.Encode()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	msg := <-b.inbound
+	if msg.Action == nil || msg.Action.Name != domain.ActionStopJob || msg.Action.Data != "job1" {
+		t.Fatalf("unexpected action %+v", msg.Action)
+	}
+	if msg.SessionKey.ThreadID != "100.1" || msg.Meta.ReplyToMessageID != "2.1" {
+		t.Fatalf("unexpected key or reply target: %+v", msg)
+	}
+}
@@ -0,0 +1,312 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+const (
+	defaultAPIBase        = "https://slack.com/api"
+	defaultRequestTimeout = 30 * time.Second
+	// maxRateLimitRetries bounds how often a call is repeated after Slack
+	// answered 429.
+	maxRateLimitRetries = 3
+)
+
+// Bot is a Slack app that receives messages through the Events API, slash
+// commands and interactive components, and answers through the Web API.
+// Top-level channel messages share the channel's session; messages in a
+// thread get a session per thread, keyed by the thread's thread_ts.
+type Bot struct {
+	token          string
+	listenAddr     string
+	auth           *verifier
+	allowedUsers   map[string]bool
+	allowedChans   map[string]bool
+	apiBase        string
+	httpClient     *http.Client
+	requestTimeout time.Duration
+	inbound        chan domain.Message
+	server         *http.Server
+	// userID is the bot's own user, filled in from auth.test on Start and
+	// used to ignore its own messages and strip mentions.
+	userID  string
+	mention *regexp.Regexp
+	// threads holds "channel:thread_ts" of threads the bot posted in;
+	// messages there address the bot without a mention.
+	threads sync.Map
+	// reactions holds the emoji name the bot last put on "channel:ts", so it
+	// can be removed when the status changes.
+	reactions sync.Map
+}
+
+// Option configures optional Bot behavior.
+type Option func(*Bot)
+
+// WithAPIBase points the bot at another Web API base URL, such as a local
+// fake in tests.
+func WithAPIBase(base string) Option {
+	return func(b *Bot) {
+		if base != "" {
+			b.apiBase = strings.TrimRight(base, "/")
+		}
+	}
+}
+
+// WithAllowedUsers allows the given user IDs to use the bot in any channel.
+func WithAllowedUsers(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedUsers[id] = true
+		}
+	}
+}
+
+// WithAllowedChannels allows every member of the given channels to use the
+// bot there.
+func WithAllowedChannels(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedChans[id] = true
+		}
+	}
+}
+
+// New returns a bot that posts with token and serves the Events API,
+// slash command and interactivity endpoints on listenAddr. Every request
+// must be signed with the app's signing secret.
+func New(token, signingSecret, listenAddr string, opts ...Option) *Bot {
+	b := &Bot{
+		token:          token,
+		listenAddr:     listenAddr,
+		auth:           newVerifier(signingSecret),
+		allowedUsers:   make(map[string]bool),
+		allowedChans:   make(map[string]bool),
+		apiBase:        defaultAPIBase,
+		httpClient:     &http.Client{},
+		requestTimeout: defaultRequestTimeout,
+		inbound:        make(chan domain.Message, inboundQueueSize),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *Bot) Name() string { return "slack" }
+
+func (b *Bot) Start(ctx context.Context, handler domain.MessageHandler) error {
+	if err := b.authTest(ctx); err != nil {
+		slog.Error("slack auth.test failed, channel messages need a mention", "error", err)
+	}
+	go b.processInbound(ctx, handler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/events", b.verified(b.handleEvents))
+	mux.HandleFunc("/slack/commands", b.verified(b.handleCommand))
+	mux.HandleFunc("/slack/interactivity", b.verified(b.handleInteraction))
+	b.server = &http.Server{Addr: b.listenAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("transport started", "transport", "slack", "listen_addr", b.listenAddr)
+	go func() {
+		<-ctx.Done()
+		_ = b.server.Shutdown(context.Background())
+	}()
+	if err := b.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (b *Bot) authTest(ctx context.Context) error {
+	var me struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.call(ctx, "auth.test", map[string]any{}, &me); err != nil {
+		return err
+	}
+	b.userID = me.UserID
+	if me.UserID != "" {
+		b.mention = regexp.MustCompile(`<@` + regexp.QuoteMeta(me.UserID) + `(\|[^>]*)?>`)
+	}
+	return nil
+}
+
+// Send posts msg as Block Kit blocks, with a plain text fallback for
+// notifications. Messages of a thread session are posted in the thread.
+func (b *Bot) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	payload := map[string]any{
+		"channel":      msg.SessionKey.ChatID,
+		"text":         fallbackText(msg),
+		"blocks":       messageBlocks(msg),
+		"unfurl_links": false,
+	}
+	if msg.SessionKey.ThreadID != "" {
+		payload["thread_ts"] = msg.SessionKey.ThreadID
+		b.threads.Store(msg.SessionKey.ChatID+":"+msg.SessionKey.ThreadID, true)
+	}
+	var sent struct {
+		TS string `json:"ts"`
+	}
+	if err := b.call(ctx, "chat.postMessage", payload, &sent); err != nil {
+		return domain.MessageRef{}, err
+	}
+	return domain.MessageRef{SessionKey: msg.SessionKey, ID: sent.TS}, nil
+}
+
+// Edit replaces the content of a message previously returned by Send.
+func (b *Bot) Edit(ctx context.Context, ref domain.MessageRef, msg domain.OutboundMessage) error {
+	if ref.ID == "" {
+		return errors.New("slack edit: empty message ts")
+	}
+	return b.call(ctx, "chat.update", map[string]any{
+		"channel": ref.SessionKey.ChatID,
+		"ts":      ref.ID,
+		"text":    fallbackText(msg),
+		"blocks":  messageBlocks(msg),
+	}, nil)
+}
+
+// reactionNames maps the status emojis to Slack's emoji names, which is
+// what the reactions API takes.
+var reactionNames = map[string]string{
+	"👀":   "eyes",
+	"👨‍💻": "technologist",
+	"👍":   "+1",
+	"👎":   "-1",
+	"🤷":   "shrug",
+}
+
+// React replaces the bot's reaction on a message. Slack allows several
+// reactions per user, so the previous one is removed first.
+func (b *Bot) React(ctx context.Context, ref domain.MessageRef, emoji string) error {
+	name := ""
+	if emoji != "" {
+		var ok bool
+		if name, ok = reactionNames[emoji]; !ok {
+			return fmt.Errorf("slack react: no emoji name for %q", emoji)
+		}
+	}
+	key := ref.SessionKey.ChatID + ":" + ref.ID
+	if prev, ok := b.reactions.Load(key); ok && prev.(string) != name {
+		err := b.call(ctx, "reactions.remove", map[string]any{"channel": ref.SessionKey.ChatID, "timestamp": ref.ID, "name": prev}, nil)
+		if err != nil && !isAPIError(err, "no_reaction") {
+			return err
+		}
+		b.reactions.Delete(key)
+	}
+	if name == "" {
+		return nil
+	}
+	err := b.call(ctx, "reactions.add", map[string]any{"channel": ref.SessionKey.ChatID, "timestamp": ref.ID, "name": name}, nil)
+	if err != nil && !isAPIError(err, "already_reacted") {
+		return err
+	}
+	b.reactions.Store(key, name)
+	return nil
+}
+
+// call invokes a Web API method with a JSON payload and decodes the
+// response into result when it is non-nil. Rate-limited calls are repeated
+// after the delay Slack asks for.
+func (b *Bot) call(ctx context.Context, method string, payload any, result any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("slack %s: encode payload: %w", method, err)
+	}
+	for attempt := 0; ; attempt++ {
+		err := b.do(ctx, method, body, result)
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter == 0 || attempt >= maxRateLimitRetries {
+			return err
+		}
+		slog.Warn("slack rate limited", "method", method, "retry_after", apiErr.RetryAfter)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(apiErr.RetryAfter):
+		}
+	}
+}
+
+func (b *Bot) do(ctx context.Context, method string, body []byte, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.apiBase+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retry, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &apiError{Method: method, StatusCode: resp.StatusCode, Code: "ratelimited", RetryAfter: time.Duration(max(retry, 1)) * time.Second}
+	}
+	var raw json.RawMessage
+	decodeErr := json.NewDecoder(resp.Body).Decode(&raw)
+	var envelope struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if decodeErr == nil {
+		decodeErr = json.Unmarshal(raw, &envelope)
+	}
+	if resp.StatusCode >= 300 || (decodeErr == nil && !envelope.OK) {
+		return &apiError{Method: method, StatusCode: resp.StatusCode, Code: envelope.Error}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("slack %s: decode response: %w", method, decodeErr)
+	}
+	if result != nil {
+		if err := json.Unmarshal(raw, result); err != nil {
+			return fmt.Errorf("slack %s: decode result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+type apiError struct {
+	Method     string
+	StatusCode int
+	// Code is Slack's error string, such as "channel_not_found".
+	Code string
+	// RetryAfter is set on 429 responses.
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("slack %s status=%d", e.Method, e.StatusCode)
+	}
+	return fmt.Sprintf("slack %s status=%d: %s", e.Method, e.StatusCode, e.Code)
+}
+
+func isAPIError(err error, code string) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// fallbackText is the plain text Slack shows in notifications and clients
+// without Block Kit support.
+func fallbackText(msg domain.OutboundMessage) string {
+	if msg.Format == "html" {
+		return render.PlainText(msg.Text)
+	}
+	return msg.Text
+}
@@ -0,0 +1,140 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"chatcode/internal/domain"
+)
+
+// fakeAPI is a local stand-in for the Slack Web API that records every
+// call.
+type fakeAPI struct {
+	mu     sync.Mutex
+	calls  []string
+	bodies []map[string]any
+	auth   []string
+	server *httptest.Server
+	// reply returns the status and JSON response for a method; nil means
+	// {"ok":true}.
+	reply func(method string, body map[string]any) (int, string)
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+	f := &fakeAPI{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		method := strings.TrimPrefix(req.URL.Path, "/")
+		body := map[string]any{}
+		raw, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(raw, &body)
+		f.mu.Lock()
+		f.calls = append(f.calls, method)
+		f.bodies = append(f.bodies, body)
+		f.auth = append(f.auth, req.Header.Get("Authorization"))
+		reply := f.reply
+		f.mu.Unlock()
+		status, resp := http.StatusOK, `{"ok":true}`
+		if reply != nil {
+			status, resp = reply(method, body)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_, _ = io.WriteString(rw, resp)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func TestSendRendersBlocksInThread(t *testing.T) {
+	api := newFakeAPI(t)
+	api.reply = func(method string, body map[string]any) (int, string) {
+		return http.StatusOK, `{"ok":true,"ts":"1700000000.000200"}`
+	}
+	b := New("xoxb-token", "secret", "127.0.0.1:0", WithAPIBase(api.server.URL))
+	key := domain.SessionKey{Platform: domain.PlatformSlack, ChatID: "C1", ThreadID: "1700000000.000100"}
+	ref, err := b.Send(context.Background(), domain.OutboundMessage{
+		SessionKey: key,
+		Text:       "<b>command_execution</b>\n<pre>ls &amp;&amp; pwd</pre>",
+		Format:     "html",
+		Actions:    []domain.Action{{Name: domain.ActionStopJob, Label: "Stop", Data: "job1"}},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if ref.ID != "1700000000.000200" {
+		t.Fatalf("expected ts as message ID, got %q", ref.ID)
+	}
+	if api.calls[0] != "chat.postMessage" || api.auth[0] != "Bearer xoxb-token" {
+		t.Fatalf("unexpected call %q with auth %q", api.calls[0], api.auth[0])
+	}
+	body := api.bodies[0]
+	if body["channel"] != "C1" || body["thread_ts"] != "1700000000.000100" {
+		t.Fatalf("unexpected channel or thread: %v", body)
+	}
+	if body["text"] != "command_execution\nls && pwd" {
+		t.Fatalf("unexpected fallback text %q", body["text"])
+	}
+	blocks := body["blocks"].([]any)
+	if len(blocks) != 2 {
+		t.Fatalf("expected a section and an actions block, got %v", blocks)
+	}
+	text := blocks[0].(map[string]any)["text"].(map[string]any)
+	if text["type"] != "mrkdwn" || text["text"] != "*command_execution*\n```ls &amp;&amp; pwd```" {
+		t.Fatalf("unexpected section %v", text)
+	}
+	button := blocks[1].(map[string]any)["elements"].([]any)[0].(map[string]any)
+	if button["action_id"] != domain.ActionStopJob || button["value"] != "job1" {
+		t.Fatalf("unexpected button %v", button)
+	}
+	if _, ok := b.threads.Load("C1:1700000000.000100"); !ok {
+		t.Fatal("expected thread to be remembered")
+	}
+}
+
+func TestReactReplacesPreviousReaction(t *testing.T) {
+	api := newFakeAPI(t)
+	b := New("xoxb-token", "secret", "127.0.0.1:0", WithAPIBase(api.server.URL))
+	ref := domain.MessageRef{SessionKey: domain.SessionKey{Platform: domain.PlatformSlack, ChatID: "C1"}, ID: "1.2"}
+	if err := b.React(context.Background(), ref, "👀"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	if err := b.React(context.Background(), ref, "👍"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	want := []string{"reactions.add", "reactions.remove", "reactions.add"}
+	if strings.Join(api.calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, api.calls)
+	}
+	if api.bodies[1]["name"] != "eyes" || api.bodies[2]["name"] != "+1" {
+		t.Fatalf("unexpected reaction names: %v", api.bodies)
+	}
+}
+
+func TestCallRetriesRateLimitedRequests(t *testing.T) {
+	api := newFakeAPI(t)
+	calls := 0
+	api.server.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls == 1 {
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = io.WriteString(rw, `{"ok":false,"error":"channel_not_found"}`)
+	})
+	b := New("xoxb-token", "secret", "127.0.0.1:0", WithAPIBase(api.server.URL))
+	err := b.call(context.Background(), "chat.postMessage", map[string]any{}, nil)
+	if !isAPIError(err, "channel_not_found") {
+		t.Fatalf("expected channel_not_found after retry, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// WithAllowedUsers allows the given user IDs to use the bot in any chat,
+// in addition to the user passed to New.
+func WithAllowedUsers(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedUsers[id] = true
+		}
+	}
+}
+
+// WithAllowedChats allows every member of the given chats, usually groups,
+// to use the bot there.
+func WithAllowedChats(ids ...string) Option {
+	return func(b *Bot) {
+		for _, id := range ids {
+			b.allowedChats[id] = true
+		}
+	}
+}
+
+func (b *Bot) allowed(userID, chatID string) bool {
+	return b.allowedUsers[userID] || (chatID != "" && b.allowedChats[chatID])
+}
+
+func (b *Bot) getMe(ctx context.Context) error {
+	var me struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	}
+	if err := b.call(ctx, "getMe", map[string]any{}, &me); err != nil {
+		return err
+	}
+	b.id = strconv.FormatInt(me.ID, 10)
+	b.username = me.Username
+	if me.Username != "" {
+		b.mention = regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(me.Username) + `\b`)
+	}
+	return nil
+}
+
+// addressedText decides whether a message is meant for the bot and returns
+// its text with the bot's name removed. Private chats always address the
+// bot. In groups only commands, messages mentioning @username and replies
+// to the bot's own messages do; commands suffixed with another bot's name
+// are ignored. Messages in a forum topic the bot created reply to its
+// creation message, so project topics need no mentions.
+func (b *Bot) addressedText(u update, text string) (string, bool) {
+	switch u.Message.Chat.Type {
+	case "group", "supergroup":
+	default:
+		return text, true
+	}
+	if u.Message.ForumTopicCreated != nil || u.Message.ForumTopicEdited != nil {
+		// Topic service messages carry no text but let the session bind to
+		// a project by topic name.
+		return "", true
+	}
+	if strings.HasPrefix(text, "/") {
+		end := strings.IndexFunc(text, unicode.IsSpace)
+		if end < 0 {
+			end = len(text)
+		}
+		if name, target, ok := strings.Cut(text[:end], "@"); ok {
+			if b.username == "" || !strings.EqualFold(target, b.username) {
+				return "", false
+			}
+			text = name + text[end:]
+		}
+		return text, true
+	}
+	if b.mention != nil && b.mention.MatchString(text) {
+		return strings.TrimSpace(b.mention.ReplaceAllString(text, "")), true
+	}
+	if r := u.Message.ReplyToMessage; r != nil && b.id != "" && r.From.ID.String() == b.id {
+		return text, true
+	}
+	return "", false
+}
@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"chatcode/internal/domain"
+)
+
+func groupUpdate(t *testing.T, raw string) update {
+	t.Helper()
+	var u update
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return u
+}
+
+func TestHandleUpdateInGroupNeedsCommandMentionOrReply(t *testing.T) {
+	api := newFakeAPI(t)
+	api.reply = func(method string, body map[string]any) (int, string) {
+		return http.StatusOK, `{"ok":true,"result":{"id":42,"username":"CodeBot"}}`
+	}
+	b := New("token", "", WithAllowedChats("-100"), WithAllowedUsers("5"))
+	b.apiBase = api.server.URL
+	if err := b.getMe(context.Background()); err != nil {
+		t.Fatalf("getMe: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		raw  string
+		want string // empty means ignored
+	}{
+		{"plain chatter", `{"message":{"message_id":1,"text":"lunch?","chat":{"id":-100,"type":"supergroup"},"from":{"id":9}}}`, ""},
+		{"command", `{"message":{"message_id":2,"text":"/status","chat":{"id":-100,"type":"supergroup"},"from":{"id":9}}}`, "/status"},
+		{"command for bot", `{"message":{"message_id":3,"text":"/codex@codebot fix it","chat":{"id":-100,"type":"supergroup"},"from":{"id":9}}}`, "/codex fix it"},
+		{"command for other bot", `{"message":{"message_id":4,"text":"/status@otherbot","chat":{"id":-100,"type":"supergroup"},"from":{"id":9}}}`, ""},
+		{"mention", `{"message":{"message_id":5,"text":"@CodeBot run the tests","chat":{"id":-100,"type":"supergroup"},"from":{"id":9}}}`, "run the tests"},
+		{"reply to bot", `{"message":{"message_id":6,"text":"and lint too","chat":{"id":-100,"type":"supergroup"},"from":{"id":9},"reply_to_message":{"message_id":3,"from":{"id":42}}}}`, "and lint too"},
+		{"other group", `{"message":{"message_id":7,"text":"/status","chat":{"id":-200,"type":"group"},"from":{"id":9}}}`, ""},
+		{"allowed user elsewhere", `{"message":{"message_id":8,"text":"/status","chat":{"id":-200,"type":"group"},"from":{"id":5}}}`, "/status"},
+		{"private chat", `{"message":{"message_id":9,"text":"hello","chat":{"id":5,"type":"private"},"from":{"id":5}}}`, "hello"},
+	}
+	for _, tc := range cases {
+		var got []domain.Message
+		b.handleUpdate(context.Background(), groupUpdate(t, tc.raw), func(_ context.Context, m domain.Message) error {
+			got = append(got, m)
+			return nil
+		})
+		if tc.want == "" {
+			if len(got) != 0 {
+				t.Errorf("%s: expected message to be ignored, got %q", tc.name, got[0].Text)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].Text != tc.want {
+			t.Errorf("%s: expected %q, got %#v", tc.name, tc.want, got)
+			continue
+		}
+		if got[0].SenderID == "" {
+			t.Errorf("%s: expected sender attribution", tc.name)
+		}
+	}
+}
@@ -4,40 +4,139 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"chatcode/internal/domain"
+	"chatcode/internal/render"
 )
 
 type Bot struct {
-	token         string
-	allowedUserID string
-	httpClient    *http.Client
-	offset        int64
+	token        string
+	allowedUsers map[string]bool
+	allowedChats map[string]bool
+	// username and id are filled in from getMe on Start and used to tell
+	// which group messages are addressed to the bot.
+	username   string
+	id         string
+	mention    *regexp.Regexp
+	apiBase    string
+	httpClient *http.Client
+	// requestTimeout bounds each API call; getUpdates additionally waits up
+	// to pollTimeout for updates to arrive.
+	requestTimeout time.Duration
+	pollTimeout    time.Duration
+	offset         int64
+	webhook        *webhookConfig
+	state          StateStore
+	outbox         *outbox
+	// updates feeds received updates, polled or pushed to the webhook, to
+	// the goroutine that handles them in order.
+	updates chan update
+	// polled counts polled updates not handled yet. getUpdates confirms
+	// every update below its offset, so the next poll waits for them.
+	polled sync.WaitGroup
+	// topics caches forum topic names by "chat_id:thread_id".
+	topics sync.Map
 }
 
+// StateStore persists transport state across restarts.
+type StateStore interface {
+	TransportState(ctx context.Context, platform domain.Platform, key string) (string, error)
+	SetTransportState(ctx context.Context, platform domain.Platform, key, value string) error
+}
+
+const offsetStateKey = "update_offset"
+
 type botCommand struct {
 	Command     string `json:"command"`
 	Description string `json:"description"`
 }
 
-func New(token string, allowedUserID string) *Bot {
-	return &Bot{token: token, allowedUserID: allowedUserID, httpClient: &http.Client{Timeout: 20 * time.Second}}
+// Option configures optional Bot behavior.
+type Option func(*Bot)
+
+// WithStateStore persists the getUpdates offset so updates handled before a
+// restart are not delivered again.
+func WithStateStore(st StateStore) Option {
+	return func(b *Bot) {
+		b.state = st
+	}
+}
+
+func New(token string, allowedUserID string, opts ...Option) *Bot {
+	b := &Bot{
+		token:          token,
+		allowedUsers:   make(map[string]bool),
+		allowedChats:   make(map[string]bool),
+		apiBase:        defaultAPIBase,
+		httpClient:     &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		requestTimeout: defaultRequestTimeout,
+		pollTimeout:    defaultPollTimeout,
+		outbox:         newOutbox(),
+		updates:        make(chan update, updateQueueSize),
+	}
+	if allowedUserID != "" {
+		b.allowedUsers[allowedUserID] = true
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 func (b *Bot) Name() string { return "telegram" }
 
 func (b *Bot) Start(ctx context.Context, handler domain.MessageHandler) error {
 	slog.Info("transport started", "transport", "telegram")
+	if err := b.getMe(ctx); err != nil {
+		slog.Error("telegram getMe failed, group messages need a command", "error", err)
+	}
 	if err := b.setMyCommands(ctx); err != nil {
 		slog.Error("telegram setMyCommands failed", "error", err)
 	} else {
 		slog.Info("telegram commands registered")
 	}
+	go b.processUpdates(ctx, handler)
+	if b.webhook != nil {
+		return b.serveWebhook(ctx)
+	}
+	return b.poll(ctx)
+}
+
+// processUpdates handles queued updates one at a time until ctx is done.
+func (b *Bot) processUpdates(ctx context.Context, handler domain.MessageHandler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-b.updates:
+			b.handleUpdate(ctx, u, handler)
+			if b.webhook == nil {
+				// The update is handled even if ctx ended meanwhile; record
+				// it so a restart resumes after it.
+				b.saveOffset(context.WithoutCancel(ctx), u.UpdateID+1)
+				b.polled.Done()
+			}
+		}
+	}
+}
+
+func (b *Bot) poll(ctx context.Context) error {
+	// getUpdates is refused while a webhook is registered, e.g. after
+	// switching back from webhook mode.
+	if err := b.call(ctx, "deleteWebhook", map[string]any{}, nil); err != nil {
+		slog.Error("telegram deleteWebhook failed", "error", err)
+	}
+	b.restoreOffset(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -53,20 +152,118 @@ func (b *Bot) Start(ctx context.Context, handler domain.MessageHandler) error {
 		for _, u := range updates {
 			// Always advance offset for every received update, including filtered ones.
 			b.offset = u.UpdateID + 1
-			if u.Message.From.ID.String() != b.allowedUserID {
-				continue
+			b.polled.Add(1)
+			select {
+			case b.updates <- u:
+			case <-ctx.Done():
+				b.polled.Done()
+				return ctx.Err()
 			}
-			msg := toDomainMessage(u)
-			slog.Info("telegram inbound message",
-				"chat_id", msg.SessionKey.ChatID,
-				"thread_id", msg.SessionKey.ThreadID,
-				"sender_id", msg.SenderID,
-			)
-			_ = handler(ctx, msg)
+		}
+		if err := b.waitPolled(ctx); err != nil {
+			return err
 		}
 	}
 }
 
+// waitPolled blocks until every polled update has been handled, so the
+// next getUpdates does not confirm updates that were not.
+func (b *Bot) waitPolled(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.polled.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Bot) restoreOffset(ctx context.Context) {
+	if b.state == nil {
+		return
+	}
+	v, err := b.state.TransportState(ctx, domain.PlatformTelegram, offsetStateKey)
+	if err != nil {
+		slog.Error("telegram restore update offset failed", "error", err)
+		return
+	}
+	if v == "" {
+		return
+	}
+	offset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		slog.Error("telegram stored update offset is invalid", "value", v)
+		return
+	}
+	b.offset = offset
+	slog.Info("telegram update offset restored", "offset", offset)
+}
+
+// saveOffset records offset, the ID after the last handled update.
+func (b *Bot) saveOffset(ctx context.Context, offset int64) {
+	if b.state == nil {
+		return
+	}
+	if err := b.state.SetTransportState(ctx, domain.PlatformTelegram, offsetStateKey, strconv.FormatInt(offset, 10)); err != nil {
+		slog.Error("telegram save update offset failed", "error", err)
+	}
+}
+
+func (b *Bot) handleUpdate(ctx context.Context, u update, handler domain.MessageHandler) {
+	if u.CallbackQuery != nil {
+		b.handleCallbackQuery(ctx, u.CallbackQuery, handler)
+		return
+	}
+	if u.EditedMessage != nil {
+		u.Message = *u.EditedMessage
+	}
+	if !b.allowed(u.Message.From.ID.String(), u.Message.Chat.ID.String()) {
+		return
+	}
+	msg := toDomainMessage(u)
+	msg.Meta.TopicName = b.topicName(u)
+	text, ok := b.addressedText(u, msg.Text)
+	if !ok {
+		return
+	}
+	msg.Text = text
+	slog.Info("telegram inbound message",
+		"chat_id", msg.SessionKey.ChatID,
+		"thread_id", msg.SessionKey.ThreadID,
+		"sender_id", msg.SenderID,
+		"edited", msg.Meta.EditID != "",
+	)
+	_ = handler(ctx, msg)
+}
+
+func (b *Bot) handleCallbackQuery(ctx context.Context, q *callbackQuery, handler domain.MessageHandler) {
+	chatID := ""
+	if q.Message != nil {
+		chatID = q.Message.Chat.ID.String()
+	}
+	if !b.allowed(q.From.ID.String(), chatID) {
+		return
+	}
+	msg, ok := callbackToDomainMessage(q)
+	if ok {
+		slog.Info("telegram inbound action",
+			"chat_id", msg.SessionKey.ChatID,
+			"thread_id", msg.SessionKey.ThreadID,
+			"sender_id", msg.SenderID,
+			"action", msg.Action.Name,
+		)
+		_ = handler(ctx, msg)
+	}
+	// Answer even unknown callbacks so the client stops showing a spinner.
+	if err := b.call(ctx, "answerCallbackQuery", map[string]any{"callback_query_id": q.ID}, nil); err != nil {
+		slog.Error("telegram answerCallbackQuery failed", "error", err)
+	}
+}
+
 func (b *Bot) setMyCommands(ctx context.Context) error {
 	commands := []botCommand{
 		{Command: "new", Description: "Create and switch workdir: /new <project_dir>"},
@@ -85,43 +282,128 @@ func (b *Bot) setMyCommands(ctx context.Context) error {
 			"type": "default",
 		},
 	}
-	body, _ := json.Marshal(payload)
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/setMyCommands", b.token)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := b.httpClient.Do(req)
-	if err != nil {
-		return err
+	return b.call(ctx, "setMyCommands", payload, nil)
+}
+
+func (b *Bot) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	payload := buildSendPayload(msg)
+	if msg.Format == "html" {
+		payload["parse_mode"] = "HTML"
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("telegram setMyCommands status=%d", resp.StatusCode)
+	var sent struct {
+		MessageID int64 `json:"message_id"`
 	}
-	return nil
+	err := b.outbox.submit(ctx, msg.SessionKey.ChatID, func(ctx context.Context) error {
+		return b.callFormatted(ctx, "sendMessage", payload, &sent)
+	})
+	if err != nil {
+		return domain.MessageRef{}, err
+	}
+	return domain.MessageRef{SessionKey: msg.SessionKey, ID: strconv.FormatInt(sent.MessageID, 10)}, nil
 }
 
-func (b *Bot) Send(ctx context.Context, msg domain.OutboundMessage) error {
-	payload := buildSendPayload(msg)
+// Edit replaces the text of a message previously returned by Send.
+func (b *Bot) Edit(ctx context.Context, ref domain.MessageRef, msg domain.OutboundMessage) error {
+	messageID, err := strconv.ParseInt(ref.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("telegram edit: invalid message id %q", ref.ID)
+	}
+	payload := map[string]any{
+		"chat_id":    ref.SessionKey.ChatID,
+		"message_id": messageID,
+		"text":       msg.Text,
+	}
 	if msg.Format == "html" {
 		payload["parse_mode"] = "HTML"
 	}
-	body, _ := json.Marshal(payload)
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", b.token)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := b.httpClient.Do(req)
+	return b.outbox.submit(ctx, ref.SessionKey.ChatID, func(ctx context.Context) error {
+		return b.callFormatted(ctx, "editMessageText", payload, nil)
+	})
+}
+
+// React sets the bot's reaction on a message, replacing any earlier one.
+// Telegram only accepts emojis from a fixed list.
+func (b *Bot) React(ctx context.Context, ref domain.MessageRef, emoji string) error {
+	messageID, err := strconv.ParseInt(ref.ID, 10, 64)
 	if err != nil {
+		return fmt.Errorf("telegram react: invalid message id %q", ref.ID)
+	}
+	reaction := []map[string]string{}
+	if emoji != "" {
+		reaction = append(reaction, map[string]string{"type": "emoji", "emoji": emoji})
+	}
+	payload := map[string]any{
+		"chat_id":    ref.SessionKey.ChatID,
+		"message_id": messageID,
+		"reaction":   reaction,
+	}
+	return b.outbox.submit(ctx, ref.SessionKey.ChatID, func(ctx context.Context) error {
+		return b.call(ctx, "setMessageReaction", payload, nil)
+	})
+}
+
+// callFormatted calls a method that carries message text. When Telegram
+// rejects the HTML markup, the text is sent again as plain text with tags
+// stripped and entities unescaped so the output is not lost.
+func (b *Bot) callFormatted(ctx context.Context, method string, payload map[string]any, result any) error {
+	err := b.call(ctx, method, payload, result)
+	if payload["parse_mode"] != "HTML" || !isEntityParseError(err) {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("telegram send status=%d", resp.StatusCode)
+	slog.Warn("telegram rejected html, resending as plain text", "method", method, "error", err)
+	plain := make(map[string]any, len(payload))
+	for k, v := range payload {
+		plain[k] = v
 	}
-	return nil
+	delete(plain, "parse_mode")
+	if text, ok := payload["text"].(string); ok {
+		plain["text"] = render.PlainText(text)
+	}
+	return b.call(ctx, method, plain, result)
+}
+
+func isEntityParseError(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(apiErr.Description), "parse entities")
+}
+
+// FetchAttachment downloads an inbound file. getFile resolves the file ID to
+// a path that is valid for at least an hour.
+func (b *Bot) FetchAttachment(ctx context.Context, att domain.Attachment) (io.ReadCloser, error) {
+	var file struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := b.call(ctx, "getFile", map[string]any{"file_id": att.FileID}, &file); err != nil {
+		return nil, err
+	}
+	if file.FilePath == "" {
+		return nil, fmt.Errorf("telegram getFile: no file path for %s", att.FileID)
+	}
+	// The deadline covers reading the body too, so it is released on Close.
+	ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/file/bot%s/%s", b.apiBase, b.token, file.FilePath), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("telegram file download: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("telegram file download: status %d", resp.StatusCode)
+	}
+	return &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}, nil
 }
 
 func (b *Bot) getUpdates(ctx context.Context) ([]update, error) {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?timeout=20&offset=%s", b.token, strconv.FormatInt(b.offset, 10))
+	ctx, cancel := context.WithTimeout(ctx, b.pollTimeout+b.requestTimeout)
+	defer cancel()
+	url := fmt.Sprintf("%s/bot%s/getUpdates?timeout=%d&offset=%s", b.apiBase, b.token, int(b.pollTimeout.Seconds()), strconv.FormatInt(b.offset, 10))
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	resp, err := b.httpClient.Do(req)
 	if err != nil {
@@ -141,18 +423,123 @@ func (b *Bot) getUpdates(ctx context.Context) ([]update, error) {
 	return envelope.Result, nil
 }
 
+// call invokes a Bot API method with a JSON payload and decodes the result
+// field of the response into result when it is non-nil.
+func (b *Bot) call(ctx context.Context, method string, payload any, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	defer cancel()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("telegram %s: encode payload: %w", method, err)
+	}
+	url := fmt.Sprintf("%s/bot%s/%s", b.apiBase, b.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return b.do(req, method, result)
+}
+
+// do sends a Bot API request and decodes the response envelope into result.
+func (b *Bot) do(req *http.Request, method string, result any) error {
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var envelope struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&envelope)
+	if resp.StatusCode >= 300 || (decodeErr == nil && !envelope.OK) {
+		return &apiError{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			Description: envelope.Description,
+			RetryAfter:  time.Duration(envelope.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("telegram %s: decode response: %w", method, decodeErr)
+	}
+	if result != nil && len(envelope.Result) > 0 {
+		if err := json.Unmarshal(envelope.Result, result); err != nil {
+			return fmt.Errorf("telegram %s: decode result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+type apiError struct {
+	Method      string
+	StatusCode  int
+	Description string
+	// RetryAfter is set on 429 responses: the time to wait before the
+	// request may be repeated.
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("telegram %s status=%d", e.Method, e.StatusCode)
+	}
+	return fmt.Sprintf("telegram %s status=%d: %s", e.Method, e.StatusCode, e.Description)
+}
+
 type update struct {
-	UpdateID int64 `json:"update_id"`
-	Message  struct {
-		ID              int64  `json:"message_id"`
-		Text            string `json:"text"`
-		MessageThreadID int64  `json:"message_thread_id"`
-		Chat            struct {
-			ID telegramID `json:"id"`
-		} `json:"chat"`
+	UpdateID      int64          `json:"update_id"`
+	CallbackQuery *callbackQuery `json:"callback_query"`
+	Message       message        `json:"message"`
+	EditedMessage *message       `json:"edited_message"`
+}
+
+type message struct {
+	ID              int64             `json:"message_id"`
+	Text            string            `json:"text"`
+	Caption         string            `json:"caption"`
+	Document        *telegramDocument `json:"document"`
+	Photo           []photoSize       `json:"photo"`
+	MessageThreadID int64             `json:"message_thread_id"`
+	IsTopicMessage  bool              `json:"is_topic_message"`
+	Chat            struct {
+		ID      telegramID `json:"id"`
+		Type    string     `json:"type"`
+		IsForum bool       `json:"is_forum"`
+	} `json:"chat"`
+	From struct {
+		ID telegramID `json:"id"`
+	} `json:"from"`
+	ReplyToMessage *struct {
+		ID   int64 `json:"message_id"`
 		From struct {
 			ID telegramID `json:"id"`
 		} `json:"from"`
+		ForumTopicCreated *forumTopic `json:"forum_topic_created"`
+	} `json:"reply_to_message"`
+	ForumTopicCreated *forumTopic `json:"forum_topic_created"`
+	ForumTopicEdited  *forumTopic `json:"forum_topic_edited"`
+	// EditDate is only set on edited messages.
+	EditDate int64 `json:"edit_date"`
+}
+
+type callbackQuery struct {
+	ID   string `json:"id"`
+	Data string `json:"data"`
+	From struct {
+		ID telegramID `json:"id"`
+	} `json:"from"`
+	Message *struct {
+		ID              int64 `json:"message_id"`
+		MessageThreadID int64 `json:"message_thread_id"`
+		Chat            struct {
+			ID telegramID `json:"id"`
+		} `json:"chat"`
 	} `json:"message"`
 }
 
@@ -191,18 +578,116 @@ func toDomainMessage(u update) domain.Message {
 	if u.Message.MessageThreadID != 0 {
 		key.ThreadID = strconv.FormatInt(u.Message.MessageThreadID, 10)
 	}
+	text := u.Message.Text
+	if text == "" {
+		text = u.Message.Caption
+	}
+	var quoted, editID string
+	if u.Message.EditDate != 0 {
+		editID = strconv.FormatInt(u.Message.EditDate, 10)
+	}
+	// Inside forum topics Telegram reports the topic's creation message as
+	// the reply target of every message that quotes nothing else.
+	if r := u.Message.ReplyToMessage; r != nil && r.ForumTopicCreated == nil {
+		quoted = strconv.FormatInt(r.ID, 10)
+	}
 	return domain.Message{
-		SessionKey: key,
-		SenderID:   u.Message.From.ID.String(),
-		Text:       u.Message.Text,
+		SessionKey:  key,
+		SenderID:    u.Message.From.ID.String(),
+		Text:        text,
+		Attachments: messageAttachments(u),
 		Meta: domain.InboundMessageMeta{
+			MessageID:        strconv.FormatInt(u.Message.ID, 10),
 			ReplyToMessageID: strconv.FormatInt(u.Message.ID, 10),
+			QuotedMessageID:  quoted,
+			EditID:           editID,
+			Forum:            u.Message.Chat.IsForum,
 			Raw:              map[string]string{"telegram_message_id": strconv.FormatInt(u.Message.ID, 10)},
 		},
 		At: time.Now().UTC(),
 	}
 }
 
+type telegramDocument struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name"`
+	MIMEType string `json:"mime_type"`
+	FileSize int64  `json:"file_size"`
+}
+
+type photoSize struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileSize     int64  `json:"file_size"`
+}
+
+// messageAttachments maps a document or photo to attachments. Telegram sends
+// every photo in several sizes, smallest first; only the largest is kept.
+func messageAttachments(u update) []domain.Attachment {
+	var out []domain.Attachment
+	if d := u.Message.Document; d != nil {
+		out = append(out, domain.Attachment{
+			Kind:     domain.AttachmentDocument,
+			FileID:   d.FileID,
+			FileName: d.FileName,
+			MIMEType: d.MIMEType,
+			Size:     d.FileSize,
+		})
+	}
+	if n := len(u.Message.Photo); n > 0 {
+		p := u.Message.Photo[n-1]
+		out = append(out, domain.Attachment{
+			Kind:     domain.AttachmentPhoto,
+			FileID:   p.FileID,
+			FileName: "photo-" + p.FileUniqueID + ".jpg",
+			MIMEType: "image/jpeg",
+			Size:     p.FileSize,
+		})
+	}
+	return out
+}
+
+func callbackToDomainMessage(q *callbackQuery) (domain.Message, bool) {
+	if q.Message == nil {
+		return domain.Message{}, false
+	}
+	name, data, ok := strings.Cut(q.Data, ":")
+	if !ok || name == "" {
+		return domain.Message{}, false
+	}
+	key := domain.SessionKey{
+		Platform: domain.PlatformTelegram,
+		ChatID:   q.Message.Chat.ID.String(),
+	}
+	if q.Message.MessageThreadID != 0 {
+		key.ThreadID = strconv.FormatInt(q.Message.MessageThreadID, 10)
+	}
+	return domain.Message{
+		SessionKey: key,
+		SenderID:   q.From.ID.String(),
+		Action:     &domain.Action{Name: name, Data: data},
+		Meta: domain.InboundMessageMeta{
+			ReplyToMessageID: strconv.FormatInt(q.Message.ID, 10),
+			Raw:              map[string]string{"telegram_callback_query_id": q.ID},
+		},
+		At: time.Now().UTC(),
+	}, true
+}
+
+// inlineKeyboard renders actions as a single row of callback buttons. The
+// callback data is "<name>:<data>", which must stay within Telegram's 64-byte
+// limit.
+func inlineKeyboard(actions []domain.Action) map[string]any {
+	row := make([]map[string]string, 0, len(actions))
+	for _, a := range actions {
+		row = append(row, map[string]string{
+			"text":          a.Label,
+			"callback_data": a.Name + ":" + a.Data,
+		})
+	}
+	return map[string]any{"inline_keyboard": [][]map[string]string{row}}
+}
+
 func buildSendPayload(msg domain.OutboundMessage) map[string]any {
 	payload := map[string]any{
 		"chat_id": msg.SessionKey.ChatID,
@@ -213,5 +698,17 @@ func buildSendPayload(msg domain.OutboundMessage) map[string]any {
 			payload["message_thread_id"] = threadID
 		}
 	}
+	if msg.ReplyToMessageID != "" {
+		if messageID, err := strconv.ParseInt(msg.ReplyToMessageID, 10, 64); err == nil {
+			// The reply is still delivered if the user deleted the message.
+			payload["reply_parameters"] = map[string]any{
+				"message_id":                  messageID,
+				"allow_sending_without_reply": true,
+			}
+		}
+	}
+	if len(msg.Actions) > 0 {
+		payload["reply_markup"] = inlineKeyboard(msg.Actions)
+	}
 	return payload
 }
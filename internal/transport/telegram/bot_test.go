@@ -1,7 +1,14 @@
 package telegram
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"chatcode/internal/domain"
 )
@@ -44,3 +51,340 @@ func TestBuildSendPayload_WithThreadID(t *testing.T) {
 		t.Fatalf("expected thread 456, got %d", gotThread)
 	}
 }
+
+func TestBuildSendPayload_WithActions(t *testing.T) {
+	msg := domain.OutboundMessage{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "999"},
+		Text:       "job queued: abc",
+		Actions: []domain.Action{
+			{Name: domain.ActionStopJob, Label: "Stop", Data: "abc"},
+			{Name: domain.ActionShowLog, Label: "Show log", Data: "abc"},
+		},
+	}
+	payload := buildSendPayload(msg)
+	markup, ok := payload["reply_markup"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected reply_markup in payload")
+	}
+	rows := markup["inline_keyboard"].([][]map[string]string)
+	if len(rows) != 1 || len(rows[0]) != 2 {
+		t.Fatalf("unexpected keyboard: %#v", rows)
+	}
+	if rows[0][0]["text"] != "Stop" || rows[0][0]["callback_data"] != "stop:abc" {
+		t.Fatalf("unexpected first button: %#v", rows[0][0])
+	}
+}
+
+func TestBuildSendPayload_WithReply(t *testing.T) {
+	msg := domain.OutboundMessage{
+		SessionKey:       domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "999"},
+		Text:             "job done: abc",
+		ReplyToMessageID: "42",
+	}
+	payload := buildSendPayload(msg)
+	reply, ok := payload["reply_parameters"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected reply_parameters in payload")
+	}
+	if reply["message_id"] != int64(42) || reply["allow_sending_without_reply"] != true {
+		t.Fatalf("unexpected reply parameters: %#v", reply)
+	}
+}
+
+func TestToDomainMessageQuotedMessage(t *testing.T) {
+	var u update
+	raw := `{"message":{"message_id":7,"text":"and the tests?","chat":{"id":1,"type":"private"},"from":{"id":1},"reply_to_message":{"message_id":5,"from":{"id":42}}}}`
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := toDomainMessage(u).Meta.QuotedMessageID; got != "5" {
+		t.Fatalf("expected quoted message 5, got %q", got)
+	}
+
+	// Plain messages in a forum topic point at the topic's creation message.
+	raw = `{"message":{"message_id":9,"message_thread_id":3,"text":"hi","chat":{"id":-100,"type":"supergroup"},"from":{"id":1},"reply_to_message":{"message_id":3,"forum_topic_created":{"name":"api"}}}}`
+	u = update{}
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got := toDomainMessage(u).Meta.QuotedMessageID; got != "" {
+		t.Fatalf("expected no quoted message in topic, got %q", got)
+	}
+}
+
+func TestCallbackToDomainMessage(t *testing.T) {
+	q := &callbackQuery{ID: "cb1", Data: "retry:abc"}
+	q.From.ID = telegramID("777")
+	q.Message = &struct {
+		ID              int64 `json:"message_id"`
+		MessageThreadID int64 `json:"message_thread_id"`
+		Chat            struct {
+			ID telegramID `json:"id"`
+		} `json:"chat"`
+	}{ID: 5, MessageThreadID: 456}
+	q.Message.Chat.ID = telegramID("999")
+
+	msg, ok := callbackToDomainMessage(q)
+	if !ok {
+		t.Fatalf("expected callback to convert")
+	}
+	if msg.Action == nil || msg.Action.Name != domain.ActionRetryJob || msg.Action.Data != "abc" {
+		t.Fatalf("unexpected action: %#v", msg.Action)
+	}
+	if msg.SessionKey.ChatID != "999" || msg.SessionKey.ThreadID != "456" || msg.SenderID != "777" {
+		t.Fatalf("unexpected message routing: %#v", msg)
+	}
+
+	q.Data = "garbage"
+	if _, ok := callbackToDomainMessage(q); ok {
+		t.Fatalf("expected malformed callback data to be rejected")
+	}
+}
+
+type memoryState struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (m *memoryState) TransportState(_ context.Context, platform domain.Platform, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[string(platform)+"/"+key], nil
+}
+
+func (m *memoryState) SetTransportState(_ context.Context, platform domain.Platform, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[string(platform)+"/"+key] = value
+	return nil
+}
+
+func TestPollRestoresAndPersistsOffset(t *testing.T) {
+	api := newFakeAPI(t)
+	delivered := false
+	api.reply = func(method string, _ map[string]any) (int, string) {
+		if method != "getUpdates" {
+			return http.StatusOK, `{"ok":true,"result":true}`
+		}
+		if delivered {
+			return http.StatusOK, `{"ok":true,"result":[]}`
+		}
+		delivered = true
+		return http.StatusOK, `{"ok":true,"result":[{"update_id":41,"message":{"message_id":9,"text":"hi","chat":{"id":999},"from":{"id":777}}}]}`
+	}
+	state := &memoryState{values: map[string]string{"telegram/update_offset": "41"}}
+	b := New("token", "777", WithStateStore(state))
+	b.apiBase = api.server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(chan domain.Message, 1)
+	go func() {
+		_ = b.Start(ctx, func(_ context.Context, msg domain.Message) error {
+			handled <- msg
+			return nil
+		})
+	}()
+	select {
+	case msg := <-handled:
+		if msg.Meta.MessageID != "9" {
+			t.Fatalf("expected message id 9, got %q", msg.Meta.MessageID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("update was not handled")
+	}
+	cancel()
+
+	api.mu.Lock()
+	firstQuery := ""
+	for i, c := range api.calls {
+		if c == "getUpdates" {
+			firstQuery = api.queries[i]
+			break
+		}
+	}
+	api.mu.Unlock()
+	if !strings.Contains(firstQuery, "offset=41") {
+		t.Fatalf("expected first poll to use restored offset, got %q", firstQuery)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := state.TransportState(context.Background(), domain.PlatformTelegram, offsetStateKey); v == "42" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected offset 42 to be persisted")
+}
+
+func TestPollSavesOffsetOnlyOnceUpdateIsHandled(t *testing.T) {
+	api := newFakeAPI(t)
+	var polls int
+	api.reply = func(method string, _ map[string]any) (int, string) {
+		if method != "getUpdates" {
+			return http.StatusOK, `{"ok":true,"result":true}`
+		}
+		api.mu.Lock()
+		polls++
+		first := polls == 1
+		api.mu.Unlock()
+		if !first {
+			return http.StatusOK, `{"ok":true,"result":[]}`
+		}
+		return http.StatusOK, `{"ok":true,"result":[{"update_id":7,"message":{"message_id":9,"text":"hi","chat":{"id":999},"from":{"id":777}}}]}`
+	}
+	state := &memoryState{values: map[string]string{}}
+	b := New("token", "777", WithStateStore(state))
+	b.apiBase = api.server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_ = b.Start(ctx, func(context.Context, domain.Message) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("update was not handled")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := state.TransportState(ctx, domain.PlatformTelegram, offsetStateKey); v != "" {
+		t.Fatalf("expected no offset while the update is being handled, got %q", v)
+	}
+	api.mu.Lock()
+	if polls != 1 {
+		t.Fatalf("expected no further poll while the update is being handled, got %d polls", polls)
+	}
+	api.mu.Unlock()
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := state.TransportState(ctx, domain.PlatformTelegram, offsetStateKey); v == "8" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected offset 8 to be persisted after handling")
+}
+
+func TestSendFallsBackToPlainTextWhenHTMLRejected(t *testing.T) {
+	api := newFakeAPI(t)
+	api.reply = func(method string, body map[string]any) (int, string) {
+		if body["parse_mode"] == "HTML" {
+			return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities: unclosed tag"}`
+		}
+		return http.StatusOK, `{"ok":true,"result":{"message_id":9}}`
+	}
+	b := New("token", "777")
+	b.apiBase = api.server.URL
+
+	ref, err := b.Send(context.Background(), domain.OutboundMessage{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "42"},
+		Text:       "<b>a &lt; b",
+		Format:     "html",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if ref.ID != "9" {
+		t.Fatalf("unexpected ref: %+v", ref)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.bodies) != 2 {
+		t.Fatalf("expected html attempt and plain retry, got %d calls", len(api.bodies))
+	}
+	retry := api.bodies[1]
+	if _, ok := retry["parse_mode"]; ok || retry["text"] != "a < b" {
+		t.Fatalf("unexpected plain retry: %#v", retry)
+	}
+}
+
+func TestToDomainMessageWithPhotoAndCaption(t *testing.T) {
+	var u update
+	raw := `{"message":{"message_id":5,"caption":"fix this","chat":{"id":1},"from":{"id":777},
+		"photo":[{"file_id":"small","file_unique_id":"s"},{"file_id":"large","file_unique_id":"l","file_size":2048}]}}`
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	msg := toDomainMessage(u)
+	if msg.Text != "fix this" {
+		t.Fatalf("expected caption as text, got %q", msg.Text)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("expected one attachment, got %#v", msg.Attachments)
+	}
+	att := msg.Attachments[0]
+	if att.Kind != domain.AttachmentPhoto || att.FileID != "large" || att.FileName != "photo-l.jpg" || att.Size != 2048 {
+		t.Fatalf("unexpected attachment: %#v", att)
+	}
+}
+
+func TestFetchAttachmentDownloadsFile(t *testing.T) {
+	api := newFakeAPI(t)
+	api.reply = func(method string, body map[string]any) (int, string) {
+		switch method {
+		case "getFile":
+			return http.StatusOK, `{"ok":true,"result":{"file_id":"f1","file_path":"documents/file_3.log"}}`
+		case "file_3.log":
+			return http.StatusOK, "log contents"
+		}
+		return http.StatusNotFound, `{"ok":false}`
+	}
+	b := New("token", "777")
+	b.apiBase = api.server.URL
+
+	rc, err := b.FetchAttachment(context.Background(), domain.Attachment{FileID: "f1"})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if string(data) != "log contents" {
+		t.Fatalf("unexpected content: %q", data)
+	}
+}
+
+func TestHandleUpdateDeliversEditedMessage(t *testing.T) {
+	b := New("token", "5")
+	var got []domain.Message
+	raw := `{"update_id":3,"edited_message":{"message_id":7,"edit_date":1700000000,"text":"fixed prompt","chat":{"id":5,"type":"private"},"from":{"id":5}}}`
+	var u update
+	if err := json.Unmarshal([]byte(raw), &u); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	b.handleUpdate(context.Background(), u, func(_ context.Context, m domain.Message) error {
+		got = append(got, m)
+		return nil
+	})
+	if len(got) != 1 {
+		t.Fatalf("expected edited message to be delivered, got %d", len(got))
+	}
+	if got[0].Text != "fixed prompt" || got[0].Meta.MessageID != "7" || got[0].Meta.EditID != "1700000000" {
+		t.Fatalf("unexpected message: %+v", got[0])
+	}
+}
+
+func TestReactSetsEmojiReaction(t *testing.T) {
+	api := newFakeAPI(t)
+	b := New("token", "5", WithAPIBase(api.server.URL))
+	ref := domain.MessageRef{SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "5"}, ID: "12"}
+	if err := b.React(context.Background(), ref, "👍"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	if len(api.calls) != 1 || api.calls[0] != "setMessageReaction" {
+		t.Fatalf("expected setMessageReaction, got %v", api.calls)
+	}
+	body := api.bodies[0]
+	reaction, _ := body["reaction"].([]any)
+	if body["message_id"] != float64(12) || len(reaction) != 1 || reaction[0].(map[string]any)["emoji"] != "👍" {
+		t.Fatalf("unexpected payload: %#v", body)
+	}
+}
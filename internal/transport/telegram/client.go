@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAPIBase        = "https://api.telegram.org"
+	defaultRequestTimeout = 30 * time.Second
+	defaultPollTimeout    = 20 * time.Second
+)
+
+// WithAPIBase points the bot at another Bot API server, such as a
+// self-hosted telegram-bot-api instance or a local fake in tests.
+func WithAPIBase(base string) Option {
+	return func(b *Bot) {
+		if base != "" {
+			b.apiBase = strings.TrimRight(base, "/")
+		}
+	}
+}
+
+// WithProxy routes all Bot API traffic through proxy (http, https or
+// socks5).
+func WithProxy(proxy *url.URL) Option {
+	return func(b *Bot) {
+		if proxy == nil {
+			return
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(proxy)
+		b.httpClient = &http.Client{Transport: t}
+	}
+}
+
+// WithTimeouts sets the deadline for a single API call and how long
+// getUpdates long polls. Zero values keep the defaults.
+func WithTimeouts(request, poll time.Duration) Option {
+	return func(b *Bot) {
+		if request > 0 {
+			b.requestTimeout = request
+		}
+		if poll > 0 {
+			b.pollTimeout = poll
+		}
+	}
+}
+
+// cancelOnClose releases a request context once its body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
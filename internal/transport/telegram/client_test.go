@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// TestBotEndToEndAgainstFakeServer drives a polling bot through a local Bot
+// API stand-in: an update is received, handled and answered.
+func TestBotEndToEndAgainstFakeServer(t *testing.T) {
+	api := newFakeAPI(t)
+	var once sync.Once
+	api.reply = func(method string, body map[string]any) (int, string) {
+		switch method {
+		case "getUpdates":
+			resp := `{"ok":true,"result":[]}`
+			once.Do(func() {
+				resp = `{"ok":true,"result":[{"update_id":10,"message":{"message_id":3,"text":"ping","chat":{"id":777,"type":"private"},"from":{"id":777}}}]}`
+			})
+			return http.StatusOK, resp
+		case "sendMessage":
+			return http.StatusOK, `{"ok":true,"result":{"message_id":4}}`
+		case "getMe":
+			return http.StatusOK, `{"ok":true,"result":{"id":1,"username":"testbot"}}`
+		}
+		return http.StatusOK, `{"ok":true,"result":true}`
+	}
+	b := New("token", "777", WithAPIBase(api.server.URL+"/"), WithTimeouts(time.Second, time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replied := make(chan domain.MessageRef, 1)
+	go func() {
+		_ = b.Start(ctx, func(ctx context.Context, msg domain.Message) error {
+			ref, err := b.Send(ctx, domain.OutboundMessage{SessionKey: msg.SessionKey, Text: "pong: " + msg.Text})
+			if err != nil {
+				t.Errorf("send: %v", err)
+			}
+			replied <- ref
+			return nil
+		})
+	}()
+
+	select {
+	case ref := <-replied:
+		if ref.ID != "4" {
+			t.Fatalf("unexpected ref: %+v", ref)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no reply sent")
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i, c := range api.calls {
+		if c == "sendMessage" {
+			if api.bodies[i]["text"] != "pong: ping" {
+				t.Fatalf("unexpected reply body: %#v", api.bodies[i])
+			}
+		}
+		if c == "getUpdates" && !strings.Contains(api.queries[i], "timeout=1") {
+			t.Fatalf("expected configured poll timeout, got %q", api.queries[i])
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"chatcode/internal/domain"
+)
+
+// Telegram truncates longer captions.
+const maxCaptionRunes = 1024
+
+// SendFile uploads f as a document with sendDocument.
+func (b *Bot) SendFile(ctx context.Context, f domain.OutboundFile) (domain.MessageRef, error) {
+	fields := map[string]string{"chat_id": f.SessionKey.ChatID}
+	if f.SessionKey.ThreadID != "" {
+		fields["message_thread_id"] = f.SessionKey.ThreadID
+	}
+	if f.Caption != "" {
+		caption := []rune(f.Caption)
+		if len(caption) > maxCaptionRunes {
+			caption = append(caption[:maxCaptionRunes-1], '…')
+		}
+		fields["caption"] = string(caption)
+	}
+	if len(f.Actions) > 0 {
+		markup, err := json.Marshal(inlineKeyboard(f.Actions))
+		if err != nil {
+			return domain.MessageRef{}, fmt.Errorf("telegram sendDocument: encode keyboard: %w", err)
+		}
+		fields["reply_markup"] = string(markup)
+	}
+	var sent struct {
+		MessageID int64 `json:"message_id"`
+	}
+	err := b.outbox.submit(ctx, f.SessionKey.ChatID, func(ctx context.Context) error {
+		return b.upload(ctx, "sendDocument", fields, "document", f.Name, f.Content, &sent)
+	})
+	if err != nil {
+		return domain.MessageRef{}, err
+	}
+	return domain.MessageRef{SessionKey: f.SessionKey, ID: strconv.FormatInt(sent.MessageID, 10)}, nil
+}
+
+// upload calls method with a multipart body carrying fields and one file.
+func (b *Bot) upload(ctx context.Context, method string, fields map[string]string, fileField, fileName string, content []byte, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	defer cancel()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return fmt.Errorf("telegram %s: encode payload: %w", method, err)
+		}
+	}
+	part, err := w.CreateFormFile(fileField, fileName)
+	if err != nil {
+		return fmt.Errorf("telegram %s: encode payload: %w", method, err)
+	}
+	if _, err := part.Write(content); err != nil {
+		return fmt.Errorf("telegram %s: encode payload: %w", method, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("telegram %s: encode payload: %w", method, err)
+	}
+	url := fmt.Sprintf("%s/bot%s/%s", b.apiBase, b.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return b.do(req, method, result)
+}
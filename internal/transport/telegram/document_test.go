@@ -0,0 +1,56 @@
+package telegram
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chatcode/internal/domain"
+)
+
+func TestSendFileUploadsMultipartDocument(t *testing.T) {
+	var fields map[string]string
+	var fileName, content string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse multipart: %v", err)
+		}
+		fields = map[string]string{}
+		for k, v := range req.MultipartForm.Value {
+			fields[k] = v[0]
+		}
+		f, hdr, err := req.FormFile("document")
+		if err != nil {
+			t.Errorf("form file: %v", err)
+		} else {
+			fileName = hdr.Filename
+			data, _ := io.ReadAll(f)
+			content = string(data)
+		}
+		_, _ = io.WriteString(rw, `{"ok":true,"result":{"message_id":12}}`)
+	}))
+	defer server.Close()
+	b := New("token", "777")
+	b.apiBase = server.URL
+
+	ref, err := b.SendFile(context.Background(), domain.OutboundFile{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "-100", ThreadID: "7"},
+		Name:       "job-1.log",
+		Content:    []byte("full output"),
+		Caption:    "job 1 · done",
+	})
+	if err != nil {
+		t.Fatalf("send file: %v", err)
+	}
+	if ref.ID != "12" {
+		t.Fatalf("unexpected ref: %+v", ref)
+	}
+	if fields["chat_id"] != "-100" || fields["message_thread_id"] != "7" || fields["caption"] != "job 1 · done" {
+		t.Fatalf("unexpected fields: %#v", fields)
+	}
+	if fileName != "job-1.log" || content != "full output" {
+		t.Fatalf("unexpected file %q: %q", fileName, content)
+	}
+}
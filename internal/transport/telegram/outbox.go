@@ -0,0 +1,157 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Telegram asks bots to stay below about one message per second in a
+	// chat, 20 per minute in groups, and 30 per second overall.
+	privateChatInterval = time.Second
+	groupChatInterval   = 3 * time.Second
+	globalInterval      = time.Second / 30
+	maxFloodRetries     = 5
+)
+
+// outbox serializes outbound calls per chat so they are delivered in order,
+// spaces them to respect Telegram's rate limits and retries calls rejected
+// with 429 after the advertised retry_after.
+type outbox struct {
+	mu         sync.Mutex
+	chats      map[string]*chatQueue
+	nextGlobal time.Time
+
+	privateInterval time.Duration
+	groupInterval   time.Duration
+	globalInterval  time.Duration
+}
+
+type chatQueue struct {
+	reqs    []*outboundRequest
+	running bool
+	next    time.Time
+}
+
+type outboundRequest struct {
+	ctx  context.Context
+	do   func(context.Context) error
+	done chan error
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		chats:           make(map[string]*chatQueue),
+		privateInterval: privateChatInterval,
+		groupInterval:   groupChatInterval,
+		globalInterval:  globalInterval,
+	}
+}
+
+// submit queues do behind earlier calls for chatID and waits for its result.
+// If ctx ends first the call is skipped when its turn comes.
+func (o *outbox) submit(ctx context.Context, chatID string, do func(context.Context) error) error {
+	req := &outboundRequest{ctx: ctx, do: do, done: make(chan error, 1)}
+	o.mu.Lock()
+	q, ok := o.chats[chatID]
+	if !ok {
+		q = &chatQueue{}
+		o.chats[chatID] = q
+	}
+	q.reqs = append(q.reqs, req)
+	if !q.running {
+		q.running = true
+		go o.drain(chatID, q)
+	}
+	o.mu.Unlock()
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *outbox) drain(chatID string, q *chatQueue) {
+	for {
+		o.mu.Lock()
+		if len(q.reqs) == 0 {
+			q.running = false
+			// Keep the queue while its spacing still applies so the next
+			// message does not skip the per-chat interval.
+			if time.Now().After(q.next) {
+				delete(o.chats, chatID)
+			}
+			o.mu.Unlock()
+			return
+		}
+		req := q.reqs[0]
+		q.reqs = q.reqs[1:]
+		o.mu.Unlock()
+
+		req.done <- o.deliver(chatID, q, req)
+	}
+}
+
+func (o *outbox) deliver(chatID string, q *chatQueue, req *outboundRequest) error {
+	for attempt := 0; ; attempt++ {
+		if err := o.wait(req.ctx, q); err != nil {
+			return err
+		}
+		err := req.do(req.ctx)
+		o.mu.Lock()
+		q.next = time.Now().Add(o.chatInterval(chatID))
+		o.mu.Unlock()
+
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter <= 0 || attempt >= maxFloodRetries {
+			return err
+		}
+		slog.Warn("telegram flood control, retrying", "chat_id", chatID, "retry_after", apiErr.RetryAfter, "attempt", attempt+1)
+		o.mu.Lock()
+		q.next = time.Now().Add(apiErr.RetryAfter)
+		o.mu.Unlock()
+	}
+}
+
+// wait blocks until both the chat's spacing and a global send slot allow
+// the next call.
+func (o *outbox) wait(ctx context.Context, q *chatQueue) error {
+	o.mu.Lock()
+	at := q.next
+	now := time.Now()
+	if at.Before(now) {
+		at = now
+	}
+	if at.Before(o.nextGlobal) {
+		at = o.nextGlobal
+	}
+	o.nextGlobal = at.Add(o.globalInterval)
+	o.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (o *outbox) chatInterval(chatID string) time.Duration {
+	// Group and channel IDs are negative.
+	if strings.HasPrefix(chatID, "-") {
+		return o.groupInterval
+	}
+	return o.privateInterval
+}
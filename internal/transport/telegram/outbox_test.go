@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+func TestOutboxKeepsOrderPerChat(t *testing.T) {
+	o := newOutbox()
+	o.privateInterval = 10 * time.Millisecond
+	o.globalInterval = 0
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		i := i
+		go func() {
+			defer wg.Done()
+			_ = o.submit(context.Background(), "1", func(context.Context) error {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				return nil
+			})
+		}()
+		// Stagger submissions so the enqueue order is deterministic.
+		time.Sleep(2 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("unexpected delivery order: %#v", order)
+		}
+	}
+}
+
+func TestSendRetriesAfterFloodControl(t *testing.T) {
+	api := newFakeAPI(t)
+	attempts := 0
+	api.reply = func(method string, _ map[string]any) (int, string) {
+		if method != "sendMessage" {
+			return http.StatusOK, `{"ok":true,"result":true}`
+		}
+		attempts++
+		if attempts == 1 {
+			return http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`
+		}
+		return http.StatusOK, `{"ok":true,"result":{"message_id":77}}`
+	}
+	b := New("token", "777")
+	b.apiBase = api.server.URL
+
+	start := time.Now()
+	ref, err := b.Send(context.Background(), domain.OutboundMessage{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "999"},
+		Text:       "hello",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if ref.ID != "77" {
+		t.Fatalf("expected message id 77, got %q", ref.ID)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected one retry, got %d attempts", attempts)
+	}
+	if time.Since(start) < time.Second {
+		t.Fatalf("expected retry to wait for retry_after")
+	}
+}
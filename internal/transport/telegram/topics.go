@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"context"
+	"strconv"
+
+	"chatcode/internal/domain"
+)
+
+type forumTopic struct {
+	Name string `json:"name"`
+}
+
+// CreateTopic creates a forum topic in key's chat and returns the key of
+// its thread.
+func (b *Bot) CreateTopic(ctx context.Context, key domain.SessionKey, name string) (domain.SessionKey, error) {
+	var topic struct {
+		MessageThreadID int64  `json:"message_thread_id"`
+		Name            string `json:"name"`
+	}
+	err := b.outbox.submit(ctx, key.ChatID, func(ctx context.Context) error {
+		return b.call(ctx, "createForumTopic", map[string]any{"chat_id": key.ChatID, "name": name}, &topic)
+	})
+	if err != nil {
+		return domain.SessionKey{}, err
+	}
+	topicKey := domain.SessionKey{
+		Platform: key.Platform,
+		ChatID:   key.ChatID,
+		ThreadID: strconv.FormatInt(topic.MessageThreadID, 10),
+	}
+	b.topics.Store(topicKey.ChatID+":"+topicKey.ThreadID, topic.Name)
+	return topicKey, nil
+}
+
+// topicName returns the name of the forum topic u was posted in. Telegram
+// only sends names on topic service messages; messages inside a topic
+// reply to its creation message unless they reply to something else, so
+// names are remembered as they are seen.
+func (b *Bot) topicName(u update) string {
+	if !u.Message.IsTopicMessage || u.Message.MessageThreadID == 0 {
+		return ""
+	}
+	cacheKey := u.Message.Chat.ID.String() + ":" + strconv.FormatInt(u.Message.MessageThreadID, 10)
+	var name string
+	switch {
+	case u.Message.ForumTopicCreated != nil:
+		name = u.Message.ForumTopicCreated.Name
+	case u.Message.ForumTopicEdited != nil && u.Message.ForumTopicEdited.Name != "":
+		name = u.Message.ForumTopicEdited.Name
+	case u.Message.ReplyToMessage != nil && u.Message.ReplyToMessage.ForumTopicCreated != nil:
+		name = u.Message.ReplyToMessage.ForumTopicCreated.Name
+	}
+	if name != "" {
+		b.topics.Store(cacheKey, name)
+		return name
+	}
+	if v, ok := b.topics.Load(cacheKey); ok {
+		return v.(string)
+	}
+	return ""
+}
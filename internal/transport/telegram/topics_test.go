@@ -0,0 +1,53 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"chatcode/internal/domain"
+)
+
+func TestCreateTopicReturnsThreadKey(t *testing.T) {
+	api := newFakeAPI(t)
+	api.reply = func(method string, body map[string]any) (int, string) {
+		return http.StatusOK, `{"ok":true,"result":{"message_thread_id":31,"name":"web-app"}}`
+	}
+	b := New("token", "5", WithAPIBase(api.server.URL))
+	key := domain.SessionKey{Platform: domain.PlatformTelegram, ChatID: "-100"}
+	got, err := b.CreateTopic(context.Background(), key, "web-app")
+	if err != nil {
+		t.Fatalf("create topic: %v", err)
+	}
+	if got.ChatID != "-100" || got.ThreadID != "31" {
+		t.Fatalf("unexpected topic key %+v", got)
+	}
+	if !api.called("createForumTopic") || api.bodies[0]["name"] != "web-app" {
+		t.Fatalf("expected createForumTopic with name, got %v %v", api.calls, api.bodies)
+	}
+}
+
+func TestHandleUpdateReportsForumTopicName(t *testing.T) {
+	b := New("token", "5")
+	b.id = "42"
+	var got []domain.Message
+	handle := func(raw string) {
+		b.handleUpdate(context.Background(), groupUpdate(t, raw), func(_ context.Context, m domain.Message) error {
+			got = append(got, m)
+			return nil
+		})
+	}
+
+	handle(`{"message":{"message_id":1,"message_thread_id":8,"is_topic_message":true,"chat":{"id":-100,"type":"supergroup","is_forum":true},"from":{"id":5},"forum_topic_created":{"name":"api"}}}`)
+	handle(`{"message":{"message_id":2,"message_thread_id":8,"is_topic_message":true,"text":"/status","chat":{"id":-100,"type":"supergroup","is_forum":true},"from":{"id":5},"reply_to_message":{"message_id":3,"from":{"id":5}}}}`)
+	handle(`{"message":{"message_id":4,"message_thread_id":8,"is_topic_message":true,"chat":{"id":-100,"type":"supergroup","is_forum":true},"from":{"id":5},"forum_topic_edited":{"name":"api-v2"}}}`)
+
+	if len(got) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(got))
+	}
+	for i, want := range []string{"api", "api", "api-v2"} {
+		if !got[i].Meta.Forum || got[i].Meta.TopicName != want || got[i].SessionKey.ThreadID != "8" {
+			t.Errorf("message %d: expected forum topic %q in thread 8, got %+v", i, want, got[i])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// updateQueueSize bounds the updates waiting to be handled; the webhook
+	// answers 503 beyond it and Telegram delivers the update again later.
+	updateQueueSize = 64
+)
+
+type webhookConfig struct {
+	listenAddr string
+	url        string
+	secret     string
+}
+
+// WithWebhook switches the bot from getUpdates long polling to receiving
+// updates on listenAddr. publicURL is registered with setWebhook and its path
+// is the one served locally; every request must carry secret in the
+// X-Telegram-Bot-Api-Secret-Token header.
+func WithWebhook(listenAddr, publicURL, secret string) Option {
+	return func(b *Bot) {
+		b.webhook = &webhookConfig{listenAddr: listenAddr, url: publicURL, secret: secret}
+	}
+}
+
+func (b *Bot) serveWebhook(ctx context.Context) error {
+	u, err := url.Parse(b.webhook.url)
+	if err != nil {
+		return fmt.Errorf("telegram webhook url: %w", err)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	ln, err := net.Listen("tcp", b.webhook.listenAddr)
+	if err != nil {
+		return fmt.Errorf("telegram webhook listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, b.webhookHandler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	if err := b.call(ctx, "setWebhook", map[string]any{
+		"url":          b.webhook.url,
+		"secret_token": b.webhook.secret,
+	}, nil); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	slog.Info("telegram webhook registered", "listen_addr", ln.Addr().String(), "path", path)
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+	serveErr := server.Serve(ln)
+
+	// The start context is already cancelled here; give deleteWebhook its own
+	// short deadline so the registration does not outlive the daemon.
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.call(cleanupCtx, "deleteWebhook", map[string]any{}, nil); err != nil {
+		slog.Error("telegram deleteWebhook failed", "error", err)
+	}
+	if serveErr != nil && serveErr != http.ErrServerClosed {
+		return serveErr
+	}
+	return nil
+}
+
+// webhookHandler accepts updates pushed by Telegram and queues them for
+// processUpdates, so the request is answered before the update is handled
+// and a slow handler does not make Telegram retry.
+func (b *Bot) webhookHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		got := req.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(b.webhook.secret)) != 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var u update
+		if err := json.NewDecoder(req.Body).Decode(&u); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		select {
+		case b.updates <- u:
+			rw.WriteHeader(http.StatusOK)
+		default:
+			slog.Warn("telegram update queue full, asking for redelivery", "update_id", u.UpdateID)
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
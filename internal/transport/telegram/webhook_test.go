@@ -0,0 +1,156 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+// fakeAPI is a local stand-in for the Bot API that records every call.
+type fakeAPI struct {
+	mu      sync.Mutex
+	calls   []string
+	bodies  []map[string]any
+	queries []string
+	server  *httptest.Server
+	// reply returns the JSON response for a method; nil means {"ok":true,"result":true}.
+	reply func(method string, body map[string]any) (int, string)
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+	f := &fakeAPI{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+		body := map[string]any{}
+		raw, _ := io.ReadAll(req.Body)
+		_ = json.Unmarshal(raw, &body)
+		f.mu.Lock()
+		f.calls = append(f.calls, method)
+		f.bodies = append(f.bodies, body)
+		f.queries = append(f.queries, req.URL.RawQuery)
+		reply := f.reply
+		f.mu.Unlock()
+		status, resp := http.StatusOK, `{"ok":true,"result":true}`
+		if reply != nil {
+			status, resp = reply(method, body)
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_, _ = io.WriteString(rw, resp)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeAPI) called(method string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if c == method {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWebhookRegistersAndDeletesWebhook(t *testing.T) {
+	api := newFakeAPI(t)
+	b := New("token", "777", WithWebhook("127.0.0.1:0", "https://bot.example.com/telegram/webhook", "s3cret"))
+	b.apiBase = api.server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Start(ctx, func(context.Context, domain.Message) error { return nil }) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !api.called("setWebhook") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !api.called("setWebhook") {
+		t.Fatalf("expected setWebhook call")
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("webhook server did not stop")
+	}
+	if !api.called("deleteWebhook") {
+		t.Fatalf("expected deleteWebhook on shutdown")
+	}
+	if api.called("getUpdates") {
+		t.Fatalf("webhook mode must not poll")
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i, c := range api.calls {
+		if c == "setWebhook" && api.bodies[i]["secret_token"] != "s3cret" {
+			t.Fatalf("expected secret_token in setWebhook, got %#v", api.bodies[i])
+		}
+	}
+}
+
+func TestWebhookHandlerChecksSecretToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := New("token", "777", WithWebhook("127.0.0.1:0", "https://bot.example.com/hook", "s3cret"))
+	got := make(chan domain.Message, 1)
+	release := make(chan struct{})
+	go b.processUpdates(ctx, func(_ context.Context, msg domain.Message) error {
+		got <- msg
+		<-release
+		return nil
+	})
+	h := b.webhookHandler()
+	body := `{"update_id":1,"message":{"message_id":5,"text":"hello","chat":{"id":999},"from":{"id":777}}}`
+
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	req.Header.Set(secretTokenHeader, "wrong")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || len(b.updates) != 0 {
+		t.Fatalf("expected unauthorized without queueing, got %d and %d updates", rec.Code, len(b.updates))
+	}
+
+	// The handler blocks until released; the request is answered anyway.
+	req = httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	req.Header.Set(secretTokenHeader, "s3cret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	select {
+	case msg := <-got:
+		if msg.Text != "hello" || msg.SessionKey.ChatID != "999" {
+			t.Fatalf("unexpected handled message: %#v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("update was not handled")
+	}
+
+	for i := 0; i < updateQueueSize; i++ {
+		b.updates <- update{}
+	}
+	req = httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	req.Header.Set(secretTokenHeader, "s3cret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After on a full queue, got %d", rec.Code)
+	}
+	close(release)
+}
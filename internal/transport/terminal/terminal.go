@@ -0,0 +1,201 @@
+package terminal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chatcode/internal/domain"
+	"chatcode/internal/render"
+)
+
+// ChatID is the chat of the terminal session. It is the same on every run,
+// so the working directory and executor chosen with /cd and /mode stick.
+const ChatID = "local"
+
+// actionPrefix starts a line that presses a button, e.g. "!stop 42".
+const actionPrefix = "!"
+
+// actions are the buttons that can be pressed; other lines starting with
+// "!" are prompts.
+var actions = map[string]bool{
+	domain.ActionStopJob:    true,
+	domain.ActionRetryJob:   true,
+	domain.ActionShowLog:    true,
+	domain.ActionTranscript: true,
+	domain.ActionRunEdited:  true,
+}
+
+// Console is an in-process transport that reads prompts and commands from
+// a terminal, one per line, and writes the replies back to it. A line
+// ending in a backslash continues on the next one.
+type Console struct {
+	in     io.Reader
+	out    io.Writer
+	color  bool
+	sender string
+	// runID makes message IDs unique across runs; the store remembers
+	// handled IDs to drop redeliveries.
+	runID string
+	seq   atomic.Int64
+	mu    sync.Mutex
+}
+
+// Option configures optional Console behavior.
+type Option func(*Console)
+
+// WithColor renders HTML output with ANSI styles instead of as plain text.
+func WithColor(on bool) Option {
+	return func(c *Console) {
+		c.color = on
+	}
+}
+
+// WithSender sets the sender ID of messages, shown in job headers.
+func WithSender(id string) Option {
+	return func(c *Console) {
+		if id != "" {
+			c.sender = id
+		}
+	}
+}
+
+// New returns a console reading from in and writing to out.
+func New(in io.Reader, out io.Writer, opts ...Option) *Console {
+	c := &Console{
+		in:     in,
+		out:    out,
+		sender: "local",
+		runID:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Console) Name() string { return "terminal" }
+
+// Start hands each line read from the terminal to handler until ctx is done
+// or the input ends, when it returns nil. Errors of the handler are shown.
+func (c *Console) Start(ctx context.Context, handler domain.MessageHandler) error {
+	slog.Info("transport started", "transport", "terminal")
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		// Reading cannot be interrupted; the goroutine ends with the input.
+		sc := bufio.NewScanner(c.in)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- sc.Err()
+	}()
+	var pending []string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			if err != nil {
+				return fmt.Errorf("terminal read: %w", err)
+			}
+			return nil
+		case line := <-lines:
+			if cont, ok := strings.CutSuffix(line, `\`); ok {
+				pending = append(pending, cont)
+				continue
+			}
+			text := strings.TrimSpace(strings.Join(append(pending, line), "\n"))
+			pending = nil
+			if text == "" {
+				continue
+			}
+			if err := handler(ctx, c.toDomainMessage(text)); err != nil {
+				c.printf("%s\n", c.style("error: "+err.Error(), "\x1b[31m", "\x1b[39m"))
+			}
+		}
+	}
+}
+
+// toDomainMessage turns an input line into a message, or an action when
+// it starts with "!" and the name of one.
+func (c *Console) toDomainMessage(text string) domain.Message {
+	id := c.runID + "." + strconv.FormatInt(c.seq.Add(1), 10)
+	msg := domain.Message{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformTerminal, ChatID: ChatID},
+		SenderID:   c.sender,
+		Meta:       domain.InboundMessageMeta{MessageID: id, ReplyToMessageID: id},
+		At:         time.Now(),
+	}
+	if rest, ok := strings.CutPrefix(text, actionPrefix); ok {
+		name, data, _ := strings.Cut(rest, " ")
+		if actions[name] {
+			msg.Action = &domain.Action{Name: name, Data: strings.TrimSpace(data)}
+			return msg
+		}
+	}
+	msg.Text = text
+	return msg
+}
+
+// Send writes msg to the terminal. Buttons are listed with the line that
+// presses them.
+func (c *Console) Send(_ context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	text := msg.Text
+	if msg.Format == "html" {
+		if c.color {
+			text = render.HTMLToANSI(text)
+		} else {
+			text = render.PlainText(text)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(text, "\n"))
+	b.WriteByte('\n')
+	if len(msg.Actions) > 0 {
+		buttons := make([]string, 0, len(msg.Actions))
+		for _, a := range msg.Actions {
+			buttons = append(buttons, strings.TrimSpace(fmt.Sprintf("[%s] %s%s %s", a.Label, actionPrefix, a.Name, a.Data)))
+		}
+		b.WriteString(c.style(strings.Join(buttons, "  "), "\x1b[2m", "\x1b[22m"))
+		b.WriteByte('\n')
+	}
+	c.printf("%s", b.String())
+	id := strconv.FormatInt(c.seq.Add(1), 10)
+	return domain.MessageRef{SessionKey: msg.SessionKey, ID: c.runID + "." + id}, nil
+}
+
+// React shows a job status reaction on its own line.
+func (c *Console) React(_ context.Context, _ domain.MessageRef, emoji string) error {
+	if emoji != "" {
+		c.printf("%s\n", c.style("↳ "+emoji, "\x1b[2m", "\x1b[22m"))
+	}
+	return nil
+}
+
+func (c *Console) style(s, on, off string) string {
+	if !c.color {
+		return s
+	}
+	return on + s + off
+}
+
+// printf writes to the terminal; replies of concurrent jobs arrive from
+// several goroutines.
+func (c *Console) printf(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, format, args...)
+}
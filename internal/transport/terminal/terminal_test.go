@@ -0,0 +1,65 @@
+package terminal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"chatcode/internal/domain"
+)
+
+func TestStartReadsPromptsAndActions(t *testing.T) {
+	in := strings.NewReader("/cd api\n\nfix the \\\nbuild\n!retry 42\n!important prompt\n")
+	var out strings.Builder
+	c := New(in, &out, WithSender("dev"))
+	var got []domain.Message
+	err := c.Start(context.Background(), func(_ context.Context, msg domain.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 messages, got %+v", got)
+	}
+	want := domain.SessionKey{Platform: domain.PlatformTerminal, ChatID: ChatID}
+	if got[0].Text != "/cd api" || got[0].SessionKey != want || got[0].SenderID != "dev" {
+		t.Fatalf("unexpected command %+v", got[0])
+	}
+	if got[1].Text != "fix the \nbuild" {
+		t.Fatalf("expected continued line, got %q", got[1].Text)
+	}
+	if a := got[2].Action; a == nil || a.Name != domain.ActionRetryJob || a.Data != "42" {
+		t.Fatalf("unexpected action %+v", got[2])
+	}
+	if got[3].Action != nil || got[3].Text != "!important prompt" {
+		t.Fatalf("expected unknown action to be a prompt, got %+v", got[3])
+	}
+	if got[0].Meta.MessageID == got[1].Meta.MessageID {
+		t.Fatal("expected distinct message IDs")
+	}
+}
+
+func TestSendRendersHTML(t *testing.T) {
+	var out strings.Builder
+	c := New(strings.NewReader(""), &out, WithColor(true))
+	_, err := c.Send(context.Background(), domain.OutboundMessage{
+		Text:    "<b>job 42</b> done\n",
+		Format:  "html",
+		Actions: []domain.Action{{Name: domain.ActionRetryJob, Label: "Retry", Data: "42"}},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if want := "\x1b[1mjob 42\x1b[22m done\n\x1b[2m[Retry] !retry 42\x1b[22m\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	c = New(strings.NewReader(""), &out)
+	_, _ = c.Send(context.Background(), domain.OutboundMessage{Text: "<i>a &amp; b</i>", Format: "html"})
+	if out.String() != "a & b\n" {
+		t.Fatalf("expected plain text, got %q", out.String())
+	}
+}
@@ -0,0 +1,70 @@
+package whatsapp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatedRequiresFreshSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	w := NewWebBridge("127.0.0.1:0", "me", WithSharedSecret("s3cret", time.Minute))
+	w.auth.now = func() time.Time { return now }
+	var calls int
+	h := w.authenticated(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	body := []byte(`{"outbound_url":"http://127.0.0.1:9000/send"}`)
+	do := func(ts time.Time, secret, signedPath string) int {
+		req := httptest.NewRequest(http.MethodPost, "/whatsapp/config/outbound", bytes.NewReader(body))
+		s := newSigner(secret, time.Minute)
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		req.Header.Set(timestampHeader, stamp)
+		req.Header.Set(signatureHeader, s.sign(stamp, http.MethodPost, signedPath, body))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec.Code
+	}
+
+	if code := do(now, "wrong", "/whatsapp/config/outbound"); code != http.StatusUnauthorized {
+		t.Fatalf("expected bad signature to be rejected, got %d", code)
+	}
+	if code := do(now.Add(-5*time.Second), "s3cret", "/whatsapp/inbound"); code != http.StatusUnauthorized {
+		t.Fatalf("expected signature for another endpoint to be rejected, got %d", code)
+	}
+	if code := do(now.Add(-2*time.Minute), "s3cret", "/whatsapp/config/outbound"); code != http.StatusUnauthorized {
+		t.Fatalf("expected stale timestamp to be rejected, got %d", code)
+	}
+	if code := do(now.Add(-10*time.Second), "s3cret", "/whatsapp/config/outbound"); code != http.StatusNoContent {
+		t.Fatalf("expected signed request to pass, got %d", code)
+	}
+	if code := do(now.Add(-10*time.Second), "s3cret", "/whatsapp/config/outbound"); code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed request to be rejected, got %d", code)
+	}
+
+	// Changing the case of the hex digits must not get past the replay check.
+	stamp := strconv.FormatInt(now.Add(-10*time.Second).Unix(), 10)
+	sig := newSigner("s3cret", time.Minute).sign(stamp, http.MethodPost, "/whatsapp/config/outbound", body)
+	replay := httptest.NewRequest(http.MethodPost, "/whatsapp/config/outbound", bytes.NewReader(body))
+	replay.Header.Set(timestampHeader, stamp)
+	replay.Header.Set(signatureHeader, signaturePrefix+strings.ToUpper(strings.TrimPrefix(sig, signaturePrefix)))
+	rec := httptest.NewRecorder()
+	h(rec, replay)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected case-changed replay to be rejected, got %d", rec.Code)
+	}
+	if calls != 1 {
+		t.Fatalf("expected handler to run once, ran %d times", calls)
+	}
+
+	unsigned := httptest.NewRecorder()
+	h(unsigned, httptest.NewRequest(http.MethodPost, "/whatsapp/config/outbound", bytes.NewReader(body)))
+	if unsigned.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned request to be rejected, got %d", unsigned.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+const (
+	inboundQueueSize = 256
+	// seenTTL is how long accepted message IDs are remembered; bridges retry
+	// well within it. Older redeliveries are still dropped by the
+	// orchestrator, which records message IDs durably.
+	seenTTL = 10 * time.Minute
+)
+
+type inboundPayload struct {
+	MessageID string `json:"message_id"`
+	SenderID  string `json:"sender_id"`
+	ChatID    string `json:"chat_id"`
+	// ThreadID, when set, gives the message its own session within the
+	// chat.
+	ThreadID        string `json:"thread_id"`
+	Text            string `json:"text"`
+	QuotedMessageID string `json:"quoted_message_id"`
+	IsGroup         bool   `json:"is_group"`
+}
+
+// handleInbound validates a message from the bridge and queues it for the
+// handler. It answers 202 when the message was queued, 200 for a message
+// already accepted, 400, 403 or 405 for requests that should not be
+// retried, and 503 with Retry-After when the queue is full.
+func (w *WebBridge) handleInbound(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var payload inboundPayload
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		writeStatus(rw, http.StatusBadRequest, "invalid JSON payload")
+		return
+	}
+	if payload.ChatID == "" || payload.SenderID == "" {
+		writeStatus(rw, http.StatusBadRequest, "chat_id and sender_id are required")
+		return
+	}
+	if payload.SenderID != w.allowedSenderID {
+		writeStatus(rw, http.StatusForbidden, "sender is not allowed")
+		return
+	}
+	msg := toDomainMessage(payload)
+	seenKey := payload.ChatID + "/" + payload.MessageID
+	if payload.MessageID != "" && !w.markSeen(seenKey) {
+		slog.Info("whatsapp duplicate message ignored", "chat_id", payload.ChatID, "message_id", payload.MessageID)
+		writeStatus(rw, http.StatusOK, "duplicate")
+		return
+	}
+	select {
+	case w.inbound <- msg:
+	default:
+		if payload.MessageID != "" {
+			w.forget(seenKey)
+		}
+		rw.Header().Set("Retry-After", "5")
+		writeStatus(rw, http.StatusServiceUnavailable, "inbound queue is full")
+		return
+	}
+	slog.Info("whatsapp inbound message",
+		"chat_id", msg.SessionKey.ChatID,
+		"thread_id", msg.SessionKey.ThreadID,
+		"sender_id", msg.SenderID,
+		"message_id", payload.MessageID,
+		"group", payload.IsGroup,
+	)
+	writeStatus(rw, http.StatusAccepted, "queued")
+}
+
+func toDomainMessage(p inboundPayload) domain.Message {
+	return domain.Message{
+		SessionKey: domain.SessionKey{Platform: domain.PlatformWhatsApp, ChatID: p.ChatID, ThreadID: p.ThreadID},
+		SenderID:   p.SenderID,
+		Text:       p.Text,
+		Meta: domain.InboundMessageMeta{
+			MessageID:        p.MessageID,
+			ReplyToMessageID: p.MessageID,
+			QuotedMessageID:  p.QuotedMessageID,
+		},
+		At: time.Now().UTC(),
+	}
+}
+
+// processInbound hands queued messages to the handler one at a time, so a
+// chat's messages are handled in the order they arrived.
+func (w *WebBridge) processInbound(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-w.inbound:
+			if err := w.handler(ctx, msg); err != nil {
+				slog.Error("whatsapp handle message failed", "chat_id", msg.SessionKey.ChatID, "message_id", msg.Meta.MessageID, "error", err)
+			}
+		}
+	}
+}
+
+// markSeen records key and reports whether it was new.
+func (w *WebBridge) markSeen(key string) bool {
+	w.seenMu.Lock()
+	defer w.seenMu.Unlock()
+	now := time.Now()
+	for k, at := range w.seen {
+		if now.Sub(at) > seenTTL {
+			delete(w.seen, k)
+		}
+	}
+	if _, ok := w.seen[key]; ok {
+		return false
+	}
+	w.seen[key] = now
+	return true
+}
+
+func (w *WebBridge) forget(key string) {
+	w.seenMu.Lock()
+	defer w.seenMu.Unlock()
+	delete(w.seen, key)
+}
+
+func writeStatus(rw http.ResponseWriter, status int, text string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(map[string]string{"status": text})
+}
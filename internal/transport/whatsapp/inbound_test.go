@@ -0,0 +1,59 @@
+package whatsapp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+func TestHandleInboundQueuesOnceAndMapsThreads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := NewWebBridge("127.0.0.1:0", "alice")
+	got := make(chan domain.Message, 4)
+	w.handler = func(_ context.Context, msg domain.Message) error {
+		got <- msg
+		return nil
+	}
+	go w.processInbound(ctx)
+
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		w.handleInbound(rec, httptest.NewRequest(http.MethodPost, "/whatsapp/inbound", strings.NewReader(body)))
+		return rec.Code
+	}
+	msg := `{"message_id":"m1","sender_id":"alice","chat_id":"g1@g.us","thread_id":"t1","text":"run tests","quoted_message_id":"m0","is_group":true}`
+	if code := post(msg); code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", code)
+	}
+	if code := post(msg); code != http.StatusOK {
+		t.Fatalf("expected retry to be acknowledged with 200, got %d", code)
+	}
+	if code := post(`{"message_id":"m2","sender_id":"mallory","chat_id":"g1@g.us","text":"rm -rf"}`); code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", code)
+	}
+	if code := post(`{"text":"no chat"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+
+	select {
+	case m := <-got:
+		want := domain.SessionKey{Platform: domain.PlatformWhatsApp, ChatID: "g1@g.us", ThreadID: "t1"}
+		if m.SessionKey != want || m.Meta.MessageID != "m1" || m.Meta.QuotedMessageID != "m0" {
+			t.Fatalf("unexpected message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not handled")
+	}
+	select {
+	case m := <-got:
+		t.Fatalf("expected duplicate to be dropped, got %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,129 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxOutboxItems   = 1000
+	minRetryBackoff  = time.Second
+	maxRetryBackoff  = time.Minute
+	outboundURLState = "outbound_url"
+	// configuredURLState is the configured URL at the time the bridge
+	// registered the one stored under outboundURLState.
+	configuredURLState = "outbound_url_configured"
+	outboundTimeout    = 30 * time.Second
+)
+
+var errOutboxFull = errors.New("whatsapp outbox is full")
+
+// outbox buffers outbound payloads while the bridge has not registered its
+// URL or cannot be reached, and delivers them in order once it can. It is
+// kept in memory only: payloads still waiting are lost on restart.
+type outbox struct {
+	mu    sync.Mutex
+	items []outboundItem
+	wake  chan struct{}
+}
+
+type outboundItem struct {
+	payload map[string]string
+	// fallback is sent instead when the bridge rejects payload as invalid.
+	fallback map[string]string
+}
+
+func newOutbox() *outbox {
+	return &outbox{wake: make(chan struct{}, 1)}
+}
+
+func (o *outbox) push(item outboundItem) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) >= maxOutboxItems {
+		return errOutboxFull
+	}
+	o.items = append(o.items, item)
+	o.notify()
+	return nil
+}
+
+// notify wakes the delivery loop, e.g. after the outbound URL changed.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) peek() (outboundItem, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) == 0 {
+		return outboundItem{}, false
+	}
+	return o.items[0], true
+}
+
+func (o *outbox) replaceHead(item outboundItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) > 0 {
+		o.items[0] = item
+	}
+}
+
+func (o *outbox) pop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) > 0 {
+		o.items = o.items[1:]
+	}
+}
+
+// deliver posts queued payloads until ctx ends. Failed deliveries are
+// retried with exponential backoff; payloads the bridge rejects as invalid
+// are replaced by their fallback or dropped.
+func (w *WebBridge) deliver(ctx context.Context) {
+	backoff := minRetryBackoff
+	for {
+		item, ok := w.outbox.peek()
+		if !ok || w.outbound() == "" {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.outbox.wake:
+				continue
+			}
+		}
+		postCtx, cancel := context.WithTimeout(ctx, outboundTimeout)
+		status, err := w.post(postCtx, item.payload)
+		cancel()
+		switch {
+		case err == nil:
+			w.outbox.pop()
+			backoff = minRetryBackoff
+		case status >= 400 && status < 500 && status != http.StatusTooManyRequests:
+			if item.fallback != nil {
+				slog.Warn("whatsapp bridge rejected payload, sending fallback", "chat_id", item.payload["chat_id"], "status", status)
+				w.outbox.replaceHead(outboundItem{payload: item.fallback})
+				continue
+			}
+			slog.Error("whatsapp bridge rejected message, dropping it", "chat_id", item.payload["chat_id"], "status", status, "error", err)
+			w.outbox.pop()
+		default:
+			slog.Warn("whatsapp outbound delivery failed, retrying", "chat_id", item.payload["chat_id"], "retry_in", backoff, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.outbox.wake:
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxRetryBackoff)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatcode/internal/domain"
+)
+
+type memoryState struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (m *memoryState) TransportState(_ context.Context, platform domain.Platform, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[string(platform)+"/"+key], nil
+}
+
+func (m *memoryState) SetTransportState(_ context.Context, platform domain.Platform, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[string(platform)+"/"+key] = value
+	return nil
+}
+
+func TestOutboxBuffersUntilBridgeIsReachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var delivered []string
+	calls := 0
+	bridge := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var payload map[string]string
+		_ = json.NewDecoder(req.Body).Decode(&payload)
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		switch {
+		case calls == 1:
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case payload["file_name"] != "":
+			rw.WriteHeader(http.StatusBadRequest)
+		default:
+			delivered = append(delivered, payload["text"])
+			rw.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer bridge.Close()
+
+	state := &memoryState{values: map[string]string{}}
+	w := NewWebBridge("127.0.0.1:0", "me", WithStateStore(state))
+	key := domain.SessionKey{Platform: domain.PlatformWhatsApp, ChatID: "c1"}
+	if _, err := w.Send(ctx, domain.OutboundMessage{SessionKey: key, Text: "first"}); err != nil {
+		t.Fatalf("send without outbound URL: %v", err)
+	}
+	if _, err := w.SendFile(ctx, domain.OutboundFile{SessionKey: key, Name: "out.txt", Content: []byte("file body"), Caption: "output"}); err != nil {
+		t.Fatalf("send file: %v", err)
+	}
+	if _, err := w.Send(ctx, domain.OutboundMessage{SessionKey: key, Text: "last"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	go w.deliver(ctx)
+
+	body := strings.NewReader(`{"outbound_url":"` + bridge.URL + `"}`)
+	rec := httptest.NewRecorder()
+	w.handleConfig(rec, httptest.NewRequest(http.MethodPost, "/whatsapp/config/outbound", body))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("register outbound URL: status %d", rec.Code)
+	}
+	if got, _ := state.TransportState(ctx, domain.PlatformWhatsApp, outboundURLState); got != bridge.URL {
+		t.Fatalf("expected outbound URL to be persisted, got %q", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(delivered)
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out, delivered %q", delivered)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if delivered[0] != "first" || delivered[1] != "output\n\nfile body" || delivered[2] != "last" {
+		t.Fatalf("unexpected delivery order: %q", delivered)
+	}
+
+	restarted := NewWebBridge("127.0.0.1:0", "me", WithStateStore(state))
+	restarted.restoreOutboundURL(ctx)
+	if got := restarted.outbound(); got != bridge.URL {
+		t.Fatalf("expected persisted URL to win after restart, got %q", got)
+	}
+
+	reconfigured := NewWebBridge("127.0.0.1:0", "me", WithStateStore(state), WithOutboundURL("http://127.0.0.1:1/static"))
+	reconfigured.restoreOutboundURL(ctx)
+	if got := reconfigured.outbound(); got != "http://127.0.0.1:1/static" {
+		t.Fatalf("expected changed configured URL to win, got %q", got)
+	}
+	if got, _ := state.TransportState(ctx, domain.PlatformWhatsApp, outboundURLState); got != "" {
+		t.Fatalf("expected stale registered URL to be cleared, got %q", got)
+	}
+}
+
+func TestSendConvertsHTMLToWhatsAppMarkup(t *testing.T) {
+	w := NewWebBridge("127.0.0.1:0", "me")
+	key := domain.SessionKey{Platform: domain.PlatformWhatsApp, ChatID: "c1"}
+	msg := domain.OutboundMessage{SessionKey: key, Text: "<b>command_execution</b>\n<pre>ls &amp;&amp; pwd</pre>", Format: "html"}
+	if _, err := w.Send(context.Background(), msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	item, ok := w.outbox.peek()
+	if !ok {
+		t.Fatal("expected a queued message")
+	}
+	if want := "*command_execution*\n```ls && pwd```"; item.payload["text"] != want {
+		t.Fatalf("got %q, want %q", item.payload["text"], want)
+	}
+}
@@ -3,36 +3,102 @@ package whatsapp
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"chatcode/internal/domain"
+	"chatcode/internal/render"
 )
 
+// fileFallbackBytes bounds the text sent in place of a file the bridge could
+// not deliver.
+const fileFallbackBytes = 3500
+
 type WebBridge struct {
 	listenAddr      string
 	allowedSenderID string
-	outboundURL     string
 	handler         domain.MessageHandler
 	server          *http.Server
+	auth            *signer
+	state           StateStore
+	outbox          *outbox
+	inbound         chan domain.Message
+	seenMu          sync.Mutex
+	// seen holds recently accepted "chat_id/message_id" pairs so bridge
+	// retries are acknowledged without being handled twice.
+	seen map[string]time.Time
+
+	// configuredURL is the URL given by WithOutboundURL; a registered URL
+	// persisted under another configured URL is not restored.
+	configuredURL string
+
+	mu          sync.Mutex
+	outboundURL string
+}
+
+// StateStore persists transport state across restarts.
+type StateStore interface {
+	TransportState(ctx context.Context, platform domain.Platform, key string) (string, error)
+	SetTransportState(ctx context.Context, platform domain.Platform, key, value string) error
 }
 
-func NewWebBridge(listenAddr, allowedSenderID string) *WebBridge {
-	return &WebBridge{listenAddr: listenAddr, allowedSenderID: allowedSenderID}
+// Option configures optional WebBridge behavior.
+type Option func(*WebBridge)
+
+// WithOutboundURL sets the URL outbound messages are posted to until the
+// bridge registers another one.
+func WithOutboundURL(url string) Option {
+	return func(w *WebBridge) {
+		w.configuredURL = url
+		w.outboundURL = url
+	}
+}
+
+// WithStateStore persists the outbound URL registered by the bridge, which
+// then takes precedence over WithOutboundURL after a restart unless the
+// configured URL has changed since.
+func WithStateStore(st StateStore) Option {
+	return func(w *WebBridge) {
+		w.state = st
+	}
+}
+
+func NewWebBridge(listenAddr, allowedSenderID string, opts ...Option) *WebBridge {
+	w := &WebBridge{
+		listenAddr:      listenAddr,
+		allowedSenderID: allowedSenderID,
+		outbox:          newOutbox(),
+		inbound:         make(chan domain.Message, inboundQueueSize),
+		seen:            make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
 }
 
 func (w *WebBridge) Name() string { return "whatsapp" }
 
 func (w *WebBridge) Start(ctx context.Context, handler domain.MessageHandler) error {
 	w.handler = handler
+	w.restoreOutboundURL(ctx)
+	go w.deliver(ctx)
+	go w.processInbound(ctx)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/whatsapp/inbound", w.handleInbound)
-	mux.HandleFunc("/whatsapp/config/outbound", w.handleConfig)
+	mux.HandleFunc("/whatsapp/inbound", w.authenticated(w.handleInbound))
+	mux.HandleFunc("/whatsapp/config/outbound", w.authenticated(w.handleConfig))
 	w.server = &http.Server{Addr: w.listenAddr, Handler: mux}
-	slog.Info("transport started", "transport", "whatsapp", "listen_addr", w.listenAddr)
+	slog.Info("transport started", "transport", "whatsapp", "listen_addr", w.listenAddr, "signed", w.auth != nil)
+	if w.auth == nil {
+		slog.Warn("whatsapp bridge endpoints are unauthenticated, set whatsapp.shared_secret")
+	}
 	go func() {
 		<-ctx.Done()
 		_ = w.server.Shutdown(context.Background())
@@ -43,55 +109,117 @@ func (w *WebBridge) Start(ctx context.Context, handler domain.MessageHandler) er
 	return nil
 }
 
-func (w *WebBridge) Send(ctx context.Context, msg domain.OutboundMessage) error {
-	if w.outboundURL == "" {
-		return fmt.Errorf("whatsapp outbound URL is not configured")
+// Send queues msg in the outbox, which delivers it once the bridge is
+// reachable. HTML text is converted to WhatsApp markup first. It only
+// fails when the outbox is full.
+func (w *WebBridge) Send(ctx context.Context, msg domain.OutboundMessage) (domain.MessageRef, error) {
+	ref := domain.MessageRef{SessionKey: msg.SessionKey}
+	text := msg.Text
+	if msg.Format == "html" {
+		text = render.HTMLToWhatsApp(text)
 	}
-	payload := map[string]string{"chat_id": msg.SessionKey.ChatID, "text": msg.Text}
-	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, w.outboundURL, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	payload := map[string]string{"chat_id": msg.SessionKey.ChatID, "text": text}
+	if msg.SessionKey.ThreadID != "" {
+		payload["thread_id"] = msg.SessionKey.ThreadID
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("whatsapp outbound status=%d", resp.StatusCode)
+	if msg.ReplyToMessageID != "" {
+		payload["quoted_message_id"] = msg.ReplyToMessageID
 	}
-	return nil
+	return ref, w.outbox.push(outboundItem{payload: payload})
 }
 
-func (w *WebBridge) handleInbound(rw http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodPost {
-		rw.WriteHeader(http.StatusMethodNotAllowed)
+// SendFile asks the bridge to deliver f as a document. Bridges that do not
+// understand file payloads answer with a 4xx status; the file is then sent
+// as a text message holding the caption and the end of the content.
+func (w *WebBridge) SendFile(ctx context.Context, f domain.OutboundFile) (domain.MessageRef, error) {
+	ref := domain.MessageRef{SessionKey: f.SessionKey}
+	payload := map[string]string{
+		"chat_id":     f.SessionKey.ChatID,
+		"text":        f.Caption,
+		"file_name":   f.Name,
+		"file_base64": base64.StdEncoding.EncodeToString(f.Content),
+		"mime_type":   "text/plain",
+	}
+	fallback := map[string]string{"chat_id": f.SessionKey.ChatID, "text": fileFallbackText(f)}
+	return ref, w.outbox.push(outboundItem{payload: payload, fallback: fallback})
+}
+
+// fileFallbackText holds the caption and the end of a file's content.
+func fileFallbackText(f domain.OutboundFile) string {
+	text := string(f.Content)
+	if len(text) > fileFallbackBytes {
+		cut := len(text) - fileFallbackBytes
+		for cut < len(text) && !utf8.RuneStart(text[cut]) {
+			cut++
+		}
+		text = fmt.Sprintf("…(%d bytes omitted)\n", cut) + text[cut:]
+	}
+	if f.Caption != "" {
+		text = f.Caption + "\n\n" + text
+	}
+	return text
+}
+
+func (w *WebBridge) outbound() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.outboundURL
+}
+
+func (w *WebBridge) restoreOutboundURL(ctx context.Context) {
+	if w.state == nil {
 		return
 	}
-	var payload struct {
-		SenderID string `json:"sender_id"`
-		ChatID   string `json:"chat_id"`
-		Text     string `json:"text"`
+	v, err := w.state.TransportState(ctx, domain.PlatformWhatsApp, outboundURLState)
+	if err != nil {
+		slog.Error("whatsapp restore outbound URL failed", "error", err)
+		return
 	}
-	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+	if v == "" {
 		return
 	}
-	if payload.SenderID != w.allowedSenderID {
-		rw.WriteHeader(http.StatusForbidden)
+	configured, err := w.state.TransportState(ctx, domain.PlatformWhatsApp, configuredURLState)
+	if err != nil {
+		slog.Error("whatsapp restore outbound URL failed", "error", err)
 		return
 	}
-	msg := domain.Message{
-		SessionKey: domain.SessionKey{Platform: domain.PlatformWhatsApp, ChatID: payload.ChatID},
-		SenderID:   payload.SenderID,
-		Text:       payload.Text,
-		At:         time.Now().UTC(),
-	}
-	slog.Info("whatsapp inbound message",
-		"chat_id", msg.SessionKey.ChatID,
-		"sender_id", msg.SenderID,
-	)
-	_ = w.handler(req.Context(), msg)
-	rw.WriteHeader(http.StatusAccepted)
+	if configured != w.configuredURL {
+		// The operator changed whatsapp.outbound_url; it wins over the URL
+		// the bridge registered before.
+		if err := w.state.SetTransportState(ctx, domain.PlatformWhatsApp, outboundURLState, ""); err != nil {
+			slog.Error("whatsapp clear outbound URL failed", "error", err)
+		}
+		slog.Info("whatsapp registered outbound URL dropped, configured URL changed", "outbound_url", w.configuredURL)
+		return
+	}
+	w.mu.Lock()
+	w.outboundURL = v
+	w.mu.Unlock()
+	slog.Info("whatsapp outbound URL restored", "outbound_url", v)
+}
+
+// post sends payload to the bridge's outbound URL and returns the response
+// status, or 0 when no response was received.
+func (w *WebBridge) post(ctx context.Context, payload any) (int, error) {
+	outboundURL := w.outbound()
+	if outboundURL == "" {
+		return 0, fmt.Errorf("whatsapp outbound URL is not configured")
+	}
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, outboundURL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if w.auth != nil {
+		w.auth.signRequest(req, body)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("whatsapp outbound status=%d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
 }
 
 func (w *WebBridge) handleConfig(rw http.ResponseWriter, req *http.Request) {
@@ -106,6 +234,22 @@ func (w *WebBridge) handleConfig(rw http.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if u, err := url.Parse(payload.OutboundURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.mu.Lock()
 	w.outboundURL = payload.OutboundURL
+	w.mu.Unlock()
+	if w.state != nil {
+		if err := w.state.SetTransportState(req.Context(), domain.PlatformWhatsApp, outboundURLState, payload.OutboundURL); err != nil {
+			slog.Error("whatsapp save outbound URL failed", "error", err)
+		}
+		if err := w.state.SetTransportState(req.Context(), domain.PlatformWhatsApp, configuredURLState, w.configuredURL); err != nil {
+			slog.Error("whatsapp save outbound URL failed", "error", err)
+		}
+	}
+	slog.Info("whatsapp outbound URL registered", "outbound_url", payload.OutboundURL)
+	w.outbox.notify()
 	rw.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,5 @@
+ALTER TABLE jobs ADD COLUMN platform TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN chat_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN thread_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN permission_mode TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN executor_session TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN format TEXT NOT NULL DEFAULT '';
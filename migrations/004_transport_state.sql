@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS transport_state (
+    platform TEXT NOT NULL,
+    state_key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, state_key)
+);
+
+CREATE TABLE IF NOT EXISTS inbound_messages (
+    platform TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    received_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, chat_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_inbound_messages_received_at ON inbound_messages(received_at);
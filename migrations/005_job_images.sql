@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN images TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN sender_id TEXT NOT NULL DEFAULT '';
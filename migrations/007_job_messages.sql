@@ -0,0 +1,12 @@
+ALTER TABLE jobs ADD COLUMN reply_to_message_id TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS job_messages (
+    platform TEXT NOT NULL,
+    chat_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (platform, chat_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_messages_job_id ON job_messages(job_id);